@@ -0,0 +1,216 @@
+// Package amf writes all the materials of an IRMF model
+// into a single AMF (Additive Manufacturing File Format, ISO/ASTM 52915) file.
+package amf
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	voxels.Slicer
+	IRMF() *irmf.IRMF
+}
+
+// Options controls the AMF output.
+type Options struct {
+	// Mesh controls how each material is meshed (may be nil).
+	Mesh *voxels.Options
+	// Compress writes the AMF document into a ZIP archive
+	// (which keeps the .amf extension, as allowed by the specification).
+	Compress bool
+}
+
+// Slice slices an IRMF model into a single AMF file containing
+// one volume per material, using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single AMF file containing
+// one volume per material. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var meshes []*mesh.Mesh
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		m, err := voxels.Mesh(slicer, materialNum, opts.Mesh)
+		if err != nil {
+			return fmt.Errorf("material %v: %v", materialNum, err)
+		}
+		log.Printf("Meshed %v triangles for material %v (%v)", len(m.Tris), materialNum, slicer.MaterialName(materialNum))
+		meshes = append(meshes, m)
+	}
+
+	filename := baseFilename + ".amf"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+
+	if err := write(f, filepath.Base(filename), slicer, meshes, opts.Compress); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close AMF file: %v", err)
+	}
+	return nil
+}
+
+// write writes the AMF document for the meshes (one per material) to w,
+// optionally compressed into a ZIP archive holding a single entry named name.
+func write(w io.Writer, name string, slicer Slicer, meshes []*mesh.Mesh, compress bool) error {
+	var zw *zip.Writer
+	if compress {
+		zw = zip.NewWriter(w)
+		var err error
+		if w, err = zw.Create(name); err != nil {
+			return fmt.Errorf("zip.Create(%q): %v", name, err)
+		}
+	}
+
+	aw := &amfWriter{w: bufio.NewWriter(w)}
+	aw.document(slicer, meshes)
+	if aw.err != nil {
+		return fmt.Errorf("write AMF: %v", aw.err)
+	}
+	if err := aw.w.Flush(); err != nil {
+		return fmt.Errorf("write AMF: %v", err)
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("Unable to close ZIP writer: %v", err)
+		}
+	}
+	return nil
+}
+
+// amfWriter streams the AMF XML document.
+type amfWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (aw *amfWriter) printf(format string, args ...interface{}) {
+	if aw.err != nil {
+		return
+	}
+	_, aw.err = fmt.Fprintf(aw.w, format, args...)
+}
+
+// metadata writes a metadata element if value is not empty.
+func (aw *amfWriter) metadata(indent, typ, value string) {
+	if value != "" {
+		aw.printf("%v<metadata type=%q>%v</metadata>\n", indent, typ, textfmt.XMLEscape(value))
+	}
+}
+
+func (aw *amfWriter) document(slicer Slicer, meshes []*mesh.Mesh) {
+	i := slicer.IRMF()
+	aw.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	u, scale := unit(i.Units)
+	aw.printf("<amf unit=%q version=\"1.1\">\n", u)
+	aw.metadata("  ", "name", i.Title)
+	aw.metadata("  ", "author", i.Author)
+	aw.metadata("  ", "copyright", i.Copyright)
+	aw.metadata("  ", "date", i.Date)
+	aw.metadata("  ", "description", i.Notes)
+	aw.metadata("  ", "cad", "irmf-slicer")
+
+	// Material IDs are the (1-based) IRMF material numbers;
+	// ID 0 is reserved by the specification for the void.
+	for n := 1; n <= slicer.NumMaterials(); n++ {
+		aw.printf("  <material id=\"%v\">\n", n)
+		aw.metadata("    ", "name", slicer.MaterialName(n))
+		aw.printf("  </material>\n")
+	}
+
+	// All the volumes share a single vertex list, so vertices on the
+	// interface between two materials are written once.
+	verts, tris := share(meshes)
+	aw.printf("  <object id=\"0\">\n")
+	aw.metadata("    ", "name", i.Title)
+	aw.printf("    <mesh>\n      <vertices>\n")
+	for _, v := range verts {
+		aw.printf("        <vertex><coordinates><x>%v</x><y>%v</y><z>%v</z></coordinates></vertex>\n", textfmt.Float32(v[0]*scale), textfmt.Float32(v[1]*scale), textfmt.Float32(v[2]*scale))
+	}
+	aw.printf("      </vertices>\n")
+	for n, ts := range tris {
+		if len(ts) == 0 {
+			continue
+		}
+		aw.printf("      <volume materialid=\"%v\">\n", n+1)
+		aw.metadata("        ", "name", slicer.MaterialName(n+1))
+		for _, t := range ts {
+			aw.printf("        <triangle><v1>%v</v1><v2>%v</v2><v3>%v</v3></triangle>\n", t[0], t[1], t[2])
+		}
+		aw.printf("      </volume>\n")
+	}
+	aw.printf("    </mesh>\n  </object>\n</amf>\n")
+}
+
+// share merges the vertices of the meshes into a single list and
+// returns the triangles of each mesh indexed into that list.
+// Triangles that collapse when their vertices are merged are dropped.
+func share(meshes []*mesh.Mesh) ([][3]float32, [][][3]int) {
+	var verts [][3]float32
+	index := map[[3]float32]int{}
+	tris := make([][][3]int, len(meshes))
+	for n, m := range meshes {
+		remap := make([]int, len(m.Verts))
+		for i, v := range m.Verts {
+			j, ok := index[v]
+			if !ok {
+				j = len(verts)
+				index[v] = j
+				verts = append(verts, v)
+			}
+			remap[i] = j
+		}
+		for _, t := range m.Tris {
+			t = [3]int{remap[t[0]], remap[t[1]], remap[t[2]]}
+			if t[0] == t[1] || t[1] == t[2] || t[2] == t[0] {
+				continue
+			}
+			tris[n] = append(tris[n], t)
+		}
+	}
+	return verts, tris
+}
+
+// unit returns the AMF unit corresponding to the IRMF units, and the
+// scale that converts coordinates to it. AMF has no centimeter unit,
+// so centimeters are converted to millimeters.
+func unit(units string) (string, float32) {
+	switch strings.ToLower(units) {
+	case "um", "micron", "microns", "micrometer":
+		return "micron", 1
+	case "cm", "centimeter":
+		return "millimeter", 10
+	case "in", "inch", "inches":
+		return "inch", 1
+	case "ft", "foot", "feet":
+		return "feet", 1
+	case "m", "meter":
+		return "meter", 1
+	}
+	return "millimeter", 1
+}
@@ -0,0 +1,164 @@
+package amf
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// fakeSlicer provides the model header and material names only.
+type fakeSlicer struct {
+	voxels.Slicer
+	irmf *irmf.IRMF
+}
+
+func (s *fakeSlicer) IRMF() *irmf.IRMF                    { return s.irmf }
+func (s *fakeSlicer) NumMaterials() int                   { return len(s.irmf.Materials) }
+func (s *fakeSlicer) MaterialName(materialNum int) string { return s.irmf.Materials[materialNum-1] }
+
+// cube returns a unit cube (12 triangles) with its minimum corner at x.
+func cube(x float32) *mesh.Mesh {
+	m := &mesh.Mesh{}
+	for i := 0; i < 8; i++ {
+		m.Verts = append(m.Verts, [3]float32{x + float32(i&1), float32(i >> 1 & 1), float32(i >> 2 & 1)})
+	}
+	m.Tris = [][3]int{
+		{0, 2, 1}, {1, 2, 3}, {4, 5, 6}, {5, 7, 6}, // -Z, +Z
+		{0, 1, 4}, {1, 5, 4}, {2, 6, 3}, {3, 6, 7}, // -Y, +Y
+		{0, 4, 2}, {2, 4, 6}, {1, 3, 5}, {3, 7, 5}, // -X, +X
+	}
+	return m
+}
+
+// document is the subset of the AMF format checked by the test.
+type document struct {
+	XMLName  xml.Name `xml:"amf"`
+	Unit     string   `xml:"unit,attr"`
+	Metadata []struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"metadata"`
+	Materials []struct {
+		ID int `xml:"id,attr"`
+	} `xml:"material"`
+	Vertices []struct {
+		X float32 `xml:"coordinates>x"`
+		Y float32 `xml:"coordinates>y"`
+		Z float32 `xml:"coordinates>z"`
+	} `xml:"object>mesh>vertices>vertex"`
+	Volumes []struct {
+		MaterialID int `xml:"materialid,attr"`
+		Triangles  []struct {
+			V1 int `xml:"v1"`
+			V2 int `xml:"v2"`
+			V3 int `xml:"v3"`
+		} `xml:"triangle"`
+	} `xml:"object>mesh>volume"`
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		units    string
+		compress bool
+		// wantMax is the maximum X coordinate (2 model units).
+		wantMax float32
+	}{
+		{units: "mm", wantMax: 2},
+		{units: "mm", compress: true, wantMax: 2},
+		{units: "cm", wantMax: 20}, // AMF has no centimeter unit
+	}
+	// The cubes of materials 1 and 3 share a face (4 vertices).
+	meshes := []*mesh.Mesh{cube(0), {}, cube(1)}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v,compress=%v", tt.units, tt.compress), func(t *testing.T) {
+			slicer := &fakeSlicer{irmf: &irmf.IRMF{
+				Title:     "Two <cubes>",
+				Author:    "Test Author",
+				Materials: []string{"PLA", "empty", "TPU"},
+				Units:     tt.units,
+			}}
+			var buf bytes.Buffer
+			if err := write(&buf, "test.amf", slicer, meshes, tt.compress); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			data := buf.Bytes()
+			if tt.compress {
+				zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatalf("zip.NewReader: %v", err)
+				}
+				if len(zr.File) != 1 || zr.File[0].Name != "test.amf" {
+					t.Fatalf("zip holds %v files, want only test.amf", len(zr.File))
+				}
+				r, err := zr.File[0].Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer r.Close()
+				if data, err = ioutil.ReadAll(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var doc document
+			if err := xml.Unmarshal(data, &doc); err != nil {
+				t.Fatalf("xml.Unmarshal: %v", err)
+			}
+			if doc.Unit != "millimeter" {
+				t.Errorf("unit = %q, want millimeter", doc.Unit)
+			}
+			metadata := map[string]string{}
+			for _, md := range doc.Metadata {
+				metadata[md.Type] = md.Value
+			}
+			if metadata["name"] != "Two <cubes>" || metadata["author"] != "Test Author" {
+				t.Errorf("metadata = %v, want name and author", metadata)
+			}
+			if len(doc.Materials) != 3 {
+				t.Errorf("got %v materials, want 3", len(doc.Materials))
+			}
+			if got, want := len(doc.Vertices), 12; got != want {
+				t.Errorf("got %v shared vertices, want %v", got, want)
+			}
+			var max float32
+			for _, v := range doc.Vertices {
+				if v.X > max {
+					max = v.X
+				}
+			}
+			if max != tt.wantMax {
+				t.Errorf("max X = %v millimeters, want %v", max, tt.wantMax)
+			}
+
+			// The empty material has no volume.
+			if len(doc.Volumes) != 2 {
+				t.Fatalf("got %v volumes, want 2", len(doc.Volumes))
+			}
+			for i, want := range []int{1, 3} {
+				vol := doc.Volumes[i]
+				if vol.MaterialID != want {
+					t.Errorf("volume %v materialid = %v, want %v", i, vol.MaterialID, want)
+				}
+				if len(vol.Triangles) != 12 {
+					t.Errorf("volume %v has %v triangles, want 12", i, len(vol.Triangles))
+				}
+				for _, tri := range vol.Triangles {
+					for _, v := range []int{tri.V1, tri.V2, tri.V3} {
+						if v < 0 || v >= len(doc.Vertices) {
+							t.Fatalf("volume %v: vertex index %v out of range", i, v)
+						}
+					}
+				}
+			}
+		})
+	}
+}
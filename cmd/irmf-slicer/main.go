@@ -1,11 +1,13 @@
 // irmf-slicer slices one or more IRMF shaders into voxel image slices
 // at the requested resolution.
 //
-// It then writes a ZIP of the slices or an STL file for each of
-// the materials, or both.
+// It then writes a ZIP of the slices, a TIFF stack or an STL file for each
+// of the materials, or a single 3MF, AMF, GLB, INP, NRRD, OBJ, PLY,
+// schematic, VDB, VOX, VTI or VTU file containing all the materials.
 //
 // By default, irmf-slicer tests IRMF shader compilation only.
-// To generate output, at least one of -stl or -zip must be supplied.
+// To generate output, at least one of the output options
+// (such as -stl or -zip) must be supplied.
 //
 // See https://github.com/gmlewis/irmf for more information about IRMF.
 package main
@@ -16,9 +18,21 @@ import (
 	"log"
 	"strings"
 
+	"github.com/gmlewis/irmf-slicer/v3/amf"
 	"github.com/gmlewis/irmf-slicer/v3/binvox"
+	"github.com/gmlewis/irmf-slicer/v3/gltf"
+	"github.com/gmlewis/irmf-slicer/v3/hexmesh"
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+	"github.com/gmlewis/irmf-slicer/v3/obj"
 	"github.com/gmlewis/irmf-slicer/v3/photon"
+	"github.com/gmlewis/irmf-slicer/v3/ply"
+	"github.com/gmlewis/irmf-slicer/v3/schem"
+	"github.com/gmlewis/irmf-slicer/v3/threemf"
+	"github.com/gmlewis/irmf-slicer/v3/tiff"
+	"github.com/gmlewis/irmf-slicer/v3/vdb"
+	"github.com/gmlewis/irmf-slicer/v3/volume"
+	"github.com/gmlewis/irmf-slicer/v3/vox"
 	"github.com/gmlewis/irmf-slicer/v3/voxels"
 	"github.com/gmlewis/irmf-slicer/v3/zipper"
 )
@@ -26,21 +40,63 @@ import (
 const defaultRes = 42
 
 var (
-	microns = flag.Float64("res", 0.0, "Resolution in microns (default is 42.0)")
-	view    = flag.Bool("view", false, "Render slicing to window")
+	amfZip       = flag.Bool("amfzip", false, "With -amf, compress the AMF file (as a ZIP archive, keeping the .amf extension)")
+	ascii        = flag.Bool("ascii", false, "With -stl, write ASCII STL files instead of binary STL files")
+	blocks       = flag.String("blocks", "", "With -schem, override material blocks, e.g. '1=stone,2=oak_stairs[facing=north]' (by default, the concrete closest to each material's color)")
+	colors       = flag.String("colors", "", "With -3mf, -gltf, -obj, -ply, -schem or -vox, override material colors, e.g. '1=#FF0000,3=blue' (by default, colors are derived from the material names)")
+	fit          = flag.Bool("fit", false, "Shrink each model's MBB to fit its occupied material (found by a coarse pre-pass) before slicing")
+	fitFactor    = flag.Int("fitfactor", 8, "Coarse pre-pass factor for -fit (1/N of the resolution)")
+	fitMargin    = flag.Float64("fitmargin", 0.0, "Extra margin (in model units) added around the fitted MBB for -fit")
+	fitIRMF      = flag.Bool("fitirmf", false, "With -fit, also write the model with its corrected MBB to a '-fit.irmf' file")
+	gltfQuant    = flag.Bool("gltfquantize", false, "With -gltf, store positions as 16-bit integers (KHR_mesh_quantization) to keep files small")
+	hexCoarsen   = flag.Int("hexcoarsen", 1, "With -inp or -vtu, merge blocks of NxNxN voxels into each hexahedral element")
+	labels       = flag.Bool("labels", false, "With -vti or -nrrd, write a single label volume (holding the number of the material in each voxel) instead of one volume per material")
+	maxError     = flag.Float64("maxerr", 0.0, "With any mesh output, stop simplifying each mesh once the next edge collapse would exceed this quadric error threshold (unitless; the Hausdorff deviation is logged)")
+	maxSize      = flag.Int64("maxsize", 0, "With any mesh output, simplify each mesh so that it would fit in a binary STL file of at most this many bytes")
+	maxTris      = flag.Int("maxtris", 0, "With any mesh output, simplify each mesh to at most this many triangles")
+	mesher       = flag.String("mesher", "mc", "Mesher used for mesh outputs (-stl, -3mf, -amf, -gltf, -obj, -ply): mc (marching cubes), surfacenets, or dc (dual contouring)")
+	microns      = flag.Float64("res", 0.0, "Resolution in microns (default is 42.0)")
+	nrrdDetached = flag.Bool("nrrddetached", false, "With -nrrd, write a '.nhdr' header and a separate raw data file instead of a single '.nrrd' file")
+	repair       = flag.Bool("repair", false, "With any mesh output, repair degenerate and duplicate triangles and inconsistent orientation (implies -validate)")
+	skipEmpty    = flag.Int("skip", 0, "Skip rendering empty regions found by a coarse pre-pass at 1/N of the resolution (N>1)")
+	smooth       = flag.Int("smooth", 0, "With any mesh output, apply N passes of Taubin smoothing to each mesh to remove voxel stair-stepping")
+	smoothLap    = flag.Bool("smoothlaplacian", false, "With -smooth, use Laplacian instead of Taubin smoothing")
+	smoothMax    = flag.Float64("smoothmax", 0.5, "With -smooth, the maximum distance (in voxels) that smoothing may move any vertex (0 for no limit)")
+	smoothVol    = flag.Bool("smoothvolume", true, "With -smooth, preserve the volume of each body")
+	split        = flag.Bool("split", false, "With -stl, write each disconnected body to its own numbered STL file, plus a '-bodies.json' index")
+	supersample  = flag.Int("ss", 1, "Supersample each voxel on an NxN grid within each slice to produce anti-aliased (fractional coverage) slices")
+	supersampleZ = flag.Int("ssz", 1, "Supersample each voxel at M sub-layer depths within each slice (used with -ss)")
+	tiffBits     = flag.Int("tiffbits", 8, "With -tiff, the number of bits per pixel: 1 (voxels that are at least half occupied), 8 or 16")
+	tileSize     = flag.Int("tile", 2048, "Maximum render window size in pixels; larger slices are rendered in tiles")
+	validate     = flag.Bool("validate", false, "With any mesh output, validate each mesh and log a summary (with -stl, also write a JSON report next to each STL file)")
+	view         = flag.Bool("view", false, "Render slicing to window")
+	volCompress  = flag.Bool("volcompress", true, "With -vti or -nrrd, compress the voxel data (zlib for VTI, gzip for NRRD)")
 
+	write3MF    = flag.Bool("3mf", false, "Write a single 3MF file containing one mesh object per material")
+	writeAMF    = flag.Bool("amf", false, "Write a single AMF file containing one volume per material")
 	writeBinvox = flag.Bool("binvox", false, "Write binvox files, one per material")
 	writeDLP    = flag.Bool("dlp", false, "Write ChiTuBox .cbddlp files (same as AnyCubic .photon), one per material (default resolution is: X:47.25,Y:47.25,Z:50 microns)")
+	writeGLTF   = flag.Bool("gltf", false, "Write a single binary glTF (.glb) file with one mesh primitive and PBR material per material")
+	writeINP    = flag.Bool("inp", false, "Write a single Abaqus input (.inp) file with one hexahedral element per voxel, an element set per material and node sets on the MBB faces")
+	writeNRRD   = flag.Bool("nrrd", false, "Write a single NRRD volume file with one volume per material (or a label volume with -labels)")
+	writeOBJ    = flag.Bool("obj", false, "Write a single OBJ file (plus its .mtl file) with one group per material")
+	writePLY    = flag.Bool("ply", false, "Write a single binary PLY file with vertices colored by material")
+	writeSchem  = flag.Bool("schem", false, "Write a single Sponge schematic (.schem) file for Minecraft with one block per voxel (default resolution is 1000 microns)")
 	writeSTL    = flag.Bool("stl", false, "Write stl files, one per material")
 	writeSVX    = flag.Bool("svx", false, "Write slices to svx voxel files, one per material (default resolution is 42 microns)")
+	writeTIFF   = flag.Bool("tiff", false, "Write slices to multi-page TIFF files (one page per slice), one per material")
+	writeVDB    = flag.Bool("vdb", false, "Write a single OpenVDB file with one sparse grid per material (float density grids when supersampling, otherwise bool grids)")
+	writeVTI    = flag.Bool("vti", false, "Write a single VTK image data (.vti) file with one scalar array per material (or a label array with -labels)")
+	writeVTU    = flag.Bool("vtu", false, "Write a single VTK unstructured grid (.vtu) file with one hexahedral cell per voxel and the material of each cell")
+	writeVOX    = flag.Bool("vox", false, "Write a single MagicaVoxel (.vox) file in which each material is a palette entry")
 	writeZip    = flag.Bool("zip", false, "Write slices to zip files, one per material (default resolution is X:65,Y:60,Z:30 microns)")
 )
 
 func main() {
 	flag.Parse()
 
-	if !*writeBinvox && !*writeDLP && !*writeSTL && !*writeSVX && !*writeZip {
-		log.Printf("-binvox, -dlp, -stl, -svx, or -zip must be supplied to generate output. Testing IRMF shader compilation only.")
+	if !*write3MF && !*writeAMF && !*writeBinvox && !*writeDLP && !*writeGLTF && !*writeINP && !*writeNRRD && !*writeOBJ && !*writePLY && !*writeSchem && !*writeSTL && !*writeSVX && !*writeTIFF && !*writeVDB && !*writeVOX && !*writeVTI && !*writeVTU && !*writeZip {
+		log.Printf("-3mf, -amf, -binvox, -dlp, -gltf, -inp, -nrrd, -obj, -ply, -schem, -stl, -svx, -tiff, -vdb, -vox, -vti, -vtu, or -zip must be supplied to generate output. Testing IRMF shader compilation only.")
 	}
 
 	var xRes, yRes, zRes float32
@@ -49,6 +105,8 @@ func main() {
 		xRes, yRes, zRes = 47.25, 47.25, 50.0
 	case *writeZip && *microns == 0.0: // use 65, 60, 30 microns
 		xRes, yRes, zRes = 65.0, 60.0, 30.0
+	case *writeSchem && *microns == 0.0: // one block per millimeter
+		xRes, yRes, zRes = 1000.0, 1000.0, 1000.0
 	case *microns == 0.0: // use defaultRes
 		xRes, yRes, zRes = defaultRes, defaultRes, defaultRes
 	default:
@@ -56,8 +114,39 @@ func main() {
 	}
 	log.Printf("Resolution in microns: X: %v, Y: %v, Z: %v", xRes, yRes, zRes)
 
+	meshAlgorithm, err := voxels.ParseMesher(*mesher)
+	check("-mesher: %v", err)
+	meshOpts := &voxels.Options{
+		Mesher:                meshAlgorithm,
+		ASCII:                 *ascii,
+		MaxTriangles:          *maxTris,
+		MaxBytes:              *maxSize,
+		MaxError:              *maxError,
+		Smooth:                *smooth,
+		SmoothLaplacian:       *smoothLap,
+		SmoothMaxDisplacement: *smoothMax,
+		SmoothPreserveVolume:  *smoothVol,
+		SplitBodies:           *split,
+		Validate:              *validate,
+		Repair:                *repair,
+	}
+
+	hexOpts := &hexmesh.Options{Coarsen: *hexCoarsen}
+	volOpts := &volume.Options{Labels: *labels, Compress: *volCompress, Detached: *nrrdDetached}
+
+	materialColors, err := matcolor.ParseOverrides(*colors)
+	check("-colors: %v", err)
+	materialBlocks, err := schem.ParseBlocks(*blocks)
+	check("-blocks: %v", err)
+
 	slicer := irmf.Init(*view, xRes, yRes, zRes)
 	defer slicer.Close()
+	slicer.SetMaxTileSize(*tileSize, *tileSize)
+	slicer.SetSupersampling(*supersample, *supersampleZ)
+	slicer.SetSkipEmpty(*skipEmpty)
+	if *supersample > 1 || *supersampleZ > 1 {
+		log.Printf("Supersampling each voxel with %vx%vx%v samples", *supersample, *supersample, *supersampleZ)
+	}
 
 	for _, arg := range flag.Args() {
 		if !strings.HasSuffix(arg, ".irmf") {
@@ -74,6 +163,35 @@ func main() {
 
 		baseName := strings.TrimSuffix(arg, ".irmf")
 
+		if *fit {
+			min, max := slicer.MBB()
+			log.Printf("Fitting MBB=(%v,%v,%v)-(%v,%v,%v)...", min[0], min[1], min[2], max[0], max[1], max[2])
+			min, max, err = slicer.Fit(*fitFactor, float32(*fitMargin))
+			check("Fit: %v", err)
+			log.Printf("Fitted MBB=(%v,%v,%v)-(%v,%v,%v)", min[0], min[1], min[2], max[0], max[1], max[2])
+
+			if *fitIRMF {
+				src, err := slicer.IRMF().Format()
+				check("Format: %v", err)
+				fitName := baseName + "-fit.irmf"
+				log.Printf("Writing: %v", fitName)
+				err = ioutil.WriteFile(fitName, []byte(src), 0644)
+				check("WriteFile: %v", err)
+			}
+		}
+
+		if *write3MF {
+			log.Printf("Slicing %v materials into a single 3MF file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = threemf.SliceWithOptions(baseName, slicer, &threemf.Options{Mesh: meshOpts, Colors: materialColors})
+			check("threemf.SliceWithOptions: %v", err)
+		}
+
+		if *writeAMF {
+			log.Printf("Slicing %v materials into a single AMF file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = amf.SliceWithOptions(baseName, slicer, &amf.Options{Mesh: meshOpts, Compress: *amfZip})
+			check("amf.SliceWithOptions: %v", err)
+		}
+
 		if *writeBinvox {
 			log.Printf("Slicing %v materials into separate binvox files (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
 			err = binvox.Slice(baseName, slicer)
@@ -86,10 +204,46 @@ func main() {
 			check("photon.Slice: %v", err)
 		}
 
+		if *writeGLTF {
+			log.Printf("Slicing %v materials into a single GLB file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = gltf.SliceWithOptions(baseName, slicer, &gltf.Options{Mesh: meshOpts, Colors: materialColors, Quantize: *gltfQuant})
+			check("gltf.SliceWithOptions: %v", err)
+		}
+
+		if *writeINP {
+			log.Printf("Slicing %v materials into a single Abaqus input file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = hexmesh.INPSlice(baseName, slicer, hexOpts)
+			check("hexmesh.INPSlice: %v", err)
+		}
+
+		if *writeNRRD {
+			log.Printf("Slicing %v materials into a single NRRD file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = volume.NRRDSlice(baseName, slicer, volOpts)
+			check("volume.NRRDSlice: %v", err)
+		}
+
+		if *writeOBJ {
+			log.Printf("Slicing %v materials into a single OBJ file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = obj.SliceWithOptions(baseName, slicer, &obj.Options{Mesh: meshOpts, Colors: materialColors})
+			check("obj.SliceWithOptions: %v", err)
+		}
+
+		if *writePLY {
+			log.Printf("Slicing %v materials into a single PLY file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = ply.SliceWithOptions(baseName, slicer, &ply.Options{Mesh: meshOpts, Colors: materialColors})
+			check("ply.SliceWithOptions: %v", err)
+		}
+
+		if *writeSchem {
+			log.Printf("Slicing %v materials into a single schematic file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = schem.SliceWithOptions(baseName, slicer, &schem.Options{Blocks: materialBlocks, Colors: materialColors})
+			check("schem.SliceWithOptions: %v", err)
+		}
+
 		if *writeSTL {
 			log.Printf("Slicing %v materials into separate STL files (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
-			err = voxels.Slice(baseName, slicer)
-			check("voxels.Slice: %v", err)
+			err = voxels.SliceWithOptions(baseName, slicer, meshOpts)
+			check("voxels.SliceWithOptions: %v", err)
 		}
 
 		if *writeSVX {
@@ -98,6 +252,36 @@ func main() {
 			check("zipper.SVXSlice: %v", err)
 		}
 
+		if *writeTIFF {
+			log.Printf("Slicing %v materials into separate TIFF files (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = tiff.SliceWithOptions(baseName, slicer, &tiff.Options{BitDepth: *tiffBits})
+			check("tiff.SliceWithOptions: %v", err)
+		}
+
+		if *writeVDB {
+			log.Printf("Slicing %v materials into a single VDB file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = vdb.SliceWithOptions(baseName, slicer, &vdb.Options{Density: *supersample > 1 || *supersampleZ > 1})
+			check("vdb.SliceWithOptions: %v", err)
+		}
+
+		if *writeVOX {
+			log.Printf("Slicing %v materials into a single VOX file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = vox.SliceWithOptions(baseName, slicer, &vox.Options{Colors: materialColors})
+			check("vox.SliceWithOptions: %v", err)
+		}
+
+		if *writeVTI {
+			log.Printf("Slicing %v materials into a single VTI file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = volume.VTISlice(baseName, slicer, volOpts)
+			check("volume.VTISlice: %v", err)
+		}
+
+		if *writeVTU {
+			log.Printf("Slicing %v materials into a single VTU file (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
+			err = hexmesh.VTUSlice(baseName, slicer, hexOpts)
+			check("hexmesh.VTUSlice: %v", err)
+		}
+
 		if *writeZip {
 			log.Printf("Slicing %v materials into separate ZIP files (%v slices each)...", slicer.NumMaterials(), slicer.NumZSlices())
 			err = zipper.Slice(baseName, slicer)
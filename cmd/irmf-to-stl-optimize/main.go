@@ -1,130 +1,258 @@
-// irmf-to-stl-optimize is a program to generate an STL file that
-// is as close to `maxSize` as possible without exceeding it.
+// irmf-to-stl-optimize is a program to generate STL files that
+// are as close to `maxSize` as possible without exceeding it.
 //
-// This program uses:
-//   * [irmf-slicer](https://github.com/gmlewis/irmf-slicer)
-//   * [marching-cubes](https://github.com/gmlewis/stldice/tree/master/cmd/marching-cubes)
-// to run.
+// For each material of each IRMF model, it searches for the finest
+// slicing resolution (in microns) whose STL file fits within the size
+// budget. Each candidate STL file is written to a temporary directory
+// next to the model, and the best one is moved into place.
+// A JSON report of every tried resolution and resulting file size
+// is written to `<model>-optimize.json`.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
-	"regexp"
+	"path/filepath"
+	"strconv"
 	"strings"
-)
 
-const (
-	outFile = "outfile-stats.stl"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
 )
 
 var (
-	fileRE = regexp.MustCompile(`Writing: (\S+)`)
-
+	matMax  = flag.String("matmax", "", "Per-material maximum STL file sizes overriding -max (e.g. '1=20000000,3=5000000')")
 	maxSize = flag.Int64("max", 50000000, "Maximum STL file size")
-	start   = flag.Int("start", 400, "Starting resolution")
+	mesher  = flag.String("mesher", "mc", "Mesher: mc (marching cubes), surfacenets, or dc (dual contouring)")
+	minRes  = flag.Int("minres", 1, "Finest resolution (in microns) to try")
+	start   = flag.Int("start", 400, "Starting resolution (in microns)")
 )
 
+// Report is the JSON report of the search for one IRMF model.
+type Report struct {
+	IRMF      string            `json:"irmf"`
+	Mesher    string            `json:"mesher"`
+	Materials []*MaterialReport `json:"materials"`
+}
+
+// MaterialReport is the result of the search for one material.
+type MaterialReport struct {
+	Material int     `json:"material"`
+	Name     string  `json:"name"`
+	MaxSize  int64   `json:"maxSize"`
+	Trials   []Trial `json:"trials"`
+	// BestRes is the finest resolution (in microns) that fits,
+	// or zero if none was found.
+	BestRes int    `json:"bestRes,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	STL     string `json:"stl,omitempty"`
+}
+
+// Trial is a single slicing of a material at a given resolution.
+type Trial struct {
+	Res       int   `json:"res"` // microns
+	Size      int64 `json:"size"`
+	Triangles int   `json:"triangles"`
+	Fits      bool  `json:"fits"`
+}
+
 func main() {
 	flag.Parse()
 
-	var pts []string
+	meshAlgorithm, err := voxels.ParseMesher(*mesher)
+	check("-mesher: %v", err)
+	opts := &voxels.Options{Mesher: meshAlgorithm}
+
+	targets, err := parseTargets(*matMax)
+	check("-matmax: %v", err)
+
+	res := float32(*start)
+	slicer := irmf.Init(false, res, res, res)
+	defer slicer.Close()
+
 	for _, arg := range flag.Args() {
+		if !strings.HasSuffix(arg, ".irmf") {
+			log.Printf("Skipping non-IRMF file %q", arg)
+			continue
+		}
+
+		log.Printf("Processing IRMF shader %q...", arg)
+		buf, err := ioutil.ReadFile(arg)
+		check("ReadFile: %v", err)
+		err = slicer.NewModel(buf)
+		check("%v: %v", arg, err)
+
+		baseName := strings.TrimSuffix(arg, ".irmf")
+		tmpDir, err := ioutil.TempDir(filepath.Dir(arg), ".irmf-to-stl-optimize-")
+		check("TempDir: %v", err)
 
-		res := *start
-		stepSize := res / 2
-
-		visited := map[int]int64{}
-		var hitMax bool
-		for {
-			var largestSTLSize int64
-			var smallestValidRes int
-			if v, ok := visited[res]; ok {
-				largestSTLSize = v
-				log.Printf("\n\nAlready processed res=%v, skipping...", res)
-			} else {
-				args := []string{"irmf-slicer", "-binvox", "-res", fmt.Sprintf("%v", res), arg}
-				cmd := exec.Command(args[0], args[1:]...)
-				log.Printf("Running: %v", strings.Join(args, " "))
-				buf, err := cmd.CombinedOutput()
-				check("Unable to run command %v : %s\n%v", strings.Join(args, " "), buf, err)
-				log.Printf("%s\n", buf)
-
-				filenames := fileRE.FindAllStringSubmatch(string(buf), -1)
-				for _, filename := range filenames {
-					fi, err := os.Stat(filename[1])
-					check("os.Stat: %v", err)
-					log.Printf("Found: %v - size: %v", filename[1], fi.Size())
-
-					// Now, convert the binvox to stl:
-					args := []string{"marching-cubes", filename[1], outFile}
-					cmd := exec.Command(args[0], args[1:]...)
-					log.Printf("Running: %v", strings.Join(args, " "))
-					buf, err := cmd.CombinedOutput()
-					check("Unable to run command %v : %s\n%v", strings.Join(args, " "), buf, err)
-					log.Printf("%s\n", buf)
-					check("os.Remove: %v", os.Remove(filename[1]))
-
-					fi, err = os.Stat(outFile)
-					check("os.Stat: %v", err)
-					log.Printf("Found: %v - size: %v", outFile, fi.Size())
-
-					if fi.Size() > largestSTLSize {
-						largestSTLSize = fi.Size()
-						visited[res] = largestSTLSize
-					}
-
-					if fi.Size() >= *maxSize {
-						check("os.Remove: %v", os.Remove(outFile))
-					} else if smallestValidRes == 0 || res <= smallestValidRes {
-						// Move file to destination
-						newFilename := fmt.Sprintf("%v.stl", strings.TrimSuffix(filename[1], ".binvox"))
-						log.Printf("Moving %v to %v (at res=%v)", outFile, newFilename, res)
-						err := os.Rename(outFile, newFilename)
-						check("os.Rename: %v", err)
-						smallestValidRes = res
-					}
-				}
+		report := &Report{IRMF: arg, Mesher: meshAlgorithm.String()}
+		for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+			mr := &MaterialReport{
+				Material: materialNum,
+				Name:     slicer.MaterialName(materialNum),
+				MaxSize:  *maxSize,
 			}
+			if v, ok := targets[materialNum]; ok {
+				mr.MaxSize = v
+			}
+			report.Materials = append(report.Materials, mr)
 
-			if largestSTLSize >= *maxSize {
-				if stepSize == 1 {
-					hitMax = true
-				}
-				res += stepSize
-				if smallestValidRes != 0 {
-					stepSize /= 2
-					if stepSize < 1 {
-						stepSize = 1
-					}
-				}
-				log.Printf("\n\nRaised res up to %v, stepSize=%v", res, stepSize)
-			} else {
-				if hitMax {
-					break
-				} else {
-					res -= stepSize
-					stepSize /= 2
-					if stepSize < 1 {
-						stepSize = 1
-					}
-					if res < 1 {
-						res = 1
-						stepSize = 1
-					}
-					log.Printf("\n\nDropped res down to %v, stepSize=%v", res, stepSize)
-				}
+			err := optimize(slicer, mr, baseName, tmpDir, opts)
+			if err != nil {
+				os.RemoveAll(tmpDir)
 			}
+			check("Material %v: %v", materialNum, err)
+		}
+		check("RemoveAll: %v", os.RemoveAll(tmpDir))
+
+		reportName := baseName + "-optimize.json"
+		buf, err = json.MarshalIndent(report, "", "  ")
+		check("json.MarshalIndent: %v", err)
+		log.Printf("Writing: %v", reportName)
+		err = ioutil.WriteFile(reportName, append(buf, '\n'), 0644)
+		check("WriteFile: %v", err)
+
+		for _, mr := range report.Materials {
+			if mr.BestRes == 0 {
+				fmt.Printf("%v: material %v (%v): no resolution fits in %v bytes\n", arg, mr.Material, mr.Name, mr.MaxSize)
+				continue
+			}
+			fmt.Printf("%v: material %v (%v): res=%v size=%v %v\n", arg, mr.Material, mr.Name, mr.BestRes, mr.Size, mr.STL)
 		}
 	}
 
-	fmt.Printf("%v\n", strings.Join(pts, "\n"))
 	log.Printf("Done.")
 }
 
+// optimize searches for the finest resolution for which the material's
+// STL file fits in mr.MaxSize bytes, and moves that file into place.
+//
+// File size decreases as the resolution (voxel size) increases, so the
+// search doubles or halves the resolution from -start until the best
+// resolution is bracketed, then bisects the bracket.
+func optimize(slicer *irmf.Slicer, mr *MaterialReport, baseName, tmpDir string, opts *voxels.Options) error {
+	materialName := strings.ReplaceAll(mr.Name, " ", "-")
+	stlName := fmt.Sprintf("%v-mat%02d-%v.stl", baseName, mr.Material, materialName)
+
+	try := func(res int) (bool, error) {
+		slicer.SetResolution(float32(res), float32(res), float32(res))
+		tmpName := filepath.Join(tmpDir, fmt.Sprintf("mat%02d-res%v.stl", mr.Material, res))
+		log.Printf("Material %v: trying res=%v", mr.Material, res)
+		numTris, err := voxels.SliceMaterial(tmpName, slicer, mr.Material, opts)
+		if err != nil {
+			return false, err
+		}
+		fi, err := os.Stat(tmpName)
+		if err != nil {
+			return false, err
+		}
+
+		t := Trial{Res: res, Size: fi.Size(), Triangles: numTris, Fits: fi.Size() <= mr.MaxSize}
+		mr.Trials = append(mr.Trials, t)
+		log.Printf("Material %v: res=%v size=%v fits=%v", mr.Material, res, t.Size, t.Fits)
+		if !t.Fits {
+			return false, os.Remove(tmpName)
+		}
+		if mr.BestRes != 0 {
+			if err := os.Remove(mr.STL); err != nil {
+				return false, err
+			}
+		}
+		mr.BestRes, mr.Size, mr.STL = res, t.Size, tmpName
+		return true, nil
+	}
+
+	// Bracket the best resolution between tooFine (too large a file)
+	// and fits (the finest resolution known to fit).
+	var tooFine, fits int
+	min, max := slicer.MBB()
+	var maxExtent float32 // millimeters
+	for i := 0; i < 3; i++ {
+		if d := max[i] - min[i]; d > maxExtent {
+			maxExtent = d
+		}
+	}
+	res := *start
+	if res < *minRes {
+		res = *minRes
+	}
+	for tooFine == 0 || fits == 0 {
+		ok, err := try(res)
+		if err != nil {
+			return err
+		}
+		if ok {
+			fits = res
+			if res == *minRes || mr.Trials[len(mr.Trials)-1].Triangles == 0 {
+				break // or the material is empty at every resolution.
+			}
+			res /= 2
+			if res < *minRes {
+				res = *minRes
+			}
+		} else {
+			tooFine = res
+			if fits != 0 || float32(res) > 1000*maxExtent {
+				break // or no resolution can possibly fit.
+			}
+			res *= 2
+		}
+	}
+
+	for fits != 0 && tooFine != 0 && fits-tooFine > 1 {
+		res := (tooFine + fits) / 2
+		ok, err := try(res)
+		if err != nil {
+			return err
+		}
+		if ok {
+			fits = res
+		} else {
+			tooFine = res
+		}
+	}
+
+	if mr.BestRes == 0 {
+		return nil
+	}
+	log.Printf("Moving %v to %v (at res=%v)", mr.STL, stlName, mr.BestRes)
+	if err := os.Rename(mr.STL, stlName); err != nil {
+		return err
+	}
+	mr.STL = stlName
+	return nil
+}
+
+// parseTargets parses a list of per-material maximum file sizes
+// of the form "materialNum=bytes,...".
+func parseTargets(s string) (map[int]int64, error) {
+	targets := map[int]int64{}
+	if s == "" {
+		return targets, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad target %q; want materialNum=bytes", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad material number in %q", part)
+		}
+		v, err := strconv.ParseInt(strings.TrimSpace(kv[1]), 10, 64)
+		if err != nil || v < 1 {
+			return nil, fmt.Errorf("bad maximum size in %q", part)
+		}
+		targets[n] = v
+	}
+	return targets, nil
+}
+
 func check(fmtStr string, args ...interface{}) {
 	if err := args[len(args)-1]; err != nil {
 		log.Fatalf(fmtStr, args...)
@@ -0,0 +1,439 @@
+// Package gltf writes all the materials of an IRMF model into a single
+// binary glTF 2.0 (GLB) file for web previews.
+// See https://registry.khronos.org/glTF/specs/2.0/glTF-2.0.html.
+package gltf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	voxels.Slicer
+	IRMF() *irmf.IRMF
+}
+
+// Options controls the GLB output.
+type Options struct {
+	// Mesh controls how each material is meshed (may be nil).
+	Mesh *voxels.Options
+	// Colors overrides the color of materials (keyed by 1-based material number).
+	// See matcolor.Colors for the default colors.
+	Colors map[int]color.NRGBA
+	// Quantize stores positions as 16-bit integers (KHR_mesh_quantization)
+	// instead of 32-bit floats, which shrinks the position data by a third.
+	Quantize bool
+}
+
+// glTF constants.
+const (
+	glbMagic     = 0x46546C67 // "glTF"
+	glbVersion   = 2
+	chunkJSON    = 0x4E4F534A // "JSON"
+	chunkBIN     = 0x004E4942 // "BIN\x00"
+	arrayBuffer  = 34962
+	elementArray = 34963
+
+	componentUnsignedShort = 5123
+	componentUnsignedInt   = 5125
+	componentFloat         = 5126
+
+	quantization = "KHR_mesh_quantization"
+	maxQuantized = 65535
+)
+
+// Slice slices an IRMF model into a single GLB file using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single GLB file containing
+// one mesh primitive and PBR material per material. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// The binary chunk follows the JSON chunk, which holds the
+	// buffer offsets, so all the materials are meshed before writing.
+	var names []string
+	var meshes []*mesh.Mesh
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		m, err := voxels.Mesh(slicer, materialNum, opts.Mesh)
+		if err != nil {
+			return fmt.Errorf("material %v: %v", materialNum, err)
+		}
+		log.Printf("Meshed %v triangles for material %v (%v)", len(m.Tris), materialNum, slicer.MaterialName(materialNum))
+		names = append(names, slicer.MaterialName(materialNum))
+		meshes = append(meshes, m)
+	}
+
+	filename := baseFilename + ".glb"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+
+	if err := write(f, slicer.IRMF(), names, matcolor.Colors(names, opts.Colors), meshes, opts.Quantize); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close GLB file: %v", err)
+	}
+	return nil
+}
+
+// document is the glTF JSON chunk.
+type document struct {
+	Asset              asset        `json:"asset"`
+	ExtensionsUsed     []string     `json:"extensionsUsed,omitempty"`
+	ExtensionsRequired []string     `json:"extensionsRequired,omitempty"`
+	Scene              int          `json:"scene"`
+	Scenes             []scene      `json:"scenes"`
+	Nodes              []node       `json:"nodes"`
+	Meshes             []gltfMesh   `json:"meshes,omitempty"`
+	Materials          []material   `json:"materials,omitempty"`
+	Accessors          []accessor   `json:"accessors,omitempty"`
+	BufferViews        []bufferView `json:"bufferViews,omitempty"`
+	Buffers            []buffer     `json:"buffers,omitempty"`
+}
+
+type asset struct {
+	Version   string  `json:"version"`
+	Generator string  `json:"generator"`
+	Copyright string  `json:"copyright,omitempty"`
+	Extras    *extras `json:"extras,omitempty"`
+}
+
+// extras holds the IRMF header fields.
+type extras struct {
+	Title     string   `json:"title,omitempty"`
+	Author    string   `json:"author,omitempty"`
+	Copyright string   `json:"copyright,omitempty"`
+	Date      string   `json:"date,omitempty"`
+	Version   string   `json:"version,omitempty"`
+	Notes     string   `json:"notes,omitempty"`
+	Units     string   `json:"units,omitempty"`
+	Materials []string `json:"materials,omitempty"`
+}
+
+type scene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type node struct {
+	Name        string    `json:"name,omitempty"`
+	Children    []int     `json:"children,omitempty"`
+	Mesh        *int      `json:"mesh,omitempty"`
+	Rotation    []float64 `json:"rotation,omitempty"`
+	Scale       []float64 `json:"scale,omitempty"`
+	Translation []float64 `json:"translation,omitempty"`
+}
+
+type gltfMesh struct {
+	Name       string      `json:"name,omitempty"`
+	Primitives []primitive `json:"primitives"`
+}
+
+type primitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    int            `json:"indices"`
+	Material   int            `json:"material"`
+}
+
+type material struct {
+	Name                 string               `json:"name"`
+	PBRMetallicRoughness pbrMetallicRoughness `json:"pbrMetallicRoughness"`
+	AlphaMode            string               `json:"alphaMode,omitempty"`
+	DoubleSided          bool                 `json:"doubleSided,omitempty"`
+}
+
+type pbrMetallicRoughness struct {
+	BaseColorFactor [4]float64 `json:"baseColorFactor"`
+	MetallicFactor  float64    `json:"metallicFactor"`
+	RoughnessFactor float64    `json:"roughnessFactor"`
+}
+
+type accessor struct {
+	BufferView    int       `json:"bufferView"`
+	ComponentType int       `json:"componentType"`
+	Count         int       `json:"count"`
+	Type          string    `json:"type"`
+	Min           []float64 `json:"min,omitempty"`
+	Max           []float64 `json:"max,omitempty"`
+}
+
+type bufferView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride,omitempty"`
+	Target     int `json:"target"`
+}
+
+type buffer struct {
+	ByteLength int `json:"byteLength"`
+}
+
+// write writes the meshes (one per material) to w as a GLB file.
+func write(w io.Writer, i *irmf.IRMF, names []string, colors []color.NRGBA, meshes []*mesh.Mesh, quantize bool) error {
+	doc := &document{
+		Asset: asset{
+			Version:   "2.0",
+			Generator: "irmf-slicer",
+			Copyright: i.Copyright,
+			Extras: &extras{
+				Title:     i.Title,
+				Author:    i.Author,
+				Copyright: i.Copyright,
+				Date:      i.Date,
+				Version:   i.Version,
+				Notes:     i.Notes,
+				Units:     i.Units,
+				Materials: names,
+			},
+		},
+		Scenes: []scene{{Nodes: []int{0}}},
+	}
+
+	// The root node converts the IRMF model (Z up, in model units)
+	// to glTF (Y up, in meters).
+	s := metersPerUnit(i.Units)
+	root := node{
+		Name:     i.Title,
+		Rotation: []float64{-math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2},
+		Scale:    []float64{s, s, s},
+		Children: []int{1},
+	}
+	doc.Nodes = []node{root, {Name: "model"}}
+
+	min, max := bounds(meshes)
+	if quantize {
+		// The model node maps the quantized positions back to model units.
+		doc.ExtensionsUsed = []string{quantization}
+		doc.ExtensionsRequired = []string{quantization}
+		var step [3]float64
+		for k := 0; k < 3; k++ {
+			step[k] = float64(max[k]-min[k]) / maxQuantized
+			if step[k] == 0 {
+				step[k] = 1
+			}
+		}
+		doc.Nodes[1].Translation = []float64{float64(min[0]), float64(min[1]), float64(min[2])}
+		doc.Nodes[1].Scale = step[:]
+	}
+
+	var bin bytes.Buffer
+	addView := func(data []byte, stride, target int) int {
+		for bin.Len()%4 != 0 {
+			bin.WriteByte(0)
+		}
+		doc.BufferViews = append(doc.BufferViews, bufferView{ByteOffset: bin.Len(), ByteLength: len(data), ByteStride: stride, Target: target})
+		bin.Write(data)
+		return len(doc.BufferViews) - 1
+	}
+
+	gm := gltfMesh{Name: i.Title}
+	for n, m := range meshes {
+		doc.Materials = append(doc.Materials, pbr(names[n], colors[n]))
+		if len(m.Tris) == 0 {
+			continue
+		}
+
+		var pos accessor
+		if quantize {
+			pos = quantized(m, min, max, addView)
+		} else {
+			pos = floats(m, addView)
+		}
+		doc.Accessors = append(doc.Accessors, pos)
+
+		indices := make([]byte, 12*len(m.Tris))
+		for t, tri := range m.Tris {
+			for k := 0; k < 3; k++ {
+				binary.LittleEndian.PutUint32(indices[12*t+4*k:], uint32(tri[k]))
+			}
+		}
+		doc.Accessors = append(doc.Accessors, accessor{
+			BufferView:    addView(indices, 0, elementArray),
+			ComponentType: componentUnsignedInt,
+			Count:         3 * len(m.Tris),
+			Type:          "SCALAR",
+		})
+
+		gm.Primitives = append(gm.Primitives, primitive{
+			Attributes: map[string]int{"POSITION": len(doc.Accessors) - 2},
+			Indices:    len(doc.Accessors) - 1,
+			Material:   n,
+		})
+	}
+	if len(gm.Primitives) > 0 {
+		doc.Meshes = []gltfMesh{gm}
+		meshIndex := 0
+		doc.Nodes[1].Mesh = &meshIndex
+	}
+	for bin.Len()%4 != 0 {
+		bin.WriteByte(0)
+	}
+	if bin.Len() > 0 {
+		doc.Buffers = []buffer{{ByteLength: bin.Len()}}
+	}
+
+	js, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	for len(js)%4 != 0 {
+		js = append(js, ' ')
+	}
+
+	length := 12 + 8 + len(js)
+	if bin.Len() > 0 {
+		length += 8 + bin.Len()
+	}
+	header := []uint32{glbMagic, glbVersion, uint32(length), uint32(len(js)), chunkJSON}
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return fmt.Errorf("write GLB: %v", err)
+	}
+	if _, err := w.Write(js); err != nil {
+		return fmt.Errorf("write GLB: %v", err)
+	}
+	if bin.Len() > 0 {
+		if err := binary.Write(w, binary.LittleEndian, []uint32{uint32(bin.Len()), chunkBIN}); err != nil {
+			return fmt.Errorf("write GLB: %v", err)
+		}
+		if _, err := w.Write(bin.Bytes()); err != nil {
+			return fmt.Errorf("write GLB: %v", err)
+		}
+	}
+	return nil
+}
+
+// floats stores the vertices of m as 32-bit floats.
+func floats(m *mesh.Mesh, addView func(data []byte, stride, target int) int) accessor {
+	min, max := m.Bounds()
+	data := make([]byte, 12*len(m.Verts))
+	for v, p := range m.Verts {
+		for k := 0; k < 3; k++ {
+			binary.LittleEndian.PutUint32(data[12*v+4*k:], math.Float32bits(p[k]))
+		}
+	}
+	return accessor{
+		BufferView:    addView(data, 0, arrayBuffer),
+		ComponentType: componentFloat,
+		Count:         len(m.Verts),
+		Type:          "VEC3",
+		Min:           []float64{float64(min[0]), float64(min[1]), float64(min[2])},
+		Max:           []float64{float64(max[0]), float64(max[1]), float64(max[2])},
+	}
+}
+
+// quantized stores the vertices of m as unsigned 16-bit integers
+// spanning the bounds (min, max) of all the meshes.
+func quantized(m *mesh.Mesh, min, max [3]float32, addView func(data []byte, stride, target int) int) accessor {
+	// Vertex attributes must be aligned to 4 bytes, so each
+	// 6-byte position is padded to 8 bytes.
+	data := make([]byte, 8*len(m.Verts))
+	qmin := []float64{maxQuantized, maxQuantized, maxQuantized}
+	qmax := []float64{0, 0, 0}
+	for v, p := range m.Verts {
+		for k := 0; k < 3; k++ {
+			var q float64
+			if max[k] > min[k] {
+				q = math.Round(float64(p[k]-min[k]) / float64(max[k]-min[k]) * maxQuantized)
+			}
+			q = math.Max(0, math.Min(maxQuantized, q))
+			binary.LittleEndian.PutUint16(data[8*v+2*k:], uint16(q))
+			qmin[k] = math.Min(qmin[k], q)
+			qmax[k] = math.Max(qmax[k], q)
+		}
+	}
+	return accessor{
+		BufferView:    addView(data, 8, arrayBuffer),
+		ComponentType: componentUnsignedShort,
+		Count:         len(m.Verts),
+		Type:          "VEC3",
+		Min:           qmin,
+		Max:           qmax,
+	}
+}
+
+// bounds returns the bounds of all the meshes.
+func bounds(meshes []*mesh.Mesh) (min, max [3]float32) {
+	first := true
+	for _, m := range meshes {
+		if len(m.Verts) == 0 {
+			continue
+		}
+		lo, hi := m.Bounds()
+		for k := 0; k < 3; k++ {
+			if first || lo[k] < min[k] {
+				min[k] = lo[k]
+			}
+			if first || hi[k] > max[k] {
+				max[k] = hi[k]
+			}
+		}
+		first = false
+	}
+	return min, max
+}
+
+// pbr returns an opaque (or blended, if translucent) dielectric material.
+func pbr(name string, c color.NRGBA) material {
+	mat := material{
+		Name: name,
+		PBRMetallicRoughness: pbrMetallicRoughness{
+			BaseColorFactor: [4]float64{linear(c.R), linear(c.G), linear(c.B), float64(c.A) / 255},
+			RoughnessFactor: 0.6,
+		},
+	}
+	if c.A < 0xff {
+		mat.AlphaMode = "BLEND"
+		mat.DoubleSided = true
+	}
+	return mat
+}
+
+// linear converts an sRGB color component to the linear value used by glTF.
+func linear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// metersPerUnit returns the scale from the IRMF units to meters.
+func metersPerUnit(units string) float64 {
+	switch strings.ToLower(units) {
+	case "um", "micron", "microns", "micrometer":
+		return 1e-6
+	case "cm", "centimeter":
+		return 0.01
+	case "in", "inch", "inches":
+		return 0.0254
+	case "ft", "foot", "feet":
+		return 0.3048
+	case "m", "meter":
+		return 1
+	}
+	return 0.001
+}
@@ -0,0 +1,122 @@
+package gltf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+)
+
+func TestWrite(t *testing.T) {
+	tri := &mesh.Mesh{
+		Verts: [][3]float32{{0, 0, 0}, {2, 0, 0}, {0, 4, 1}},
+		Tris:  [][3]int{{0, 1, 2}},
+	}
+	meshes := []*mesh.Mesh{tri, {}, tri}
+	names := []string{"PLA", "empty", "TPU"}
+	colors := []color.NRGBA{{255, 255, 255, 255}, {0, 0, 0, 255}, {255, 0, 0, 128}}
+	header := &irmf.IRMF{Title: "Test", Author: "Test Author", Units: "mm"}
+
+	tests := []struct {
+		quantize   bool
+		wantStride int
+		wantType   int
+	}{
+		{quantize: false, wantType: componentFloat},
+		{quantize: true, wantStride: 8, wantType: componentUnsignedShort},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("quantize=%v", tt.quantize), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := write(&buf, header, names, colors, meshes, tt.quantize); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			data := buf.Bytes()
+
+			// GLB header and chunks.
+			le := binary.LittleEndian
+			if le.Uint32(data) != glbMagic || le.Uint32(data[4:]) != glbVersion || int(le.Uint32(data[8:])) != len(data) {
+				t.Fatalf("bad GLB header % x", data[:12])
+			}
+			jsonLen := int(le.Uint32(data[12:]))
+			if le.Uint32(data[16:]) != chunkJSON || jsonLen%4 != 0 {
+				t.Fatalf("bad JSON chunk header % x", data[12:20])
+			}
+			var doc document
+			if err := json.Unmarshal(data[20:20+jsonLen], &doc); err != nil {
+				t.Fatalf("json.Unmarshal: %v", err)
+			}
+			binStart := 20 + jsonLen
+			binLen := int(le.Uint32(data[binStart:]))
+			if le.Uint32(data[binStart+4:]) != chunkBIN || binStart+8+binLen != len(data) {
+				t.Fatalf("bad BIN chunk header % x", data[binStart:binStart+8])
+			}
+			bin := data[binStart+8:]
+
+			if doc.Asset.Version != "2.0" || doc.Asset.Extras == nil || doc.Asset.Extras.Author != "Test Author" {
+				t.Errorf("asset = %+v, want version 2.0 and IRMF extras", doc.Asset)
+			}
+			if got := len(doc.ExtensionsRequired) > 0; got != tt.quantize {
+				t.Errorf("extensionsRequired = %v, want quantization %v", doc.ExtensionsRequired, tt.quantize)
+			}
+			if len(doc.Materials) != 3 {
+				t.Fatalf("got %v materials, want 3", len(doc.Materials))
+			}
+			if got := doc.Materials[2]; got.AlphaMode != "BLEND" || got.PBRMetallicRoughness.BaseColorFactor[0] != 1 {
+				t.Errorf("material 2 = %+v, want translucent red", got)
+			}
+
+			// The empty material has no primitive.
+			if len(doc.Meshes) != 1 || len(doc.Meshes[0].Primitives) != 2 {
+				t.Fatalf("meshes = %+v, want 1 mesh with 2 primitives", doc.Meshes)
+			}
+			for i, want := range []int{0, 2} {
+				p := doc.Meshes[0].Primitives[i]
+				if p.Material != want {
+					t.Errorf("primitive %v material = %v, want %v", i, p.Material, want)
+				}
+				pos := doc.Accessors[p.Attributes["POSITION"]]
+				view := doc.BufferViews[pos.BufferView]
+				if pos.ComponentType != tt.wantType || view.ByteStride != tt.wantStride || pos.Count != 3 {
+					t.Errorf("POSITION = %+v (view %+v), want type %v stride %v", pos, view, tt.wantType, tt.wantStride)
+				}
+				if view.ByteOffset%4 != 0 || view.ByteOffset+view.ByteLength > binLen {
+					t.Errorf("POSITION view %+v is misaligned or out of range", view)
+				}
+
+				// Decode the last vertex back into model units.
+				stride := view.ByteStride
+				if stride == 0 {
+					stride = 12
+				}
+				off := view.ByteOffset + 2*stride
+				var got [3]float64
+				for k := 0; k < 3; k++ {
+					if tt.quantize {
+						model := doc.Nodes[1]
+						got[k] = float64(le.Uint16(bin[off+2*k:]))*model.Scale[k] + model.Translation[k]
+					} else {
+						got[k] = float64(math.Float32frombits(le.Uint32(bin[off+4*k:])))
+					}
+				}
+				for k, want := range tri.Verts[2] {
+					if math.Abs(got[k]-float64(want)) > 1e-4 {
+						t.Errorf("vertex 2 = %v, want %v", got, tri.Verts[2])
+					}
+				}
+
+				idx := doc.Accessors[p.Indices]
+				if idx.ComponentType != componentUnsignedInt || idx.Count != 3 {
+					t.Errorf("indices = %+v, want 3 unsigned ints", idx)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Package hexmesh slices the model and writes voxel hexahedral meshes
+// for finite-element analysis, as Abaqus input (.inp) files or VTK
+// unstructured grid (.vtu) files.
+package hexmesh
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	IRMF() *irmf.IRMF
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the hexahedral mesh.
+type Options struct {
+	// Coarsen merges blocks of NxNxN voxels into each element
+	// (the default, 0 or 1, makes one element per voxel).
+	// It may be at most maxCoarsen.
+	Coarsen int
+}
+
+// maxCoarsen keeps the total coverage of each element within 32 bits.
+const maxCoarsen = 128
+
+// Boundary faces of the MBB (as bits of mesh.boundary).
+const (
+	xMin = 1 << iota
+	xMax
+	yMin
+	yMax
+	zMin
+	zMax
+)
+
+// faceNames are the names of the node sets on the boundary faces.
+var faceNames = []string{"XMIN", "XMAX", "YMIN", "YMAX", "ZMIN", "ZMAX"}
+
+// mesh is a hexahedral mesh whose nodes are shared between elements.
+type mesh struct {
+	nodes    [][3]float32 // in millimeters
+	boundary []uint8      // the boundary faces (if any) of each node
+
+	// elements holds the 8 nodes of each hexahedron (0-based), ordered as
+	// for Abaqus C3D8 and VTK_HEXAHEDRON elements: the bottom face
+	// counterclockwise (seen from above), then the top face.
+	elements [][8]int32
+	material []uint8 // 1-based material number of each element
+	names    []string
+}
+
+// newMesh slices the model and meshes the voxels of all the materials.
+// Each element is assigned to the material with the greatest coverage
+// (if its average coverage is at least one half).
+func newMesh(slicer Slicer, opts *Options) (*mesh, error) {
+	c := 1
+	if opts != nil && opts.Coarsen > 1 {
+		c = opts.Coarsen
+	}
+	if c > maxCoarsen {
+		return nil, fmt.Errorf("coarsening factor %v is too large (at most %v)", c, maxCoarsen)
+	}
+	if n := slicer.NumMaterials(); n > 255 {
+		return nil, fmt.Errorf("at most 255 materials are supported, got %v", n)
+	}
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return nil, fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	g := &grid{coarsen: c, voxels: [3]int{slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()}}
+	for k, n := range g.voxels {
+		g.cells[k] = (n + c - 1) / c
+	}
+	numCells := g.cells[0] * g.cells[1] * g.cells[2]
+	g.label = make([]uint8, numCells)
+	g.best = make([]uint32, numCells)
+	g.band = make([]uint32, g.cells[0]*g.cells[1])
+
+	m := &mesh{}
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		m.names = append(m.names, slicer.MaterialName(materialNum))
+		g.materialNum = uint8(materialNum)
+		if err := slicer.RenderZSlices(materialNum, g, irmf.MinToMax); err != nil {
+			return nil, fmt.Errorf("RenderZSlices: %v", err)
+		}
+	}
+
+	min, max := slicer.MBB()
+	m.build(g, min, max)
+	log.Printf("Meshed %v elements and %v nodes (%v voxels per element side)", len(m.elements), len(m.nodes), c)
+	return m, nil
+}
+
+// grid accumulates the coverage of the voxels within each element cell.
+// It implements the irmf.ZSliceProcessor interface.
+type grid struct {
+	coarsen     int
+	voxels      [3]int // number of voxels along each axis
+	cells       [3]int // number of cells along each axis
+	materialNum uint8
+
+	label []uint8  // material of each cell
+	best  []uint32 // total coverage of the material of each cell
+	band  []uint32 // total coverage of each cell of the current band of slices
+}
+
+var _ irmf.ZSliceProcessor = &grid{}
+
+func (g *grid) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != g.voxels[0] || b.Dy() != g.voxels[1] {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, b.Dx(), b.Dy(), g.voxels[0], g.voxels[1])
+	}
+	if sliceNum < 0 || sliceNum >= g.voxels[2] {
+		return fmt.Errorf("slice %v out of range [0,%v)", sliceNum, g.voxels[2])
+	}
+
+	rgba, _ := img.(*image.RGBA)
+	for y := 0; y < g.voxels[1]; y++ {
+		row := (y / g.coarsen) * g.cells[0]
+		for x := 0; x < g.voxels[0]; x++ {
+			var v uint8
+			if rgba != nil {
+				v = rgba.Pix[y*rgba.Stride+x*4]
+			} else {
+				v = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+			g.band[row+x/g.coarsen] += uint32(v)
+		}
+	}
+
+	if sliceNum%g.coarsen == g.coarsen-1 || sliceNum == g.voxels[2]-1 {
+		g.flush(sliceNum / g.coarsen)
+	}
+	return nil
+}
+
+// flush assigns the cells of the band of slices at cell layer cz
+// to the current material where it wins, and clears the band.
+func (g *grid) flush(cz int) {
+	wz := g.width(2, cz)
+	for cy := 0; cy < g.cells[1]; cy++ {
+		for cx := 0; cx < g.cells[0]; cx++ {
+			i := cy*g.cells[0] + cx
+			sum := g.band[i]
+			g.band[i] = 0
+			count := uint32(g.width(0, cx) * g.width(1, cy) * wz)
+			if j := cz*len(g.band) + i; sum >= 128*count && sum > g.best[j] {
+				g.label[j], g.best[j] = g.materialNum, sum
+			}
+		}
+	}
+}
+
+// width returns the number of voxels along axis k of cell i;
+// the last cell may be partial.
+func (g *grid) width(k, i int) int {
+	if w := g.voxels[k] - i*g.coarsen; w < g.coarsen {
+		return w
+	}
+	return g.coarsen
+}
+
+// build creates the elements of the labeled cells (grouped by material)
+// and their shared nodes.
+func (m *mesh) build(g *grid, min, max [3]float32) {
+	nx, ny, nz := g.cells[0]+1, g.cells[1]+1, g.cells[2]+1
+	nodeIndex := func(x, y, z int) int { return (z*ny+y)*nx + x }
+	corners := [8][3]int{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0}, {0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1}}
+
+	// Number the nodes used by any element in grid order.
+	ids := make([]int32, nx*ny*nz)
+	for i, label := range g.label {
+		if label == 0 {
+			continue
+		}
+		cx, cy, cz := i%g.cells[0], (i/g.cells[0])%g.cells[1], i/(g.cells[0]*g.cells[1])
+		for _, d := range corners {
+			ids[nodeIndex(cx+d[0], cy+d[1], cz+d[2])] = 1
+		}
+	}
+	coord := func(k, i int) float32 {
+		v := i * g.coarsen
+		if v > g.voxels[k] {
+			v = g.voxels[k] // the last cell is clipped to the MBB
+		}
+		return min[k] + (max[k]-min[k])*float32(v)/float32(g.voxels[k])
+	}
+	for z := 0; z < nz; z++ {
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				i := nodeIndex(x, y, z)
+				if ids[i] == 0 {
+					continue
+				}
+				ids[i] = int32(len(m.nodes))
+				m.nodes = append(m.nodes, [3]float32{coord(0, x), coord(1, y), coord(2, z)})
+				var b uint8
+				for k, p := range []int{x, y, z} {
+					if p == 0 {
+						b |= xMin << (2 * k)
+					}
+					if p == g.cells[k] {
+						b |= xMax << (2 * k)
+					}
+				}
+				m.boundary = append(m.boundary, b)
+			}
+		}
+	}
+
+	for materialNum := 1; materialNum <= len(m.names); materialNum++ {
+		for i, label := range g.label {
+			if int(label) != materialNum {
+				continue
+			}
+			cx, cy, cz := i%g.cells[0], (i/g.cells[0])%g.cells[1], i/(g.cells[0]*g.cells[1])
+			var e [8]int32
+			for j, d := range corners {
+				e[j] = ids[nodeIndex(cx+d[0], cy+d[1], cz+d[2])]
+			}
+			m.elements = append(m.elements, e)
+			m.material = append(m.material, label)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package hexmesh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+)
+
+var tests = []struct {
+	name    string
+	size    [3]int // 3x2x2 1mm voxels if zero
+	voxels  []map[[3]int]uint8
+	coarsen int
+	// wantElements holds the min and max corners of the elements of each material.
+	wantElements map[string][][2][3]float32
+	wantNodes    int
+	wantSets     map[string]int // number of nodes in each node set
+}{
+	{
+		name: "voxels",
+		voxels: []map[[3]int]uint8{
+			{{0, 0, 0}: 255, {1, 0, 0}: 200},
+			{{1, 0, 0}: 255, {2, 1, 1}: 255, {0, 1, 1}: 100},
+		},
+		wantElements: map[string][][2][3]float32{
+			"MAT01_PLA":         {{{0, 0, 0}, {1, 1, 1}}},
+			"MAT02_Copper_wire": {{{1, 0, 0}, {2, 1, 1}}, {{2, 1, 1}, {3, 2, 2}}}, // the greater coverage wins
+		},
+		wantNodes: 19, // two elements share a face, and two share a corner
+		wantSets:  map[string]int{"XMIN": 4, "XMAX": 4, "YMIN": 6, "YMAX": 4, "ZMIN": 6, "ZMAX": 4},
+	},
+	{
+		name: "coarsened",
+		voxels: []map[[3]int]uint8{
+			{{0, 0, 0}: 255, {1, 0, 0}: 255, {0, 1, 0}: 255, {1, 1, 0}: 255, {0, 0, 1}: 255},
+			{{2, 1, 0}: 255, {2, 0, 1}: 255, {2, 1, 1}: 255},
+		},
+		coarsen: 2,
+		wantElements: map[string][][2][3]float32{
+			"MAT01_PLA":         {{{0, 0, 0}, {2, 2, 2}}},
+			"MAT02_Copper_wire": {{{2, 0, 0}, {3, 2, 2}}}, // clipped to the MBB
+		},
+		wantNodes: 12,
+		wantSets:  map[string]int{"XMIN": 4, "XMAX": 4, "YMIN": 6, "YMAX": 6, "ZMIN": 6, "ZMAX": 6},
+	},
+	{
+		name: "partial cells",
+		size: [3]int{3, 3, 3},
+		voxels: []map[[3]int]uint8{
+			// The first cell is only half full; the last (1x1x1) cell is full.
+			{{0, 0, 0}: 255, {1, 0, 0}: 255, {0, 1, 0}: 255, {1, 1, 0}: 255, {2, 2, 2}: 255},
+		},
+		coarsen: 2,
+		wantElements: map[string][][2][3]float32{
+			"MAT01_PLA": {{{2, 2, 2}, {3, 3, 3}}},
+		},
+		wantNodes: 8,
+		wantSets:  map[string]int{"XMAX": 4, "YMAX": 4, "ZMAX": 4},
+	},
+	{
+		name: "empty bands",
+		size: [3]int{2, 2, 6},
+		voxels: []map[[3]int]uint8{
+			slicertest.Box([3]int{0, 0, 4}, [3]int{1, 1, 5}, 255),
+			{{0, 0, 0}: 255}, // less than half of its cell
+		},
+		coarsen: 2,
+		wantElements: map[string][][2][3]float32{
+			"MAT01_PLA": {{{0, 0, 4}, {2, 2, 6}}},
+		},
+		wantNodes: 8,
+		wantSets:  map[string]int{"XMIN": 4, "XMAX": 4, "YMIN": 4, "YMAX": 4, "ZMAX": 4},
+	},
+}
+
+// newSlicer returns a fake slicer for the test case.
+func newSlicer(size [3]int, voxels []map[[3]int]uint8) *slicertest.Slicer {
+	if size == [3]int{} {
+		size = [3]int{3, 2, 2}
+	}
+	return &slicertest.Slicer{Names: []string{"PLA", "Copper wire"}, Size: size, Voxels: voxels}
+}
+
+func TestINPSlice(t *testing.T) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "test")
+			if err := INPSlice(base, newSlicer(tt.size, tt.voxels), &Options{Coarsen: tt.coarsen}); err != nil {
+				t.Fatalf("INPSlice: %v", err)
+			}
+			f, err := os.Open(base + ".inp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			nodes := map[int][3]float32{}
+			elements := map[string][][2][3]float32{}
+			sets := map[string]int{}
+			var keyword, set string
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				line := s.Text()
+				if strings.HasPrefix(line, "**") {
+					continue
+				}
+				if strings.HasPrefix(line, "*") {
+					fields := strings.Split(line, ", ")
+					keyword = fields[0]
+					if i := strings.Index(line, "SET="); i >= 0 {
+						set = line[i+4:]
+					}
+					continue
+				}
+				var values []int
+				var coords []float32
+				for _, v := range strings.Split(line, ", ") {
+					n, _ := strconv.Atoi(v)
+					values = append(values, n)
+					c, _ := strconv.ParseFloat(v, 32)
+					coords = append(coords, float32(c))
+				}
+				switch keyword {
+				case "*NODE":
+					nodes[values[0]] = [3]float32{coords[1], coords[2], coords[3]}
+				case "*ELEMENT":
+					if len(values) != 9 {
+						t.Fatalf("element %q has %v values, want 9", line, len(values))
+					}
+					// The first and seventh nodes are the min and max corners.
+					elements[set] = append(elements[set], [2][3]float32{nodes[values[1]], nodes[values[7]]})
+				case "*NSET":
+					sets[set] += len(values)
+				}
+			}
+
+			if len(nodes) != tt.wantNodes {
+				t.Errorf("got %v nodes, want %v", len(nodes), tt.wantNodes)
+			}
+			if !reflect.DeepEqual(elements, tt.wantElements) {
+				t.Errorf("elements = %v, want %v", elements, tt.wantElements)
+			}
+			if !reflect.DeepEqual(sets, tt.wantSets) {
+				t.Errorf("node sets = %v, want %v", sets, tt.wantSets)
+			}
+		})
+	}
+}
+
+func TestVTUSlice(t *testing.T) {
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "test")
+			if err := VTUSlice(base, newSlicer(tt.size, tt.voxels), &Options{Coarsen: tt.coarsen}); err != nil {
+				t.Fatalf("VTUSlice: %v", err)
+			}
+			data, err := ioutil.ReadFile(base + ".vtu")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			marker := []byte("<AppendedData encoding=\"raw\">\n   _")
+			start := bytes.Index(data, marker)
+			if start < 0 {
+				t.Fatalf("missing appended data")
+			}
+			appended := data[start+len(marker):]
+			var doc struct {
+				Piece struct {
+					NumberOfPoints int `xml:",attr"`
+					NumberOfCells  int `xml:",attr"`
+					Arrays         []struct {
+						Name   string `xml:",attr"`
+						Offset int    `xml:"offset,attr"`
+					} `xml:"PointData>DataArray"`
+					CellArrays []struct {
+						Name   string `xml:",attr"`
+						Offset int    `xml:"offset,attr"`
+					} `xml:"CellData>DataArray"`
+					PointArrays []struct {
+						Offset int `xml:"offset,attr"`
+					} `xml:"Points>DataArray"`
+					Cells []struct {
+						Name   string `xml:",attr"`
+						Offset int    `xml:"offset,attr"`
+					} `xml:"Cells>DataArray"`
+				} `xml:"UnstructuredGrid>Piece"`
+			}
+			if err := xml.Unmarshal([]byte(string(data[:start])+"</VTKFile>"), &doc); err != nil {
+				t.Fatalf("xml.Unmarshal: %v", err)
+			}
+			p := doc.Piece
+			if p.NumberOfPoints != tt.wantNodes || len(p.Cells) != 3 || len(p.CellArrays) != 1 || len(p.PointArrays) != 1 {
+				t.Fatalf("got piece %+v, want %v points", p, tt.wantNodes)
+			}
+			array := func(offset int) []byte {
+				n := binary.LittleEndian.Uint64(appended[offset:])
+				return appended[offset+8 : offset+8+int(n)]
+			}
+			le := binary.LittleEndian
+
+			points := array(p.PointArrays[0].Offset)
+			point := func(i int) [3]float32 {
+				var v [3]float32
+				binary.Read(bytes.NewReader(points[12*i:]), le, &v)
+				return v
+			}
+			connectivity := array(p.Cells[0].Offset)
+			materials := array(p.CellArrays[0].Offset)
+			types := array(p.Cells[2].Offset)
+			got := map[string][][2][3]float32{}
+			for i := 0; i < p.NumberOfCells; i++ {
+				if types[i] != vtkHexahedron {
+					t.Errorf("cell %v type = %v, want %v", i, types[i], vtkHexahedron)
+				}
+				name := fmt.Sprintf("MAT%02d_%v", materials[i], []string{"PLA", "Copper_wire"}[materials[i]-1])
+				first, seventh := int(le.Uint32(connectivity[32*i:])), int(le.Uint32(connectivity[32*i+24:]))
+				got[name] = append(got[name], [2][3]float32{point(first), point(seventh)})
+			}
+			if !reflect.DeepEqual(got, tt.wantElements) {
+				t.Errorf("elements = %v, want %v", got, tt.wantElements)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tooMany := make([]map[[3]int]uint8, 256)
+	tests := []struct {
+		name   string
+		voxels []map[[3]int]uint8
+		opts   *Options
+	}{
+		{name: "coarsen too large", voxels: tests[0].voxels, opts: &Options{Coarsen: maxCoarsen + 1}},
+		{name: "too many materials", voxels: tooMany},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "test")
+			if err := INPSlice(base, newSlicer([3]int{}, tt.voxels), tt.opts); err == nil {
+				t.Error("INPSlice = nil, want error")
+			}
+		})
+	}
+}
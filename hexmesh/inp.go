@@ -0,0 +1,111 @@
+package hexmesh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+)
+
+// INPSlice slices an IRMF model into a single Abaqus input file with
+// C3D8 elements, one element set per material (named like "MAT01_PLA"),
+// and node sets on the faces of the MBB ("XMIN" through "ZMAX").
+// Coordinates are in millimeters. opts may be nil.
+func INPSlice(baseFilename string, slicer Slicer, opts *Options) error {
+	m, err := newMesh(slicer, opts)
+	if err != nil {
+		return err
+	}
+	filename := baseFilename + ".inp"
+	log.Printf("Writing: %v", filename)
+	return writeFile(filename, func(w io.Writer) error {
+		return m.writeINP(w, slicer.IRMF().Title)
+	})
+}
+
+// writeFile creates filename and writes it with write.
+func writeFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close %v: %v", filename, err)
+	}
+	return nil
+}
+
+// writeINP writes the mesh as an Abaqus input file.
+// Node and element labels are 1-based.
+func (m *mesh) writeINP(w io.Writer, title string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "*HEADING\n")
+	if title = strings.Join(strings.Fields(title), " "); title != "" {
+		fmt.Fprintf(bw, "%v\n", title)
+	}
+	fmt.Fprintf(bw, "** Written by irmf-slicer\n")
+	fmt.Fprintf(bw, "** Units: mm\n")
+
+	fmt.Fprintf(bw, "*NODE\n")
+	for i, n := range m.nodes {
+		fmt.Fprintf(bw, "%v, %v, %v, %v\n", i+1, textfmt.Float32(n[0]), textfmt.Float32(n[1]), textfmt.Float32(n[2]))
+	}
+
+	for i := 0; i < len(m.elements); {
+		materialNum := m.material[i]
+		fmt.Fprintf(bw, "*ELEMENT, TYPE=C3D8, ELSET=%v\n", setName(int(materialNum), m.names[materialNum-1]))
+		for ; i < len(m.elements) && m.material[i] == materialNum; i++ {
+			e := m.elements[i]
+			fmt.Fprintf(bw, "%v, %v, %v, %v, %v, %v, %v, %v, %v\n", i+1,
+				e[0]+1, e[1]+1, e[2]+1, e[3]+1, e[4]+1, e[5]+1, e[6]+1, e[7]+1)
+		}
+	}
+
+	for face, name := range faceNames {
+		var ids []string
+		for i, b := range m.boundary {
+			if b&(1<<face) != 0 {
+				ids = append(ids, strconv.Itoa(i+1))
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "*NSET, NSET=%v\n", name)
+		for len(ids) > 0 {
+			n := len(ids)
+			if n > 16 { // at most 16 entries per data line
+				n = 16
+			}
+			fmt.Fprintf(bw, "%v\n", strings.Join(ids[:n], ", "))
+			ids = ids[n:]
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write INP: %v", err)
+	}
+	return nil
+}
+
+// setName returns the element set name of a material, which may only
+// contain letters, digits and underscores.
+func setName(materialNum int, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, name)
+	return fmt.Sprintf("MAT%02d_%v", materialNum, name)
+}
@@ -0,0 +1,118 @@
+package hexmesh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+)
+
+// vtkHexahedron is the VTK cell type of 8-node hexahedra.
+const vtkHexahedron = 12
+
+// VTUSlice slices an IRMF model into a single VTK unstructured grid (.vtu)
+// file of hexahedra with its data appended in binary. Each cell's material
+// number is in the "material" cell data array, and each point's boundary
+// faces are in the "boundary" point data array (bits 1, 2, 4, 8, 16 and 32
+// for XMIN, XMAX, YMIN, YMAX, ZMIN and ZMAX). Coordinates are in millimeters.
+// opts may be nil.
+func VTUSlice(baseFilename string, slicer Slicer, opts *Options) error {
+	m, err := newMesh(slicer, opts)
+	if err != nil {
+		return err
+	}
+	filename := baseFilename + ".vtu"
+	log.Printf("Writing: %v", filename)
+	return writeFile(filename, m.writeVTU)
+}
+
+// writeVTU writes the mesh as a VTK XML unstructured grid. Each array
+// is stored in the appended data section as a UInt64 byte count
+// followed by its bytes.
+func (m *mesh) writeVTU(w io.Writer) error {
+	numPoints, numCells := len(m.nodes), len(m.elements)
+	types := make([]uint8, numCells)
+	offsets := make([]int32, numCells)
+	for i := range types {
+		types[i] = vtkHexahedron
+		offsets[i] = int32(8 * (i + 1))
+	}
+
+	arrays := []struct {
+		section, typ, name string
+		components         int
+		data               interface{}
+	}{
+		{"PointData", "UInt8", "boundary", 1, m.boundary},
+		{"CellData", "UInt8", "material", 1, m.material},
+		{"Points", "Float32", "Points", 3, m.nodes},
+		{"Cells", "Int32", "connectivity", 1, m.elements},
+		{"Cells", "Int32", "offsets", 1, offsets},
+		{"Cells", "UInt8", "types", 1, types},
+	}
+
+	bw := bufio.NewWriter(w)
+	var werr error
+	printf := func(format string, args ...interface{}) {
+		if werr == nil {
+			_, werr = fmt.Fprintf(bw, format, args...)
+		}
+	}
+
+	printf("<?xml version=\"1.0\"?>\n")
+	printf("<VTKFile type=\"UnstructuredGrid\" version=\"1.0\" byte_order=\"LittleEndian\" header_type=\"UInt64\">\n")
+	var labels []string
+	for i, name := range m.names {
+		labels = append(labels, fmt.Sprintf("%v: %v", i+1, textfmt.XMLComment(name)))
+	}
+	printf("  <!-- materials: %v -->\n", strings.Join(labels, ", "))
+	printf("  <UnstructuredGrid>\n")
+	printf("    <Piece NumberOfPoints=\"%v\" NumberOfCells=\"%v\">\n", numPoints, numCells)
+	var offset int
+	section := ""
+	for _, a := range arrays {
+		if a.section != section {
+			if section != "" {
+				printf("      </%v>\n", section)
+			}
+			section = a.section
+			switch section {
+			case "PointData":
+				printf("      <PointData Scalars=\"boundary\">\n")
+			case "CellData":
+				printf("      <CellData Scalars=\"material\">\n")
+			default:
+				printf("      <%v>\n", section)
+			}
+		}
+		printf("        <DataArray type=\"%v\" Name=\"%v\" NumberOfComponents=\"%v\" format=\"appended\" offset=\"%v\"/>\n",
+			a.typ, a.name, a.components, offset)
+		offset += 8 + binary.Size(a.data)
+	}
+	printf("      </%v>\n", section)
+	printf("    </Piece>\n")
+	printf("  </UnstructuredGrid>\n")
+	printf("  <AppendedData encoding=\"raw\">\n   _")
+	for _, a := range arrays {
+		if werr == nil {
+			werr = binary.Write(bw, binary.LittleEndian, uint64(binary.Size(a.data)))
+		}
+		if werr == nil {
+			werr = binary.Write(bw, binary.LittleEndian, a.data)
+		}
+	}
+	printf("\n  </AppendedData>\n")
+	printf("</VTKFile>\n")
+
+	if werr == nil {
+		werr = bw.Flush()
+	}
+	if werr != nil {
+		return fmt.Errorf("write VTU: %v", werr)
+	}
+	return nil
+}
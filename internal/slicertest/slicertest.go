@@ -0,0 +1,113 @@
+// Package slicertest provides a fake slicer for testing the writers
+// that consume the Z slices of an IRMF model.
+package slicertest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer is a fake slicer whose voxels are given by a map per material.
+// It implements the Slicer interfaces of the writer packages.
+type Slicer struct {
+	// Model is returned by IRMF (a model titled "Test" if nil).
+	Model *irmf.IRMF
+	// Names are the names of the materials (by default, "Material N").
+	Names []string
+	// Size is the number of voxels along each axis.
+	Size [3]int
+	// Min and Max are the MBB (in millimeters). If they are equal,
+	// the MBB starts at the origin and each voxel is 1mm.
+	Min, Max [3]float32
+	// Voxels holds the coverage of the voxels (keyed by x, y and z)
+	// of each material. Voxels that are not listed are empty.
+	Voxels []map[[3]int]uint8
+}
+
+// IRMF returns the model.
+func (s *Slicer) IRMF() *irmf.IRMF {
+	if s.Model == nil {
+		return &irmf.IRMF{Title: "Test"}
+	}
+	return s.Model
+}
+
+// NumMaterials returns the number of materials.
+func (s *Slicer) NumMaterials() int { return len(s.Voxels) }
+
+// MaterialName returns the name of the material (1-based).
+func (s *Slicer) MaterialName(materialNum int) string {
+	if materialNum <= len(s.Names) {
+		return s.Names[materialNum-1]
+	}
+	return fmt.Sprintf("Material %v", materialNum)
+}
+
+// MBB returns the MBB of the model in millimeters.
+func (s *Slicer) MBB() (min, max [3]float32) {
+	if s.Min == s.Max {
+		return min, [3]float32{float32(s.Size[0]), float32(s.Size[1]), float32(s.Size[2])}
+	}
+	return s.Min, s.Max
+}
+
+// PrepareRenderZ does nothing.
+func (s *Slicer) PrepareRenderZ() error { return nil }
+
+// NumXSlices returns the number of voxels along X.
+func (s *Slicer) NumXSlices() int { return s.Size[0] }
+
+// NumYSlices returns the number of voxels along Y.
+func (s *Slicer) NumYSlices() int { return s.Size[1] }
+
+// NumZSlices returns the number of voxels along Z.
+func (s *Slicer) NumZSlices() int { return s.Size[2] }
+
+// RenderZSlices renders the Z slices of the material in the given order,
+// like irmf.Slicer: pixel (x,y) of each slice is voxel (x,y,z).
+func (s *Slicer) RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error {
+	if materialNum < 1 || materialNum > len(s.Voxels) {
+		return fmt.Errorf("material %v out of range [1,%v]", materialNum, len(s.Voxels))
+	}
+	min, max := s.MBB()
+	dz := (max[2] - min[2]) / float32(s.Size[2])
+
+	// Group the voxels by slice so that each slice is rendered once.
+	slices := map[int][][3]int{}
+	for p := range s.Voxels[materialNum-1] {
+		slices[p[2]] = append(slices[p[2]], p)
+	}
+
+	for n := 0; n < s.Size[2]; n++ {
+		z := n
+		if order == irmf.MaxToMin {
+			z = s.Size[2] - n - 1
+		}
+		img := image.NewRGBA(image.Rect(0, 0, s.Size[0], s.Size[1]))
+		for _, p := range slices[z] {
+			v := s.Voxels[materialNum-1][p]
+			img.Set(p[0], p[1], color.RGBA{v, v, v, 255})
+		}
+		if err := sp.ProcessZSlice(n, min[2]+(float32(z)+0.5)*dz, 0.5*dz, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Box returns the voxels of the box from min to max (inclusive)
+// with the given coverage.
+func Box(min, max [3]int, v uint8) map[[3]int]uint8 {
+	voxels := map[[3]int]uint8{}
+	for z := min[2]; z <= max[2]; z++ {
+		for y := min[1]; y <= max[1]; y++ {
+			for x := min[0]; x <= max[0]; x++ {
+				voxels[[3]int{x, y, z}] = v
+			}
+		}
+	}
+	return voxels
+}
@@ -0,0 +1,30 @@
+// Package textfmt holds the text formatting helpers shared by the writers
+// of text-based file formats (such as 3MF, AMF, OBJ, Abaqus and VTK).
+package textfmt
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+)
+
+// Float32 formats v with the fewest digits that read back as the same float32.
+func Float32(v float32) string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 32)
+}
+
+// XMLEscape escapes s for use in XML text or (quoted) attribute values.
+func XMLEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// XMLComment makes s safe to use within an XML comment
+// (which may not contain "--").
+func XMLComment(s string) string {
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "- -")
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package textfmt
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestFloat32(t *testing.T) {
+	tests := []struct {
+		v    float32
+		want string
+	}{
+		{v: 0, want: "0"},
+		{v: 1.5, want: "1.5"},
+		{v: -0.1, want: "-0.1"},
+		{v: 1e-7, want: "1e-07"},
+		{v: 16777216, want: "1.6777216e+07"},
+	}
+
+	for _, tt := range tests {
+		if got := Float32(tt.v); got != tt.want {
+			t.Errorf("Float32(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestXMLEscape(t *testing.T) {
+	s := `Cubes & <things> "quoted"`
+	var v struct {
+		Text string `xml:",chardata"`
+		Attr string `xml:"a,attr"`
+	}
+	doc := `<x a="` + XMLEscape(s) + `">` + XMLEscape(s) + `</x>`
+	if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatalf("xml.Unmarshal(%q): %v", doc, err)
+	}
+	if v.Text != s || v.Attr != s {
+		t.Errorf("got text %q and attribute %q, want %q", v.Text, v.Attr, s)
+	}
+}
+
+func TestXMLComment(t *testing.T) {
+	for _, s := range []string{"a--b", "a---b", "a----b", "--"} {
+		if got := XMLComment(s); strings.Contains(got, "--") {
+			t.Errorf("XMLComment(%q) = %q, which contains --", s, got)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package irmf
+
+import "fmt"
+
+// GLError represents an OpenGL error detected while rendering a slice.
+type GLError struct {
+	Op   string // the operation after which the error was detected
+	Code uint32 // the value returned by gl.GetError
+}
+
+func (e *GLError) Error() string {
+	return fmt.Sprintf("%v: GL error 0x%04x", e.Op, e.Code)
+}
+
+// InitError represents a failure to initialize GLFW or OpenGL
+// (including failing to create the render window).
+type InitError struct {
+	Op  string
+	Err error
+}
+
+func (e *InitError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// ModelError represents an IRMF model that can not be sliced.
+type ModelError struct {
+	Msg string
+}
+
+func (e *ModelError) Error() string {
+	return fmt.Sprintf("bad IRMF model: %v", e.Msg)
+}
@@ -0,0 +1,37 @@
+package irmf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSlicerErrors(t *testing.T) {
+	s := Init(false, 42, 42, 42)
+
+	var modelErr *ModelError
+	if err := s.PrepareRenderZ(); !errors.As(err, &modelErr) {
+		t.Errorf("PrepareRenderZ with no model = %v, want *ModelError", err)
+	}
+	if err := s.RenderZSlices(1, nil, MinToMax); !errors.As(err, &modelErr) {
+		t.Errorf("RenderZSlices with no model = %v, want *ModelError", err)
+	}
+	if got := s.NumZSlices(); got != 0 {
+		t.Errorf("NumZSlices with no model = %v, want 0", got)
+	}
+
+	s.irmf = &IRMF{Materials: []string{"PLA"}, Min: []float32{0, 0}, Max: []float32{1, 1, 1}}
+	if min, max := s.MBB(); min != [3]float32{} || max != [3]float32{} {
+		t.Errorf("MBB with bad model = %v-%v, want zero values", min, max)
+	}
+	if err := s.RenderZSlices(1, nil, MinToMax); !errors.As(err, &modelErr) {
+		t.Errorf("RenderZSlices with bad model = %v, want *ModelError", err)
+	}
+
+	s.irmf.Min = []float32{0, 0, 0}
+	if err := s.RenderZSlices(1, nil, MinToMax); err != ErrNotPrepared {
+		t.Errorf("RenderZSlices before PrepareRenderZ = %v, want ErrNotPrepared", err)
+	}
+	if got := s.MaterialName(0); got != "" {
+		t.Errorf("MaterialName(0) = %q, want empty", got)
+	}
+}
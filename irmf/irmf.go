@@ -4,7 +4,9 @@ package irmf
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -32,6 +34,11 @@ type IRMF struct {
 	Version   string          `json:"version"`
 
 	Shader string `json:"-"`
+
+	// source is the shader source before any "#include" lines are processed.
+	source string
+	// sourceHash is the SHA-256 hash of the IRMF file.
+	sourceHash [sha256.Size]byte
 }
 
 var (
@@ -105,6 +112,8 @@ func newModel(src []byte) (*IRMF, error) {
 		jsonBlob.Shader = string(shaderSrcBuf)
 	}
 
+	jsonBlob.source = jsonBlob.Shader
+	jsonBlob.sourceHash = sha256.Sum256(src)
 	jsonBlob.Shader = processIncludes(jsonBlob.Shader)
 
 	if lineNum, err := jsonBlob.validate(jsonBlobStr, jsonBlob.Shader); err != nil {
@@ -214,6 +223,18 @@ func (i *IRMF) format(shaderSrc string) (string, error) {
 	return fmt.Sprintf("/*%v*/\n%v", jsonBlob, shaderSrc), nil
 }
 
+// SourceHash returns the hex-encoded SHA-256 hash of the IRMF file
+// that the model was parsed from (as reported by "sha256sum").
+func (i *IRMF) SourceHash() string {
+	return hex.EncodeToString(i.sourceHash[:])
+}
+
+// Format returns the complete IRMF source for the model, including its
+// (possibly modified) JSON header. The shader is written unencoded.
+func (i *IRMF) Format() (string, error) {
+	return i.format(i.source)
+}
+
 func curl(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
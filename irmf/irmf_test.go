@@ -46,3 +46,40 @@ func TestParseIncludeURL(t *testing.T) {
 		})
 	}
 }
+
+func TestFormat(t *testing.T) {
+	src := `/*{
+  irmf: "1.0",
+  materials: ["PLA"],
+  max: [5,5,5],
+  min: [-5,-5,-5],
+  units: "mm",
+}*/
+
+void mainModel4(out vec4 materials, in vec3 xyz) {
+  materials[0] = length(xyz) <= 2.0 ? 1.0 : 0.0;
+}
+`
+	model, err := newModel([]byte(src))
+	if err != nil {
+		t.Fatalf("newModel: %v", err)
+	}
+	model.Min = []float32{-2, -2, -2}
+	model.Max = []float32{2, 2, 2}
+
+	got, err := model.Format()
+	if err != nil {
+		t.Fatalf("Format: %v", err)
+	}
+
+	fitted, err := newModel([]byte(got))
+	if err != nil {
+		t.Fatalf("newModel(Format()): %v\n%v", err, got)
+	}
+	if fitted.Min[0] != -2 || fitted.Max[2] != 2 {
+		t.Errorf("Format MBB = %v-%v, want [-2 -2 -2]-[2 2 2]", fitted.Min, fitted.Max)
+	}
+	if fitted.Shader != model.Shader {
+		t.Errorf("Format shader = %q, want %q", fitted.Shader, model.Shader)
+	}
+}
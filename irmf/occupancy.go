@@ -0,0 +1,281 @@
+package irmf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"log"
+)
+
+// Occupancy represents the regions occupied by a single material
+// as found by a coarse pre-pass over the Z slices of the model.
+type Occupancy struct {
+	// Empty is true if the material was not found anywhere in the model.
+	Empty bool
+	// Min and Max are the tight MBB of the occupied material
+	// (in model units), accurate to within one coarse voxel.
+	Min, Max [3]float32
+
+	factor int
+	// bands holds the (conservatively dilated) full-resolution pixel bounds
+	// of the occupied material for each band of factor Z slices.
+	bands []image.Rectangle
+}
+
+// sliceBounds returns the pixel bounds of the occupied material
+// for Z slice n (where slice 0 is at the minimum Z of the model).
+func (o *Occupancy) sliceBounds(n int) image.Rectangle {
+	b := n / o.factor
+	if b < 0 || b >= len(o.bands) {
+		return image.Rectangle{}
+	}
+	return o.bands[b]
+}
+
+// SetSkipEmpty enables a coarse pre-pass (at 1/factor of the full resolution
+// in each dimension) for each material before its Z slices are rendered.
+// The full-resolution rendering then skips the empty regions of the model,
+// while the ZSliceProcessor still receives every slice (with empty regions
+// left blank). Values of factor less than 2 disable the pre-pass.
+//
+// The pre-pass is conservative: each coarse voxel is occupied if any
+// full-resolution voxel center within it is occupied in the first,
+// middle or last slice of its band of slices. Only horizontal features
+// thinner than about factor/2 slices that lie between the sampled slices
+// may be missed.
+func (s *Slicer) SetSkipEmpty(factor int) {
+	if factor < 2 {
+		factor = 0
+	}
+	s.coarseFactor = factor
+	s.occupancy = nil
+}
+
+// zOccupancy returns the cached coarse pre-pass results for materialNum,
+// performing the pre-pass if necessary.
+func (s *Slicer) zOccupancy(materialNum int) (*Occupancy, error) {
+	if o, ok := s.occupancy[materialNum]; ok {
+		return o, nil
+	}
+
+	o, err := s.ScanOccupancy(materialNum, s.coarseFactor)
+	if err != nil {
+		return nil, err
+	}
+	if s.occupancy == nil {
+		s.occupancy = map[int]*Occupancy{}
+	}
+	s.occupancy[materialNum] = o
+
+	if o.Empty {
+		log.Printf("Material #%v is empty", materialNum)
+	} else {
+		log.Printf("Material #%v occupies MBB=(%v,%v,%v)-(%v,%v,%v)", materialNum, o.Min[0], o.Min[1], o.Min[2], o.Max[0], o.Max[1], o.Max[2])
+	}
+	return o, nil
+}
+
+// ScanOccupancy performs a coarse pre-pass over the Z slices of
+// the given materialNum (1-based index) at 1/factor of the full
+// resolution in each dimension and returns the regions occupied
+// by the material. PrepareRenderZ must be called first.
+func (s *Slicer) ScanOccupancy(materialNum, factor int) (*Occupancy, error) {
+	if err := s.checkPrepared(); err != nil {
+		return nil, err
+	}
+	if factor < 1 {
+		factor = 1
+	}
+
+	full := s.fullGrid()
+	g := renderGrid{
+		width:       (full.width + factor - 1) / factor,
+		height:      (full.height + factor - 1) / factor,
+		pixelWidth:  full.pixelWidth * float32(factor),
+		pixelHeight: full.pixelHeight * float32(factor),
+	}
+
+	// Each coarse voxel is sampled at the centers of all the
+	// full-resolution voxels within it, keeping the maximum, so that
+	// thin features between the coarse voxel centers are not missed.
+	samplesXY, samplesZ, sampleMax := s.samplesXY, s.samplesZ, s.sampleMax
+	s.samplesXY, s.samplesZ, s.sampleMax = factor, 1, true
+	defer func() { s.samplesXY, s.samplesZ, s.sampleMax = samplesXY, samplesZ, sampleMax }()
+
+	numSlices := s.NumZSlices()
+	numBands := (numSlices + factor - 1) / factor
+	log.Printf("ScanOccupancy(%v): %v bands of %vx%v coarse voxels", materialNum, numBands, g.width, g.height)
+
+	cells := make([]image.Rectangle, numBands) // coarse pixel bounds
+	for b := range cells {
+		for _, n := range bandSlices(b, factor, numSlices) {
+			z := s.irmf.Min[2] + (float32(n)+0.5)*s.deltaZ
+			img, err := s.renderImage(z, materialNum, g, g.bounds())
+			if err != nil {
+				return nil, fmt.Errorf("ScanOccupancy(%v,%v): %v", z, materialNum, err)
+			}
+			cells[b] = cells[b].Union(occupiedBounds(img))
+		}
+	}
+
+	o := &Occupancy{Empty: true, factor: factor, bands: make([]image.Rectangle, numBands)}
+	var occupied image.Rectangle
+	firstBand, lastBand := -1, -1
+	for b, r := range cells {
+		if r.Empty() {
+			continue
+		}
+		occupied = occupied.Union(r)
+		if firstBand < 0 {
+			firstBand = b
+		}
+		lastBand = b
+	}
+	if firstBand < 0 {
+		return o, nil
+	}
+	o.Empty = false
+
+	// Dilate the occupied regions by one coarse voxel in each direction
+	// to account for features that fall between the sampled slices.
+	for b := range cells {
+		var r image.Rectangle
+		for nb := b - 1; nb <= b+1; nb++ {
+			if nb >= 0 && nb < numBands {
+				r = r.Union(cells[nb])
+			}
+		}
+		if r.Empty() {
+			continue
+		}
+		r = image.Rect((r.Min.X-1)*factor, (r.Min.Y-1)*factor, (r.Max.X+1)*factor, (r.Max.Y+1)*factor)
+		o.bands[b] = r.Intersect(full.bounds())
+	}
+
+	clamp := func(v float32, axis int) float32 {
+		if v < s.irmf.Min[axis] {
+			return s.irmf.Min[axis]
+		}
+		if v > s.irmf.Max[axis] {
+			return s.irmf.Max[axis]
+		}
+		return v
+	}
+	o.Min[0] = clamp(s.left+float32(occupied.Min.X)*g.pixelWidth, 0)
+	o.Min[1] = clamp(s.bottom+float32(occupied.Min.Y)*g.pixelHeight, 1)
+	o.Min[2] = clamp(s.irmf.Min[2]+float32(firstBand*factor)*s.deltaZ, 2)
+	o.Max[0] = clamp(s.left+float32(occupied.Max.X)*g.pixelWidth, 0)
+	o.Max[1] = clamp(s.bottom+float32(occupied.Max.Y)*g.pixelHeight, 1)
+	o.Max[2] = clamp(s.irmf.Min[2]+float32((lastBand+1)*factor)*s.deltaZ, 2)
+
+	return o, nil
+}
+
+// bandSlices returns the (distinct) Z slices sampled by the coarse
+// pre-pass for band b of factor slices: the first, middle and last slices.
+func bandSlices(b, factor, numSlices int) []int {
+	first, last := b*factor, (b+1)*factor-1
+	if last >= numSlices {
+		last = numSlices - 1
+	}
+	slices := []int{first}
+	if mid := (first + last) / 2; mid > first {
+		slices = append(slices, mid)
+	}
+	if last > slices[len(slices)-1] {
+		slices = append(slices, last)
+	}
+	return slices
+}
+
+// occupiedBounds returns the bounds of the non-empty pixels in img.
+func occupiedBounds(img *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[(y-b.Min.Y)*img.Stride:]
+		minX, maxX := -1, -1
+		for x := 0; x < b.Dx(); x++ {
+			if row[x*4] != 0 {
+				if minX < 0 {
+					minX = x
+				}
+				maxX = x
+			}
+		}
+		if minX >= 0 {
+			r = r.Union(image.Rect(b.Min.X+minX, y, b.Min.X+maxX+1, y+1))
+		}
+	}
+	return r
+}
+
+// Fit performs a coarse pre-pass (at 1/factor of the full resolution)
+// over every material of the model and then shrinks the MBB of the model
+// to the tight MBB of all the occupied material, padded by one coarse
+// voxel plus margin (in model units) on every side. The MBB never grows
+// beyond the original MBB of the model. The new MBB is returned.
+//
+// Subsequent renders use the new MBB, and (*IRMF).Format can be used
+// to write out the model with the corrected "min" and "max" values.
+func (s *Slicer) Fit(factor int, margin float32) (min, max [3]float32, err error) {
+	if err := s.checkModel(); err != nil {
+		return min, max, err
+	}
+	if factor < 1 {
+		factor = 1
+	}
+
+	if err := s.PrepareRenderZ(); err != nil {
+		return min, max, fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	found := false
+	for materialNum := 1; materialNum <= s.NumMaterials(); materialNum++ {
+		o, err := s.ScanOccupancy(materialNum, factor)
+		if err != nil {
+			return min, max, err
+		}
+		if o.Empty {
+			continue
+		}
+		if !found {
+			min, max = o.Min, o.Max
+			found = true
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			if o.Min[i] < min[i] {
+				min[i] = o.Min[i]
+			}
+			if o.Max[i] > max[i] {
+				max[i] = o.Max[i]
+			}
+		}
+	}
+	if !found {
+		return min, max, errors.New("Fit: no material found in model")
+	}
+
+	pad := [3]float32{
+		float32(factor)*s.deltaX + margin,
+		float32(factor)*s.deltaY + margin,
+		float32(factor)*s.deltaZ + margin,
+	}
+	for i := 0; i < 3; i++ {
+		min[i] -= pad[i]
+		if min[i] < s.irmf.Min[i] {
+			min[i] = s.irmf.Min[i]
+		}
+		max[i] += pad[i]
+		if max[i] > s.irmf.Max[i] {
+			max[i] = s.irmf.Max[i]
+		}
+	}
+
+	s.irmf.Min = []float32{min[0], min[1], min[2]}
+	s.irmf.Max = []float32{max[0], max[1], max[2]}
+	s.occupancy = nil
+
+	return min, max, nil
+}
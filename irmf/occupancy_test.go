@@ -0,0 +1,61 @@
+package irmf
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestOccupiedBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		pixels []image.Point
+		want   image.Rectangle
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "single pixel",
+			pixels: []image.Point{{3, 4}},
+			want:   image.Rect(3, 4, 4, 5),
+		},
+		{
+			name:   "corners",
+			pixels: []image.Point{{0, 9}, {7, 2}},
+			want:   image.Rect(0, 2, 8, 10),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 8, 10))
+			for _, p := range tt.pixels {
+				img.Set(p.X, p.Y, color.White)
+			}
+			if got := occupiedBounds(img); got != tt.want {
+				t.Errorf("occupiedBounds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBandSlices(t *testing.T) {
+	tests := []struct {
+		b, factor, numSlices int
+		want                 []int
+	}{
+		{b: 0, factor: 8, numSlices: 20, want: []int{0, 3, 7}},
+		{b: 1, factor: 8, numSlices: 20, want: []int{8, 11, 15}},
+		{b: 2, factor: 8, numSlices: 20, want: []int{16, 17, 19}}, // partial band
+		{b: 0, factor: 2, numSlices: 20, want: []int{0, 1}},
+		{b: 3, factor: 4, numSlices: 13, want: []int{12}}, // single-slice band
+	}
+
+	for _, tt := range tests {
+		if got := bandSlices(tt.b, tt.factor, tt.numSlices); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("bandSlices(%v,%v,%v) = %v, want %v", tt.b, tt.factor, tt.numSlices, got, tt.want)
+		}
+	}
+}
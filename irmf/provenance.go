@@ -0,0 +1,73 @@
+package irmf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxProvenance is the size of a binary STL header.
+	maxProvenance = 80
+	// provenanceHashLen is the number of hex digits of the source hash
+	// included in the provenance.
+	provenanceHashLen = 16
+)
+
+// Provenance returns a compact (at most 80 byte) description of the model
+// and settings used to slice the given material (1-based), suitable for
+// an STL file header. For example:
+//
+//	IRMF Rounded cube; PLA; 42x42x42um; sha256:0123456789abcdef
+//
+// The hash is the start of the SHA-256 hash of the IRMF file.
+func (s *Slicer) Provenance(materialNum int) string {
+	if s.irmf == nil {
+		return ""
+	}
+	res := fmt.Sprintf("%vx%vx%vum", formatMicrons(s.deltaX), formatMicrons(s.deltaY), formatMicrons(s.deltaZ))
+	return provenance(s.irmf.Title, s.MaterialName(materialNum), res, s.irmf.SourceHash())
+}
+
+// formatMicrons formats a size in millimeters as microns.
+func formatMicrons(mm float32) string {
+	return strconv.FormatFloat(1000*float64(mm), 'g', 6, 64)
+}
+
+// provenance builds the provenance string, truncating the title
+// (and then the material name) as needed to fit.
+func provenance(title, material, res, hash string) string {
+	title, material = oneLine(title), oneLine(material)
+	if len(hash) > provenanceHashLen {
+		hash = hash[:provenanceHashLen]
+	}
+	suffix := fmt.Sprintf("; %v; sha256:%v", res, hash)
+
+	room := maxProvenance - len("IRMF ") - len("; ") - len(suffix)
+	if len(title)+len(material) > room {
+		title = strings.TrimSpace(truncate(title, room-len(material)))
+	}
+	if len(title)+len(material) > room {
+		material = strings.TrimSpace(truncate(material, room-len(title)))
+	}
+	return fmt.Sprintf("IRMF %v; %v%v", title, material, suffix)
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 character.
+func truncate(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,59 @@
+package irmf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	hash := strings.Repeat("0123456789abcdef", 4)
+	tests := []struct {
+		name     string
+		title    string
+		material string
+		want     string
+	}{
+		{
+			name:     "short",
+			title:    "Rounded cube",
+			material: "PLA",
+			want:     "IRMF Rounded cube; PLA; 42x42x42um; sha256:0123456789abcdef",
+		},
+		{
+			name:     "multi-line title",
+			title:    "Rounded\n  cube",
+			material: "PLA",
+			want:     "IRMF Rounded cube; PLA; 42x42x42um; sha256:0123456789abcdef",
+		},
+		{
+			name:     "long title",
+			title:    strings.Repeat("title ", 20),
+			material: "dielectric",
+			want:     "IRMF title title title title ti; dielectric; 42x42x42um; sha256:0123456789abcdef",
+		},
+		{
+			name:     "long title and material",
+			title:    strings.Repeat("title ", 20),
+			material: strings.Repeat("material ", 10),
+			want:     "IRMF ; material material material material; 42x42x42um; sha256:0123456789abcdef",
+		},
+		{
+			name:     "does not split UTF-8",
+			title:    strings.Repeat("é", 40),
+			material: "PLA",
+			want:     "IRMF " + strings.Repeat("é", 16) + "; PLA; 42x42x42um; sha256:0123456789abcdef",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := provenance(tt.title, tt.material, "42x42x42um", hash)
+			if got != tt.want {
+				t.Errorf("provenance = %q, want %q", got, tt.want)
+			}
+			if len(got) > 80 {
+				t.Errorf("len(provenance) = %v, want <= 80", len(got))
+			}
+		})
+	}
+}
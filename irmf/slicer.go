@@ -1,6 +1,7 @@
 package irmf
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"log"
@@ -17,22 +18,56 @@ func init() {
 	runtime.LockOSThread()
 }
 
+// defaultMaxTileSize is the default maximum width and height (in pixels)
+// of the window used to render each tile of a slice.
+const defaultMaxTileSize = 2048
+
 // Slicer represents a slicer context.
 type Slicer struct {
 	irmf   *IRMF
-	width  int
-	height int
+	width  int // window width
+	height int // window height
 	window *glfw.Window
 	deltaX float32 // millimeters (model units)
 	deltaY float32
 	deltaZ float32
 	view   bool
 
+	maxTileWidth  int
+	maxTileHeight int
+
+	// samplesXY and samplesZ are the number of supersamples per voxel
+	// in each of the two image dimensions and in the slice depth dimension.
+	samplesXY int
+	samplesZ  int
+	// sampleMax combines the supersamples of each voxel with their maximum
+	// rather than their average (as used by the coarse pre-pass).
+	sampleMax bool
+	// sliceDelta is the thickness of each slice along the current render axis.
+	sliceDelta float32
+
+	// coarseFactor enables the coarse pre-pass that skips empty regions
+	// when greater than 1. occupancy caches the results per material.
+	coarseFactor int
+	occupancy    map[int]*Occupancy
+
+	// imgWidth and imgHeight are the full dimensions of each rendered slice,
+	// which may be larger than the window when rendering in tiles.
+	imgWidth  int
+	imgHeight int
+	// left, right, bottom, and top define the orthographic projection
+	// of the full slice.
+	left   float32
+	right  float32
+	bottom float32
+	top    float32
+
 	program uint32
 	model   mgl32.Mat4
 	vao     uint32
 
 	modelUniform        int32
+	projectionUniform   int32
 	uMaterialNumUniform int32
 	uSliceUniform       int32 // u_slice => x, y, or z
 }
@@ -40,13 +75,58 @@ type Slicer struct {
 // Init returns a new Slicer instance.
 func Init(view bool, umXRes, umYRes, umZRes float32) *Slicer {
 	// TODO: Support units other than millimeters.
-	return &Slicer{deltaX: umXRes / 1000.0, deltaY: umYRes / 1000.0, deltaZ: umZRes / 1000.0, view: view}
+	return &Slicer{
+		deltaX:        umXRes / 1000.0,
+		deltaY:        umYRes / 1000.0,
+		deltaZ:        umZRes / 1000.0,
+		view:          view,
+		maxTileWidth:  defaultMaxTileSize,
+		maxTileHeight: defaultMaxTileSize,
+		samplesXY:     1,
+		samplesZ:      1,
+	}
+}
+
+// SetResolution sets the size of each voxel in microns.
+func (s *Slicer) SetResolution(umXRes, umYRes, umZRes float32) {
+	s.deltaX = umXRes / 1000.0
+	s.deltaY = umYRes / 1000.0
+	s.deltaZ = umZRes / 1000.0
+}
+
+// SetSupersampling sets the number of samples taken per voxel.
+// Each voxel is sampled on an xy-by-xy grid within each slice
+// and at z sub-layer depths within the slice thickness, and the results
+// are averaged to provide the fractional coverage of the voxel
+// (where 0 is empty and 255 is fully occupied).
+// Values less than 1 are treated as 1 (which samples only the voxel center).
+func (s *Slicer) SetSupersampling(xy, z int) {
+	if xy < 1 {
+		xy = 1
+	}
+	if z < 1 {
+		z = 1
+	}
+	s.samplesXY, s.samplesZ = xy, z
+}
+
+// SetMaxTileSize sets the maximum size (in pixels) of the window used
+// to render slices. Slices larger than this are rendered in tiles
+// and then assembled into a single image. Values less than 1 are ignored.
+func (s *Slicer) SetMaxTileSize(width, height int) {
+	if width > 0 {
+		s.maxTileWidth = width
+	}
+	if height > 0 {
+		s.maxTileHeight = height
+	}
 }
 
 // NewModel prepares the slicer to slice a new shader model.
 func (s *Slicer) NewModel(shaderSrc []byte) error {
 	irmf, err := newModel(shaderSrc)
 	s.irmf = irmf
+	s.occupancy = nil
 	return err
 }
 
@@ -69,34 +149,69 @@ func (s *Slicer) NumMaterials() int {
 
 // MaterialName returns the name of the n-th material (1-based).
 func (s *Slicer) MaterialName(n int) string {
-	if s.irmf == nil || n > len(s.irmf.Materials) {
+	if s.irmf == nil || n < 1 || n > len(s.irmf.Materials) {
 		return ""
 	}
 	return s.irmf.Materials[n-1]
 }
 
 // MBB returns the MBB of the IRMF model.
+// It returns zero values if there is no valid model.
 func (s *Slicer) MBB() (min, max [3]float32) {
-	if s.irmf != nil {
-		if len(s.irmf.Min) != 3 || len(s.irmf.Max) != 3 {
-			log.Fatalf("Bad IRMF model: min=%#v, max=%#v", s.irmf.Min, s.irmf.Max)
-		}
-		min[0], min[1], min[2] = s.irmf.Min[0], s.irmf.Min[1], s.irmf.Min[2]
-		max[0], max[1], max[2] = s.irmf.Max[0], s.irmf.Max[1], s.irmf.Max[2]
+	if s.checkModel() != nil {
+		return min, max
 	}
+	min[0], min[1], min[2] = s.irmf.Min[0], s.irmf.Min[1], s.irmf.Min[2]
+	max[0], max[1], max[2] = s.irmf.Max[0], s.irmf.Max[1], s.irmf.Max[2]
 	return min, max
 }
 
-func (s *Slicer) createOrResizeWindow(width, height int) {
+// ErrNotPrepared is returned when slices are rendered before
+// the GPU has been prepared (e.g. by PrepareRenderZ).
+var ErrNotPrepared = errors.New("slicer is not prepared to render")
+
+// checkModel returns a *ModelError if the current model can not be sliced.
+func (s *Slicer) checkModel() error {
+	if s.irmf == nil {
+		return &ModelError{Msg: "no model loaded"}
+	}
+	if len(s.irmf.Min) != 3 || len(s.irmf.Max) != 3 {
+		return &ModelError{Msg: fmt.Sprintf("min=%#v, max=%#v", s.irmf.Min, s.irmf.Max)}
+	}
+	for i := 0; i < 3; i++ {
+		if s.irmf.Min[i] >= s.irmf.Max[i] {
+			return &ModelError{Msg: fmt.Sprintf("min=%v must be strictly less than max=%v", s.irmf.Min, s.irmf.Max)}
+		}
+	}
+	if s.deltaX <= 0 || s.deltaY <= 0 || s.deltaZ <= 0 {
+		return &ModelError{Msg: fmt.Sprintf("invalid resolution (%v,%v,%v)", s.deltaX, s.deltaY, s.deltaZ)}
+	}
+	return nil
+}
+
+// checkPrepared returns an error if slices can not be rendered yet.
+func (s *Slicer) checkPrepared() error {
+	if err := s.checkModel(); err != nil {
+		return err
+	}
+	if s.window == nil {
+		return ErrNotPrepared
+	}
+	return nil
+}
+
+func (s *Slicer) createOrResizeWindow(width, height int) error {
 	log.Printf("createOrResizeWindow(%v,%v)", width, height)
 	if s.window != nil {
 		glfw.Terminate()
+		s.window = nil
 	}
-	s.width = width
-	s.height = height
+	s.width = 0
+	s.height = 0
 
-	err := glfw.Init()
-	check("glfw.Init: %v", err)
+	if err := glfw.Init(); err != nil {
+		return &InitError{Op: "glfw.Init", Err: err}
+	}
 
 	glfw.WindowHint(glfw.Resizable, glfw.False)
 	glfw.WindowHint(glfw.ContextVersionMajor, 4)
@@ -106,15 +221,25 @@ func (s *Slicer) createOrResizeWindow(width, height int) {
 	if !s.view {
 		glfw.WindowHint(glfw.Visible, glfw.False)
 	}
-	s.window, err = glfw.CreateWindow(width, height, "IRMF Slicer", nil, nil)
-	check("CreateWindow(%v,%v): %v", width, height, err)
-	s.window.MakeContextCurrent()
+	window, err := glfw.CreateWindow(width, height, "IRMF Slicer", nil, nil)
+	if err != nil {
+		glfw.Terminate()
+		return &InitError{Op: fmt.Sprintf("CreateWindow(%v,%v)", width, height), Err: err}
+	}
+	window.MakeContextCurrent()
 
-	err = gl.Init()
-	check("gl.Init: %v", err)
+	if err := gl.Init(); err != nil {
+		glfw.Terminate()
+		return &InitError{Op: "gl.Init", Err: err}
+	}
+
+	s.window = window
+	s.width = width
+	s.height = height
 
 	version := gl.GoStr(gl.GetString(gl.VERSION))
 	fmt.Println("OpenGL version", version)
+	return nil
 }
 
 // XSliceProcessor represents a X slice processor.
@@ -142,6 +267,9 @@ const (
 
 // NumXSlices returns the number of slices in the X direction.
 func (s *Slicer) NumXSlices() int {
+	if s.checkModel() != nil {
+		return 0
+	}
 	n := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
 	if n%2 == 1 {
 		n++
@@ -152,6 +280,9 @@ func (s *Slicer) NumXSlices() int {
 // RenderXSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderXSlices(materialNum int, sp XSliceProcessor, order Order) error {
+	if err := s.checkPrepared(); err != nil {
+		return err
+	}
 	numSlices := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
 	voxelRadiusX := 0.5 * s.deltaX
 	minVal := s.irmf.Min[0] + voxelRadiusX
@@ -187,6 +318,9 @@ func (s *Slicer) RenderXSlices(materialNum int, sp XSliceProcessor, order Order)
 
 // NumYSlices returns the number of slices in the Y direction.
 func (s *Slicer) NumYSlices() int {
+	if s.checkModel() != nil {
+		return 0
+	}
 	nx := int(0.5 + (s.irmf.Max[0]-s.irmf.Min[0])/s.deltaX)
 	ny := int(0.5 + (s.irmf.Max[1]-s.irmf.Min[1])/s.deltaY)
 	if nx%2 == 1 {
@@ -198,6 +332,9 @@ func (s *Slicer) NumYSlices() int {
 // RenderYSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderYSlices(materialNum int, sp YSliceProcessor, order Order) error {
+	if err := s.checkPrepared(); err != nil {
+		return err
+	}
 	numSlices := int(0.5 + (s.irmf.Max[1]-s.irmf.Min[1])/s.deltaY)
 	voxelRadiusY := 0.5 * s.deltaY
 	minVal := s.irmf.Min[1] + voxelRadiusY
@@ -233,12 +370,18 @@ func (s *Slicer) RenderYSlices(materialNum int, sp YSliceProcessor, order Order)
 
 // NumZSlices returns the number of slices in the Z direction.
 func (s *Slicer) NumZSlices() int {
+	if s.checkModel() != nil {
+		return 0
+	}
 	return int(0.5 + (s.irmf.Max[2]-s.irmf.Min[2])/s.deltaZ)
 }
 
 // RenderZSlices slices the given materialNum (1-based index)
 // to an image, calling the SliceProcessor for each slice.
 func (s *Slicer) RenderZSlices(materialNum int, sp ZSliceProcessor, order Order) error {
+	if err := s.checkPrepared(); err != nil {
+		return err
+	}
 	numSlices := int(0.5 + (s.irmf.Max[2]-s.irmf.Min[2])/s.deltaZ)
 	voxelRadiusZ := 0.5 * s.deltaZ
 	minVal := s.irmf.Min[2] + voxelRadiusZ
@@ -258,10 +401,28 @@ func (s *Slicer) RenderZSlices(materialNum int, sp ZSliceProcessor, order Order)
 
 	// log.Printf("RenderZSlices: numSlices=%v, startVal=%v, endVal=%v, delta=%v", numSlices, zFunc(0), zFunc(numSlices-1), s.delta)
 
+	var occupancy *Occupancy
+	if s.coarseFactor > 1 {
+		var err error
+		if occupancy, err = s.zOccupancy(materialNum); err != nil {
+			return err
+		}
+	}
+
 	for n := 0; n < numSlices; n++ {
 		z := zFunc(n)
 
-		img, err := s.renderSlice(z, materialNum)
+		var img image.Image
+		var err error
+		if occupancy != nil {
+			sliceIndex := n
+			if order == MaxToMin {
+				sliceIndex = numSlices - n - 1
+			}
+			img, err = s.renderOccupiedSlice(z, materialNum, occupancy.sliceBounds(sliceIndex))
+		} else {
+			img, err = s.renderSlice(z, materialNum)
+		}
 		if err != nil {
 			return fmt.Errorf("renderZSlice(%v,%v): %v", z, materialNum, err)
 		}
@@ -273,36 +434,99 @@ func (s *Slicer) RenderZSlices(materialNum int, sp ZSliceProcessor, order Order)
 }
 
 func (s *Slicer) renderSlice(sliceDepth float32, materialNum int) (image.Image, error) {
-	if e := gl.GetError(); e != gl.NO_ERROR {
-		fmt.Printf("renderSlice, before gl.Clear: GL ERROR: %v", e)
+	g := s.fullGrid()
+	return s.renderImage(sliceDepth, materialNum, g, g.bounds())
+}
+
+// renderOccupiedSlice renders only the pixels of the slice within r,
+// which are the only pixels that may be occupied by the material.
+func (s *Slicer) renderOccupiedSlice(sliceDepth float32, materialNum int, r image.Rectangle) (image.Image, error) {
+	g := s.fullGrid()
+	if r.Empty() {
+		return image.NewRGBA(g.bounds()), nil
 	}
+	return s.renderImage(sliceDepth, materialNum, g, r)
+}
 
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+// renderGrid describes the pixels of a rendered image. Pixel (0,0) is
+// always at the lower-left corner of the projection of the current slice.
+type renderGrid struct {
+	width       int
+	height      int
+	pixelWidth  float32 // model units
+	pixelHeight float32
+}
+
+func (g renderGrid) bounds() image.Rectangle {
+	return image.Rect(0, 0, g.width, g.height)
+}
+
+// fullGrid returns the full-resolution grid of the current slice.
+func (s *Slicer) fullGrid() renderGrid {
+	return renderGrid{
+		width:       s.imgWidth,
+		height:      s.imgHeight,
+		pixelWidth:  (s.right - s.left) / float32(s.imgWidth),
+		pixelHeight: (s.top - s.bottom) / float32(s.imgHeight),
+	}
+}
+
+// renderImage renders the pixels of grid g that lie within r
+// (in tiles, if necessary). All other pixels of the image are left empty.
+func (s *Slicer) renderImage(sliceDepth float32, materialNum int, g renderGrid, r image.Rectangle) (*image.RGBA, error) {
+	if e := gl.GetError(); e != gl.NO_ERROR {
+		return nil, &GLError{Op: "before rendering slice", Code: e}
+	}
 
-	// Render
 	gl.UseProgram(s.program)
 	gl.UniformMatrix4fv(s.modelUniform, 1, false, &s.model[0])
-	gl.Uniform1f(s.uSliceUniform, float32(sliceDepth))
 	gl.Uniform1i(s.uMaterialNumUniform, int32(materialNum))
 
 	gl.BindVertexArray(s.vao)
 
-	gl.DrawArrays(gl.TRIANGLES, 0, 2*3) // 6*2*3)
-
-	if e := gl.GetError(); e != gl.NO_ERROR {
-		fmt.Printf("renderSlice, after gl.DrawArrays: GL ERROR: %v", e)
-	}
-
-	width, height := s.window.GetFramebufferSize()
+	width, height := g.width, g.height
 	rgba := &image.RGBA{
 		Pix:    make([]uint8, width*height*4),
 		Stride: width * 4, // bytes between vertically adjacent pixels.
 		Rect:   image.Rect(0, 0, width, height),
 	}
-	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&rgba.Pix[0]))
+	r = r.Intersect(rgba.Rect)
 
-	if e := gl.GetError(); e != gl.NO_ERROR {
-		fmt.Printf("renderSlice, after gl.ReadPixels: GL ERROR: %v", e)
+	tileWidth, tileHeight := s.window.GetFramebufferSize()
+	tile := make([]uint8, tileWidth*tileHeight*4)
+	gl.Viewport(0, 0, int32(tileWidth), int32(tileHeight))
+
+	numSamples := s.samplesXY * s.samplesXY * s.samplesZ
+	var sum []uint32
+	if numSamples > 1 {
+		sum = make([]uint32, len(tile))
+	}
+
+	// Note that the rows of the image (and each tile) are in OpenGL order,
+	// which means that row 0 is at the bottom of the slice.
+	for ty := r.Min.Y; ty < r.Max.Y; ty += tileHeight {
+		for tx := r.Min.X; tx < r.Max.X; tx += tileWidth {
+			if numSamples == 1 {
+				gl.Uniform1f(s.uSliceUniform, float32(sliceDepth))
+				if err := s.renderTile(g, tx, ty, 0, 0, tileWidth, tileHeight, tile); err != nil {
+					return nil, err
+				}
+			} else if err := s.renderSupersampledTile(g, sliceDepth, tx, ty, tileWidth, tileHeight, tile, sum); err != nil {
+				return nil, err
+			}
+
+			w, h := tileWidth, tileHeight
+			if tx+w > width {
+				w = width - tx
+			}
+			if ty+h > height {
+				h = height - ty
+			}
+			for row := 0; row < h; row++ {
+				src := tile[row*tileWidth*4 : (row*tileWidth+w)*4]
+				copy(rgba.Pix[(ty+row)*rgba.Stride+tx*4:], src)
+			}
+		}
 	}
 
 	// Maintenance
@@ -312,8 +536,85 @@ func (s *Slicer) renderSlice(sliceDepth float32, materialNum int) (image.Image,
 	return rgba, nil
 }
 
+// renderSupersampledTile renders the tile at (tx,ty) once for each
+// supersample offset and stores the average (or, with s.sampleMax,
+// the maximum) of all the samples in buf.
+// sum is scratch space the same size as buf.
+func (s *Slicer) renderSupersampledTile(g renderGrid, sliceDepth float32, tx, ty, tileWidth, tileHeight int, buf []uint8, sum []uint32) error {
+	for i := range sum {
+		sum[i] = 0
+	}
+
+	// Sample offsets are evenly spaced within each voxel,
+	// in fractions of a voxel relative to its center.
+	offset := func(i, n int) float32 {
+		return (float32(i)+0.5)/float32(n) - 0.5
+	}
+
+	for zi := 0; zi < s.samplesZ; zi++ {
+		gl.Uniform1f(s.uSliceUniform, sliceDepth+offset(zi, s.samplesZ)*s.sliceDelta)
+		for yi := 0; yi < s.samplesXY; yi++ {
+			for xi := 0; xi < s.samplesXY; xi++ {
+				if err := s.renderTile(g, tx, ty, offset(xi, s.samplesXY), offset(yi, s.samplesXY), tileWidth, tileHeight, buf); err != nil {
+					return err
+				}
+				for i, v := range buf {
+					if !s.sampleMax {
+						sum[i] += uint32(v)
+					} else if uint32(v) > sum[i] {
+						sum[i] = uint32(v)
+					}
+				}
+			}
+		}
+	}
+
+	if s.sampleMax {
+		for i, v := range sum {
+			buf[i] = uint8(v)
+		}
+		return nil
+	}
+	numSamples := uint32(s.samplesXY * s.samplesXY * s.samplesZ)
+	for i, v := range sum {
+		buf[i] = uint8((v + numSamples/2) / numSamples)
+	}
+	return nil
+}
+
+// renderTile renders the tile of grid g whose lower-left pixel
+// is at (tx,ty) and reads the resulting pixels into buf.
+// The tile is shifted by (dx,dy) pixels, which is used for supersampling.
+func (s *Slicer) renderTile(g renderGrid, tx, ty int, dx, dy float32, tileWidth, tileHeight int, buf []uint8) error {
+	left := s.left + (float32(tx)+dx)*g.pixelWidth
+	right := left + float32(tileWidth)*g.pixelWidth
+	bottom := s.bottom + (float32(ty)+dy)*g.pixelHeight
+	top := bottom + float32(tileHeight)*g.pixelHeight
+
+	projection := mgl32.Ortho(left, right, bottom, top, nearPlane, farPlane)
+	gl.UniformMatrix4fv(s.projectionUniform, 1, false, &projection[0])
+
+	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+	gl.DrawArrays(gl.TRIANGLES, 0, 2*3) // 6*2*3)
+
+	if e := gl.GetError(); e != gl.NO_ERROR {
+		return &GLError{Op: fmt.Sprintf("renderTile(%v,%v): gl.DrawArrays", tx, ty), Code: e}
+	}
+
+	gl.ReadPixels(0, 0, int32(tileWidth), int32(tileHeight), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&buf[0]))
+
+	if e := gl.GetError(); e != gl.NO_ERROR {
+		return &GLError{Op: fmt.Sprintf("renderTile(%v,%v): gl.ReadPixels", tx, ty), Code: e}
+	}
+	return nil
+}
+
 // PrepareRenderX prepares the GPU to render along the X axis.
 func (s *Slicer) PrepareRenderX() error {
+	if err := s.checkModel(); err != nil {
+		return err
+	}
+	s.sliceDelta = s.deltaX
 	left := float32(s.irmf.Min[1])
 	right := float32(s.irmf.Max[1])
 	bottom := float32(s.irmf.Min[2])
@@ -339,6 +640,10 @@ func (s *Slicer) PrepareRenderX() error {
 
 // PrepareRenderY prepares the GPU to render along the Y axis.
 func (s *Slicer) PrepareRenderY() error {
+	if err := s.checkModel(); err != nil {
+		return err
+	}
+	s.sliceDelta = s.deltaY
 	left := float32(s.irmf.Min[0])
 	right := float32(s.irmf.Max[0])
 	bottom := float32(s.irmf.Min[2])
@@ -364,6 +669,10 @@ func (s *Slicer) PrepareRenderY() error {
 
 // PrepareRenderZ prepares the GPU to render along the Z axis.
 func (s *Slicer) PrepareRenderZ() error {
+	if err := s.checkModel(); err != nil {
+		return err
+	}
+	s.sliceDelta = s.deltaZ
 	left := float32(s.irmf.Min[0])
 	right := float32(s.irmf.Max[0])
 	bottom := float32(s.irmf.Min[1])
@@ -392,14 +701,29 @@ func (s *Slicer) prepareRender(newWidth, newHeight int, left, right, bottom, top
 		newWidth++
 		newHeight++
 	}
+	if s.imgWidth != newWidth || s.imgHeight != newHeight || s.left != left || s.right != right || s.bottom != bottom || s.top != top {
+		s.occupancy = nil // the coarse pre-pass results no longer apply.
+	}
+	s.imgWidth, s.imgHeight = newWidth, newHeight
+	s.left, s.right, s.bottom, s.top = left, right, bottom, top
+
+	// Slices larger than the maximum tile size are rendered in tiles.
+	windowWidth, windowHeight := newWidth, newHeight
+	if windowWidth > s.maxTileWidth {
+		windowWidth = s.maxTileWidth
+	}
+	if windowHeight > s.maxTileHeight {
+		windowHeight = s.maxTileHeight
+	}
 
 	// Create or resize window if necessary.
-	near, far := float32(0.1), float32(100.0)
-	resize := (s.width != newWidth || s.height != newHeight)
+	resize := (s.width != windowWidth || s.height != windowHeight)
 
-	log.Printf("prepareRender: (%v,%v)-(%v,%v), resize=%v", left, bottom, right, top, resize)
+	log.Printf("prepareRender: (%v,%v)-(%v,%v), image=(%v,%v), window=(%v,%v), resize=%v", left, bottom, right, top, newWidth, newHeight, windowWidth, windowHeight, resize)
 	if s.window == nil || resize {
-		s.createOrResizeWindow(newWidth, newHeight)
+		if err := s.createOrResizeWindow(windowWidth, windowHeight); err != nil {
+			return err
+		}
 	}
 
 	// Configure the vertex and fragment shaders
@@ -410,9 +734,9 @@ func (s *Slicer) prepareRender(newWidth, newHeight int, left, right, bottom, top
 
 	gl.UseProgram(s.program)
 
-	projection := mgl32.Ortho(left, right, bottom, top, near, far)
-	projectionUniform := gl.GetUniformLocation(s.program, gl.Str("projection\x00"))
-	gl.UniformMatrix4fv(projectionUniform, 1, false, &projection[0])
+	projection := mgl32.Ortho(left, right, bottom, top, nearPlane, farPlane)
+	s.projectionUniform = gl.GetUniformLocation(s.program, gl.Str("projection\x00"))
+	gl.UniformMatrix4fv(s.projectionUniform, 1, false, &projection[0])
 
 	cameraUniform := gl.GetUniformLocation(s.program, gl.Str("camera\x00"))
 	gl.UniformMatrix4fv(cameraUniform, 1, false, &camera[0])
@@ -510,6 +834,12 @@ func compileShader(source string, shaderType uint32) (uint32, error) {
 	return shader, nil
 }
 
+// nearPlane and farPlane are the clipping planes of the orthographic projection.
+const (
+	nearPlane = float32(0.1)
+	farPlane  = float32(100.0)
+)
+
 const vertexShader = `
 #version 330
 uniform mat4 projection;
@@ -563,13 +893,6 @@ var zPlaneVertices = []float32{
 	-1.0, 1.0, 0.0, 1.0, 1.0, // ul
 }
 
-func check(fmtStr string, args ...interface{}) {
-	err := args[len(args)-1]
-	if err != nil {
-		log.Fatalf(fmtStr, args...)
-	}
-}
-
 func genFooter(numMaterials int, vec3Str string) string {
 	switch numMaterials {
 	default:
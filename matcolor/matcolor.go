@@ -0,0 +1,126 @@
+// Package matcolor assigns display colors to the materials of an IRMF model.
+package matcolor
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// named are the colors recognized in material names and color specifications.
+var named = map[string]color.NRGBA{
+	"black":   {0x20, 0x20, 0x20, 0xff},
+	"blue":    {0x43, 0x63, 0xd8, 0xff},
+	"brass":   {0xb5, 0xa6, 0x42, 0xff},
+	"bronze":  {0xcd, 0x7f, 0x32, 0xff},
+	"brown":   {0x8b, 0x5a, 0x2b, 0xff},
+	"copper":  {0xb8, 0x73, 0x33, 0xff},
+	"cyan":    {0x42, 0xd4, 0xf4, 0xff},
+	"gold":    {0xd4, 0xaf, 0x37, 0xff},
+	"gray":    {0x80, 0x80, 0x80, 0xff},
+	"green":   {0x3c, 0xb4, 0x4b, 0xff},
+	"grey":    {0x80, 0x80, 0x80, 0xff},
+	"magenta": {0xf0, 0x32, 0xe6, 0xff},
+	"orange":  {0xf5, 0x82, 0x31, 0xff},
+	"pink":    {0xfa, 0xbe, 0xd4, 0xff},
+	"purple":  {0x91, 0x1e, 0xb4, 0xff},
+	"red":     {0xe6, 0x19, 0x4b, 0xff},
+	"silver":  {0xc0, 0xc0, 0xc0, 0xff},
+	"steel":   {0x8c, 0x92, 0xac, 0xff},
+	"white":   {0xf0, 0xf0, 0xf0, 0xff},
+	"yellow":  {0xff, 0xe1, 0x19, 0xff},
+}
+
+// palette provides distinct colors for materials whose names
+// do not mention a color.
+var palette = []color.NRGBA{
+	{0xc0, 0xc0, 0xc0, 0xff},
+	{0xe6, 0x19, 0x4b, 0xff},
+	{0x3c, 0xb4, 0x4b, 0xff},
+	{0x43, 0x63, 0xd8, 0xff},
+	{0xf5, 0x82, 0x31, 0xff},
+	{0x91, 0x1e, 0xb4, 0xff},
+	{0x42, 0xd4, 0xf4, 0xff},
+	{0xf0, 0x32, 0xe6, 0xff},
+	{0xbf, 0xef, 0x45, 0xff},
+	{0xff, 0xe1, 0x19, 0xff},
+}
+
+// Colors returns the color of each material (index 0 is material 1).
+// A color in overrides (keyed by 1-based material number) wins.
+// Otherwise, the first color mentioned in the material name is used
+// (e.g. "red" or "Copper wire"), falling back to a fixed palette.
+func Colors(materialNames []string, overrides map[int]color.NRGBA) []color.NRGBA {
+	colors := make([]color.NRGBA, len(materialNames))
+	for i, name := range materialNames {
+		if c, ok := overrides[i+1]; ok {
+			colors[i] = c
+			continue
+		}
+		colors[i] = palette[i%len(palette)]
+		words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+			return !unicode.IsLetter(r)
+		})
+		for _, word := range words {
+			if c, ok := named[word]; ok {
+				colors[i] = c
+				break
+			}
+		}
+	}
+	return colors
+}
+
+// Parse parses a color given as "#RRGGBB", "#RRGGBBAA", or a color name.
+func Parse(s string) (color.NRGBA, error) {
+	s = strings.TrimSpace(s)
+	if c, ok := named[strings.ToLower(s)]; ok {
+		return c, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q (want #RRGGBB or a color name)", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q (want #RRGGBB or a color name)", s)
+	}
+	if len(hex) == 6 {
+		v = v<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// ParseOverrides parses per-material colors such as "1=#FF0000,3=blue".
+func ParseOverrides(s string) (map[int]color.NRGBA, error) {
+	overrides := map[int]color.NRGBA{}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid material color %q (want N=color)", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid material number in %q", part)
+		}
+		c, err := Parse(kv[1])
+		if err != nil {
+			return nil, err
+		}
+		overrides[n] = c
+	}
+	return overrides, nil
+}
+
+// Hex returns the color as "#RRGGBB" (or "#RRGGBBAA" if not opaque).
+func Hex(c color.NRGBA) string {
+	if c.A == 0xff {
+		return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02X%02X%02X%02X", c.R, c.G, c.B, c.A)
+}
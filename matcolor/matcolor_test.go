@@ -0,0 +1,60 @@
+package matcolor
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.NRGBA
+		wantErr bool
+	}{
+		{s: "#FF8000", want: color.NRGBA{0xff, 0x80, 0x00, 0xff}},
+		{s: "ff800080", want: color.NRGBA{0xff, 0x80, 0x00, 0x80}},
+		{s: " Red ", want: named["red"]},
+		{s: "#FF80", wantErr: true},
+		{s: "#GGGGGG", wantErr: true},
+		{s: "chartreuse", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := Parse(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColors(t *testing.T) {
+	overrides, err := ParseOverrides("3=#010203, 4=blue")
+	if err != nil {
+		t.Fatalf("ParseOverrides: %v", err)
+	}
+	names := []string{"PLA", "Copper-wire", "red", "gold"}
+	got := Colors(names, overrides)
+	want := []color.NRGBA{palette[0], named["copper"], {1, 2, 3, 0xff}, named["blue"]}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Colors[%v] (%v) = %v, want %v", i, names[i], Hex(got[i]), Hex(want[i]))
+		}
+	}
+
+	for _, s := range []string{"1", "x=red", "0=red", "1=nope"} {
+		if _, err := ParseOverrides(s); err == nil {
+			t.Errorf("ParseOverrides(%q) = nil, want error", s)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package mesh
+
+import "sort"
+
+// Bodies splits the mesh into its disconnected bodies, largest first.
+// Each body is an outer shell together with the shells of any
+// cavities directly within it.
+func (m *Mesh) Bodies() []*Mesh {
+	shells := m.Shells()
+	containers := m.shellContainers(shells)
+
+	// Outer shells are within an even number of other shells.
+	// Each cavity belongs to its innermost container, which is the
+	// container that is itself within the most shells.
+	body := make([]int, len(shells))
+	var outer []int
+	for i := range shells {
+		if len(containers[i])%2 == 0 {
+			body[i] = len(outer)
+			outer = append(outer, i)
+		}
+	}
+	tris := make([][]int, len(outer))
+	for i, shell := range shells {
+		owner := i
+		if len(containers[i])%2 == 1 {
+			owner = containers[i][0]
+			for _, c := range containers[i] {
+				if len(containers[c]) > len(containers[owner]) {
+					owner = c
+				}
+			}
+		}
+		tris[body[owner]] = append(tris[body[owner]], shell...)
+	}
+
+	bodies := make([]*Mesh, len(tris))
+	volumes := make([]float64, len(tris))
+	for i, ts := range tris {
+		sort.Ints(ts)
+		bodies[i] = m.subMesh(ts)
+		volumes[i] = bodies[i].Volume()
+	}
+	order := make([]int, len(bodies))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return volumes[order[a]] > volumes[order[b]]
+	})
+	sorted := make([]*Mesh, len(bodies))
+	for i, n := range order {
+		sorted[i] = bodies[n]
+	}
+	return sorted
+}
+
+// subMesh returns a new mesh made of the given triangles of m.
+func (m *Mesh) subMesh(tris []int) *Mesh {
+	part := &Mesh{Verts: m.Verts, Tris: make([][3]int, 0, len(tris))}
+	for _, t := range tris {
+		part.Tris = append(part.Tris, m.Tris[t])
+	}
+	part.compact()
+	return part
+}
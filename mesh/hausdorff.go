@@ -0,0 +1,188 @@
+package mesh
+
+import "math"
+
+// Hausdorff returns the (vertex-sampled) symmetric Hausdorff distance
+// between meshes a and b: the largest distance from any vertex of
+// either mesh to the nearest point on the surface of the other mesh.
+func Hausdorff(a, b *Mesh) float64 {
+	d1 := newTriGrid(b).maxDistance(a.Verts)
+	d2 := newTriGrid(a).maxDistance(b.Verts)
+	if d2 > d1 {
+		return d2
+	}
+	return d1
+}
+
+// triGrid is a uniform grid of triangle indices used to accelerate
+// closest-point queries.
+type triGrid struct {
+	m        *Mesh
+	min      [3]float32
+	cellSize float32
+	dims     [3]int
+	cells    map[[3]int][]int
+}
+
+func newTriGrid(m *Mesh) *triGrid {
+	g := &triGrid{m: m, cells: map[[3]int][]int{}}
+	if len(m.Tris) == 0 {
+		return g
+	}
+	min, max := m.Bounds()
+	g.min = min
+
+	// Aim for roughly one triangle per cell.
+	var longest float32
+	for k := 0; k < 3; k++ {
+		if d := max[k] - min[k]; d > longest {
+			longest = d
+		}
+	}
+	n := math.Cbrt(float64(len(m.Tris)))
+	g.cellSize = longest / float32(n)
+	if g.cellSize <= 0 {
+		g.cellSize = 1
+	}
+	for k := 0; k < 3; k++ {
+		g.dims[k] = int((max[k]-min[k])/g.cellSize) + 1
+	}
+
+	for i, t := range m.Tris {
+		lo, hi := m.Verts[t[0]], m.Verts[t[0]]
+		for _, v := range t[1:] {
+			for k := 0; k < 3; k++ {
+				if p := m.Verts[v][k]; p < lo[k] {
+					lo[k] = p
+				} else if p > hi[k] {
+					hi[k] = p
+				}
+			}
+		}
+		c0, c1 := g.cell(lo), g.cell(hi)
+		for z := c0[2]; z <= c1[2]; z++ {
+			for y := c0[1]; y <= c1[1]; y++ {
+				for x := c0[0]; x <= c1[0]; x++ {
+					key := [3]int{x, y, z}
+					g.cells[key] = append(g.cells[key], i)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// cell returns the grid cell containing p, clamped to the grid.
+func (g *triGrid) cell(p [3]float32) [3]int {
+	var c [3]int
+	for k := 0; k < 3; k++ {
+		c[k] = int((p[k] - g.min[k]) / g.cellSize)
+		if c[k] < 0 {
+			c[k] = 0
+		} else if c[k] >= g.dims[k] {
+			c[k] = g.dims[k] - 1
+		}
+	}
+	return c
+}
+
+// maxDistance returns the largest distance from any of the points
+// to the surface of the mesh.
+func (g *triGrid) maxDistance(points [][3]float32) float64 {
+	if len(g.m.Tris) == 0 {
+		return 0
+	}
+	var result float64
+	for _, p := range points {
+		if d := g.distance(p); d > result {
+			result = d
+		}
+	}
+	return result
+}
+
+// distance returns the distance from p to the nearest triangle, searching
+// shells of cells of increasing radius around p until no closer
+// triangle can exist.
+func (g *triGrid) distance(p [3]float32) float64 {
+	c := g.cell(p)
+	// The distance from p to the boundary of its (clamped) cell block.
+	var outside float64
+	for k := 0; k < 3; k++ {
+		lo := g.min[k] + float32(c[k])*g.cellSize
+		hi := lo + g.cellSize
+		if p[k] < lo {
+			outside = math.Max(outside, float64(lo-p[k]))
+		} else if p[k] > hi {
+			outside = math.Max(outside, float64(p[k]-hi))
+		}
+	}
+
+	best := math.Inf(1)
+	maxR := g.dims[0] + g.dims[1] + g.dims[2]
+	for r := 0; r <= maxR; r++ {
+		for z := c[2] - r; z <= c[2]+r; z++ {
+			for y := c[1] - r; y <= c[1]+r; y++ {
+				for x := c[0] - r; x <= c[0]+r; x++ {
+					if iabs(x-c[0]) != r && iabs(y-c[1]) != r && iabs(z-c[2]) != r {
+						continue // already searched
+					}
+					for _, t := range g.cells[[3]int{x, y, z}] {
+						tri := g.m.Tris[t]
+						q := closestPoint(p, g.m.Verts[tri[0]], g.m.Verts[tri[1]], g.m.Verts[tri[2]])
+						if d := float64(length(sub(p, q))); d < best {
+							best = d
+						}
+					}
+				}
+			}
+		}
+		// Every triangle not yet tested is at least this far away.
+		if best <= outside+float64(r)*float64(g.cellSize) {
+			break
+		}
+	}
+	return best
+}
+
+// closestPoint returns the point on triangle abc that is closest to p.
+// See "Real-Time Collision Detection" by Christer Ericson, section 5.1.5.
+func closestPoint(p, a, b, c [3]float32) [3]float32 {
+	ab, ac, ap := sub(b, a), sub(c, a), sub(p, a)
+	d1, d2 := dot(ab, ap), dot(ac, ap)
+	if d1 <= 0 && d2 <= 0 {
+		return a
+	}
+	bp := sub(p, b)
+	d3, d4 := dot(ab, bp), dot(ac, bp)
+	if d3 >= 0 && d4 <= d3 {
+		return b
+	}
+	vc := d1*d4 - d3*d2
+	if vc <= 0 && d1 >= 0 && d3 <= 0 {
+		return add(a, scale(ab, d1/(d1-d3)))
+	}
+	cp := sub(p, c)
+	d5, d6 := dot(ab, cp), dot(ac, cp)
+	if d6 >= 0 && d5 <= d6 {
+		return c
+	}
+	vb := d5*d2 - d1*d6
+	if vb <= 0 && d2 >= 0 && d6 <= 0 {
+		return add(a, scale(ac, d2/(d2-d6)))
+	}
+	va := d3*d6 - d5*d4
+	if va <= 0 && d4-d3 >= 0 && d5-d6 >= 0 {
+		return add(b, scale(sub(c, b), (d4-d3)/((d4-d3)+(d5-d6))))
+	}
+	denom := 1 / (va + vb + vc)
+	v, w := vb*denom, vc*denom
+	return add(a, add(scale(ab, v), scale(ac, w)))
+}
+
+func iabs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,184 @@
+// Package mesh provides an indexed triangle mesh and the
+// post-processing operations that are applied to meshes generated
+// from voxels before they are written out.
+package mesh
+
+import (
+	"fmt"
+
+	"github.com/gmlewis/irmf-slicer/v3/stl"
+)
+
+// Mesh represents an indexed triangle mesh.
+// Triangles are wound counter-clockwise when viewed from outside.
+type Mesh struct {
+	Verts [][3]float32
+	Tris  [][3]int
+}
+
+// Builder builds a Mesh from a stream of STL triangles, welding
+// together vertices that have identical coordinates.
+// It implements the TriWriter interface.
+type Builder struct {
+	m     *Mesh
+	index map[[3]float32]int
+}
+
+// NewBuilder returns a new mesh Builder.
+func NewBuilder() *Builder {
+	return &Builder{m: &Mesh{}, index: map[[3]float32]int{}}
+}
+
+// Write adds a triangle to the mesh.
+func (b *Builder) Write(t *stl.Tri) error {
+	b.m.Tris = append(b.m.Tris, [3]int{b.vertex(t.V1), b.vertex(t.V2), b.vertex(t.V3)})
+	return nil
+}
+
+func (b *Builder) vertex(v [3]float32) int {
+	if i, ok := b.index[v]; ok {
+		return i
+	}
+	i := len(b.m.Verts)
+	b.m.Verts = append(b.m.Verts, v)
+	b.index[v] = i
+	return i
+}
+
+// Mesh returns the mesh built so far.
+func (b *Builder) Mesh() *Mesh {
+	return b.m
+}
+
+// STLSize returns the size (in bytes) of a binary STL file
+// containing numTris triangles.
+func STLSize(numTris int) int64 {
+	return 84 + 50*int64(numTris)
+}
+
+// TrisForSTLSize returns the largest number of triangles that fit
+// in a binary STL file of at most size bytes.
+func TrisForSTLSize(size int64) int {
+	if size < 84 {
+		return 0
+	}
+	return int((size - 84) / 50)
+}
+
+// Normal returns the unit normal of triangle t.
+func (m *Mesh) Normal(t int) [3]float32 {
+	v1, v2, v3 := m.Verts[m.Tris[t][0]], m.Verts[m.Tris[t][1]], m.Verts[m.Tris[t][2]]
+	return normalize(cross(sub(v2, v1), sub(v3, v1)))
+}
+
+// WriteSTL writes the mesh to a binary STL file.
+func (m *Mesh) WriteSTL(filename string) error {
+	w, err := stl.New(filename)
+	if err != nil {
+		return err
+	}
+	if err := m.WriteTris(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// TriWriter represents a destination for the triangles of a mesh,
+// such as an *stl.Client or a *Builder.
+type TriWriter interface {
+	Write(t *stl.Tri) error
+}
+
+// WriteTris writes every triangle of the mesh to w.
+func (m *Mesh) WriteTris(w TriWriter) error {
+	for i, t := range m.Tris {
+		tri := &stl.Tri{
+			N:  m.Normal(i),
+			V1: m.Verts[t[0]],
+			V2: m.Verts[t[1]],
+			V3: m.Verts[t[2]],
+		}
+		if err := w.Write(tri); err != nil {
+			return fmt.Errorf("triangle %v: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Bounds returns the MBB of the mesh.
+func (m *Mesh) Bounds() (min, max [3]float32) {
+	for i, v := range m.Verts {
+		for k := 0; k < 3; k++ {
+			if i == 0 || v[k] < min[k] {
+				min[k] = v[k]
+			}
+			if i == 0 || v[k] > max[k] {
+				max[k] = v[k]
+			}
+		}
+	}
+	return min, max
+}
+
+// Volume returns the signed volume enclosed by the mesh, which is
+// positive for a closed mesh whose triangles are wound counter-clockwise.
+func (m *Mesh) Volume() float64 {
+	var v float64
+	for _, t := range m.Tris {
+		a, b, c := m.Verts[t[0]], m.Verts[t[1]], m.Verts[t[2]]
+		v += float64(dot(a, cross(b, c))) / 6
+	}
+	return v
+}
+
+// compact removes unused vertices and renumbers the triangles.
+func (m *Mesh) compact() {
+	remap := make([]int, len(m.Verts))
+	for i := range remap {
+		remap[i] = -1
+	}
+	var verts [][3]float32
+	for i, t := range m.Tris {
+		for k, v := range t {
+			if remap[v] < 0 {
+				remap[v] = len(verts)
+				verts = append(verts, m.Verts[v])
+			}
+			m.Tris[i][k] = remap[v]
+		}
+	}
+	m.Verts = verts
+}
+
+func sub(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func add(a, b [3]float32) [3]float32 {
+	return [3]float32{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+func scale(a [3]float32, s float32) [3]float32 {
+	return [3]float32{a[0] * s, a[1] * s, a[2] * s}
+}
+
+func dot(a, b [3]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float32) [3]float32 {
+	return [3]float32{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func length(a [3]float32) float32 {
+	return sqrt32(dot(a, a))
+}
+
+func normalize(a [3]float32) [3]float32 {
+	l := length(a)
+	if l == 0 {
+		return a
+	}
+	return scale(a, 1/l)
+}
@@ -0,0 +1,170 @@
+package mesh
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/stl"
+)
+
+// gridCube returns a closed cube of the given size whose faces
+// are each divided into an n x n grid of quads.
+func gridCube(n int, size float32) *Mesh {
+	b := NewBuilder()
+	// Each face is given by an origin and two (right-handed, outward) axes.
+	faces := [][3][3]float32{
+		{{0, 0, 0}, {0, 1, 0}, {1, 0, 0}}, // -Z
+		{{0, 0, 1}, {1, 0, 0}, {0, 1, 0}}, // +Z
+		{{0, 0, 0}, {1, 0, 0}, {0, 0, 1}}, // -Y
+		{{0, 1, 0}, {0, 0, 1}, {1, 0, 0}}, // +Y
+		{{0, 0, 0}, {0, 0, 1}, {0, 1, 0}}, // -X
+		{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}, // +X
+	}
+	pt := func(f [3][3]float32, i, j int) [3]float32 {
+		u, v := float32(i)/float32(n), float32(j)/float32(n)
+		return scale(add(f[0], add(scale(f[1], u), scale(f[2], v))), size)
+	}
+	for _, f := range faces {
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				a, c := pt(f, i, j), pt(f, i+1, j+1)
+				b.Write(&stl.Tri{V1: a, V2: pt(f, i+1, j), V3: c})
+				b.Write(&stl.Tri{V1: a, V2: c, V3: pt(f, i, j+1)})
+			}
+		}
+	}
+	return b.Mesh()
+}
+
+// checkClosed verifies that every directed edge is matched
+// by exactly one edge in the opposite direction.
+func checkClosed(t *testing.T, m *Mesh) {
+	t.Helper()
+	edges := map[[2]int]int{}
+	for _, tri := range m.Tris {
+		for k := 0; k < 3; k++ {
+			edges[[2]int{tri[k], tri[(k+1)%3]}]++
+		}
+	}
+	for e, n := range edges {
+		if n != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			t.Fatalf("edge %v appears %v times, opposite edge appears %v times", e, n, edges[[2]int{e[1], e[0]}])
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	m := gridCube(4, 2)
+	if got, want := len(m.Tris), 6*4*4*2; got != want {
+		t.Errorf("len(Tris) = %v, want %v", got, want)
+	}
+	// A welded closed mesh of genus 0 satisfies V - E + F = 2.
+	if got, want := len(m.Verts), 2+len(m.Tris)/2; got != want {
+		t.Errorf("len(Verts) = %v, want %v", got, want)
+	}
+	checkClosed(t, m)
+	if got := m.Volume(); math.Abs(got-8) > 1e-4 {
+		t.Errorf("Volume = %v, want 8", got)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     SimplifyOptions
+		wantTris int // maximum number of triangles
+	}{
+		{name: "target", opts: SimplifyOptions{TargetTris: 100}, wantTris: 100},
+		{name: "flat faces are free", opts: SimplifyOptions{MaxError: 1e-4}, wantTris: 48},
+		{name: "file size", opts: SimplifyOptions{TargetTris: TrisForSTLSize(STLSize(500) + 49)}, wantTris: 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := gridCube(16, 2)
+			m := gridCube(16, 2)
+			m.Simplify(tt.opts)
+
+			if len(m.Tris) > tt.wantTris {
+				t.Errorf("len(Tris) = %v, want at most %v", len(m.Tris), tt.wantTris)
+			}
+			checkClosed(t, m)
+			if got := m.Volume(); math.Abs(got-8) > 1e-3 {
+				t.Errorf("Volume = %v, want 8", got)
+			}
+			if got := Hausdorff(orig, m); got > 1e-3 {
+				t.Errorf("Hausdorff = %v, want 0", got)
+			}
+		})
+	}
+}
+
+func TestHausdorff(t *testing.T) {
+	a := gridCube(2, 2)
+	b := gridCube(2, 2)
+	for i := range b.Verts {
+		b.Verts[i] = add(b.Verts[i], [3]float32{0, 0, 0.25})
+	}
+	if got := Hausdorff(a, a); got != 0 {
+		t.Errorf("Hausdorff(a, a) = %v, want 0", got)
+	}
+	if got := Hausdorff(a, b); math.Abs(got-0.25) > 1e-6 {
+		t.Errorf("Hausdorff(a, b) = %v, want 0.25", got)
+	}
+}
+
+func TestSmooth(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       SmoothOptions
+		minVolume  float64
+		maxVolume  float64
+		maxDisplay float32
+	}{
+		{
+			name:      "laplacian shrinks",
+			opts:      SmoothOptions{Iterations: 10, Laplacian: true},
+			minVolume: 0,
+			maxVolume: 7,
+		},
+		{
+			name:      "taubin roughly keeps volume",
+			opts:      SmoothOptions{Iterations: 10},
+			minVolume: 7.5,
+			maxVolume: 8.5,
+		},
+		{
+			name:      "preserve volume",
+			opts:      SmoothOptions{Iterations: 10, Laplacian: true, PreserveVolume: true},
+			minVolume: 7.99,
+			maxVolume: 8.01,
+		},
+		{
+			name:       "max displacement",
+			opts:       SmoothOptions{Iterations: 10, Laplacian: true, MaxDisplacement: 0.1},
+			minVolume:  7,
+			maxVolume:  8,
+			maxDisplay: 0.1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orig := gridCube(8, 2)
+			m := gridCube(8, 2)
+			m.Smooth(tt.opts)
+
+			checkClosed(t, m)
+			if got := m.Volume(); got < tt.minVolume || got > tt.maxVolume {
+				t.Errorf("Volume = %v, want %v to %v", got, tt.minVolume, tt.maxVolume)
+			}
+			if tt.maxDisplay > 0 {
+				for v, p := range m.Verts {
+					if d := length(sub(p, orig.Verts[v])); d > tt.maxDisplay*1.0001 {
+						t.Fatalf("vertex %v moved %v, want at most %v", v, d, tt.maxDisplay)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package mesh
+
+import (
+	"container/heap"
+	"math"
+)
+
+// SimplifyOptions controls mesh simplification.
+type SimplifyOptions struct {
+	// TargetTris is the maximum number of triangles in the result.
+	// Zero means that only MaxError limits the simplification.
+	TargetTris int
+	// MaxError stops the simplification once the next collapse would
+	// have a quadric error above MaxError². The quadric error sums the
+	// squared distances to every plane merged into a vertex, so it is a
+	// unitless threshold rather than a bound on how far the surface moves
+	// (use Hausdorff to measure that). Zero means that only TargetTris
+	// limits the simplification.
+	MaxError float64
+}
+
+// Simplify reduces the number of triangles in the mesh (in place) using
+// quadric error metric edge collapses (Garland and Heckbert), stopping once
+// the mesh has at most opts.TargetTris triangles or the next collapse
+// would exceed opts.MaxError. Collapses that would fold over a triangle
+// or make the mesh non-manifold are skipped, so the target may not be reached.
+func (m *Mesh) Simplify(opts SimplifyOptions) {
+	if opts.TargetTris <= 0 && opts.MaxError <= 0 {
+		return
+	}
+	s := newSimplifier(m)
+	maxCost := math.Inf(1)
+	if opts.MaxError > 0 {
+		maxCost = opts.MaxError * opts.MaxError
+	}
+	s.run(opts.TargetTris, maxCost)
+	s.finish()
+}
+
+// quadric is a symmetric 4x4 matrix stored as its upper triangle:
+// a2 ab ac ad b2 bc bd c2 cd d2.
+type quadric [10]float64
+
+func planeQuadric(n [3]float32, p [3]float32) quadric {
+	a, b, c := float64(n[0]), float64(n[1]), float64(n[2])
+	d := -(a*float64(p[0]) + b*float64(p[1]) + c*float64(p[2]))
+	return quadric{a * a, a * b, a * c, a * d, b * b, b * c, b * d, c * c, c * d, d * d}
+}
+
+func (q *quadric) add(o quadric) {
+	for i := range q {
+		q[i] += o[i]
+	}
+}
+
+// cost returns the quadric error of position v.
+func (q *quadric) cost(v [3]float32) float64 {
+	x, y, z := float64(v[0]), float64(v[1]), float64(v[2])
+	return q[0]*x*x + 2*q[1]*x*y + 2*q[2]*x*z + 2*q[3]*x +
+		q[4]*y*y + 2*q[5]*y*z + 2*q[6]*y +
+		q[7]*z*z + 2*q[8]*z + q[9]
+}
+
+// optimal returns the position that minimizes the quadric error, if unique.
+func (q *quadric) optimal() ([3]float32, bool) {
+	a := [3][3]float64{{q[0], q[1], q[2]}, {q[1], q[4], q[5]}, {q[2], q[5], q[7]}}
+	det := a[0][0]*(a[1][1]*a[2][2]-a[1][2]*a[2][1]) -
+		a[0][1]*(a[1][0]*a[2][2]-a[1][2]*a[2][0]) +
+		a[0][2]*(a[1][0]*a[2][1]-a[1][1]*a[2][0])
+	if math.Abs(det) < 1e-12 {
+		return [3]float32{}, false
+	}
+	b := [3]float64{-q[3], -q[6], -q[8]}
+	var v [3]float32
+	for c := 0; c < 3; c++ {
+		m := a
+		for r := 0; r < 3; r++ {
+			m[r][c] = b[r]
+		}
+		d := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+		v[c] = float32(d / det)
+	}
+	return v, true
+}
+
+// collapse is a candidate edge collapse in the priority queue.
+type collapse struct {
+	cost   float64
+	length float32 // breaks ties between equal costs (e.g. on flat regions)
+	v1, v2 int
+	pos    [3]float32
+	// stamp1 and stamp2 detect stale candidates after either vertex changes.
+	stamp1, stamp2 int
+}
+
+type collapseQueue []*collapse
+
+func (q collapseQueue) Len() int { return len(q) }
+func (q collapseQueue) Less(i, j int) bool {
+	if q[i].cost == q[j].cost {
+		return q[i].length < q[j].length
+	}
+	return q[i].cost < q[j].cost
+}
+func (q collapseQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *collapseQueue) Push(x interface{}) { *q = append(*q, x.(*collapse)) }
+func (q *collapseQueue) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+type simplifier struct {
+	m        *Mesh
+	quadrics []quadric
+	stamps   []int
+	dead     []bool  // vertices
+	removed  []bool  // triangles
+	vertTris [][]int // triangles around each vertex (may include removed ones)
+	numTris  int
+	queue    collapseQueue
+
+	// marks and mark flag vertices without allocating a set.
+	marks []int
+	mark  int
+}
+
+func newSimplifier(m *Mesh) *simplifier {
+	s := &simplifier{
+		m:        m,
+		quadrics: make([]quadric, len(m.Verts)),
+		stamps:   make([]int, len(m.Verts)),
+		dead:     make([]bool, len(m.Verts)),
+		removed:  make([]bool, len(m.Tris)),
+		vertTris: make([][]int, len(m.Verts)),
+		numTris:  len(m.Tris),
+		marks:    make([]int, len(m.Verts)),
+	}
+
+	for i, t := range m.Tris {
+		q := planeQuadric(m.Normal(i), m.Verts[t[0]])
+		for _, v := range t {
+			s.quadrics[v].add(q)
+			s.vertTris[v] = append(s.vertTris[v], i)
+		}
+	}
+
+	for i, t := range m.Tris {
+		for k := 0; k < 3; k++ {
+			v1, v2 := t[k], t[(k+1)%3]
+			if v1 < v2 { // each edge once (for a consistently-wound mesh)
+				s.push(v1, v2)
+			} else if !s.hasEdge(v2, v1, i) {
+				s.push(v2, v1)
+			}
+		}
+	}
+	return s
+}
+
+// hasEdge reports whether the directed edge (v1,v2) appears
+// in a triangle other than t.
+func (s *simplifier) hasEdge(v1, v2, t int) bool {
+	for _, ot := range s.vertTris[v1] {
+		if ot == t {
+			continue
+		}
+		tri := s.m.Tris[ot]
+		for k := 0; k < 3; k++ {
+			if tri[k] == v1 && tri[(k+1)%3] == v2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// push adds the candidate collapse of edge (v1,v2) to the queue.
+func (s *simplifier) push(v1, v2 int) {
+	q := s.quadrics[v1]
+	q.add(s.quadrics[v2])
+
+	pos, ok := q.optimal()
+	if !ok || length(sub(pos, s.m.Verts[v1]))+length(sub(pos, s.m.Verts[v2])) > 2*length(sub(s.m.Verts[v1], s.m.Verts[v2])) {
+		// Fall back to the best of the end points and the midpoint.
+		mid := scale(add(s.m.Verts[v1], s.m.Verts[v2]), 0.5)
+		pos = mid
+		best := q.cost(mid)
+		for _, p := range [][3]float32{s.m.Verts[v1], s.m.Verts[v2]} {
+			if c := q.cost(p); c < best {
+				pos, best = p, c
+			}
+		}
+	}
+	cost := q.cost(pos)
+	if cost < 0 {
+		cost = 0
+	}
+	heap.Push(&s.queue, &collapse{
+		cost:   cost,
+		length: length(sub(s.m.Verts[v1], s.m.Verts[v2])),
+		v1:     v1,
+		v2:     v2,
+		pos:    pos,
+		stamp1: s.stamps[v1],
+		stamp2: s.stamps[v2],
+	})
+}
+
+func (s *simplifier) run(targetTris int, maxCost float64) {
+	heap.Init(&s.queue)
+	for s.queue.Len() > 0 && s.numTris > targetTris {
+		c := heap.Pop(&s.queue).(*collapse)
+		if s.dead[c.v1] || s.dead[c.v2] || c.stamp1 != s.stamps[c.v1] || c.stamp2 != s.stamps[c.v2] {
+			continue // stale
+		}
+		if c.cost > maxCost {
+			break
+		}
+		s.collapse(c)
+	}
+}
+
+// liveTris returns the triangles around v that have not been removed.
+func (s *simplifier) liveTris(v int) []int {
+	live := s.vertTris[v][:0]
+	for _, t := range s.vertTris[v] {
+		if !s.removed[t] {
+			live = append(live, t)
+		}
+	}
+	s.vertTris[v] = live
+	return live
+}
+
+// neighbors appends the vertices that share an edge with v to dst.
+// Each vertex is marked with the current value of s.mark.
+func (s *simplifier) neighbors(dst []int, v int) []int {
+	for _, t := range s.liveTris(v) {
+		for _, o := range s.m.Tris[t] {
+			if o != v && s.marks[o] != s.mark {
+				s.marks[o] = s.mark
+				dst = append(dst, o)
+			}
+		}
+	}
+	return dst
+}
+
+// collapse merges v2 into v1 (at the new position), unless doing so
+// would make the mesh non-manifold or fold over a triangle.
+func (s *simplifier) collapse(c *collapse) {
+	v1, v2 := c.v1, c.v2
+
+	// Link condition: the edge must be shared by exactly two triangles,
+	// whose opposite vertices are the only common neighbors of v1 and v2.
+	s.mark++
+	n1 := s.neighbors(nil, v1)
+	if s.marks[v2] != s.mark {
+		return
+	}
+	var common int
+	for _, t := range s.liveTris(v2) {
+		for _, o := range s.m.Tris[t] {
+			if o != v2 && s.marks[o] == s.mark {
+				s.marks[o] = -1 // count each common neighbor once
+				common++
+			}
+		}
+	}
+	if common != 2 {
+		return
+	}
+
+	// Reject collapses that flip the normal of any remaining triangle.
+	for _, v := range []int{v1, v2} {
+		for _, t := range s.liveTris(v) {
+			tri := s.m.Tris[t]
+			if (tri[0] == v1 || tri[1] == v1 || tri[2] == v1) && (tri[0] == v2 || tri[1] == v2 || tri[2] == v2) {
+				continue // removed by the collapse
+			}
+			var ps [3][3]float32
+			for k, tv := range tri {
+				ps[k] = s.m.Verts[tv]
+				if tv == v {
+					ps[k] = c.pos
+				}
+			}
+			before := s.m.Normal(t)
+			after := normalize(cross(sub(ps[1], ps[0]), sub(ps[2], ps[0])))
+			if dot(before, after) < 0.2 {
+				return
+			}
+		}
+	}
+
+	// Perform the collapse.
+	s.m.Verts[v1] = c.pos
+	s.quadrics[v1].add(s.quadrics[v2])
+	s.dead[v2] = true
+	s.stamps[v1]++
+	for _, t := range s.liveTris(v2) {
+		tri := &s.m.Tris[t]
+		if tri[0] == v1 || tri[1] == v1 || tri[2] == v1 {
+			s.removed[t] = true
+			s.numTris--
+			continue
+		}
+		for k := range tri {
+			if tri[k] == v2 {
+				tri[k] = v1
+			}
+		}
+		s.vertTris[v1] = append(s.vertTris[v1], t)
+	}
+	s.vertTris[v2] = nil
+
+	s.mark++
+	for _, v := range s.neighbors(n1[:0], v1) {
+		s.push(v1, v)
+	}
+}
+
+// finish removes the collapsed triangles and vertices from the mesh.
+func (s *simplifier) finish() {
+	var tris [][3]int
+	for i, t := range s.m.Tris {
+		if !s.removed[i] {
+			tris = append(tris, t)
+		}
+	}
+	s.m.Tris = tris
+	s.m.compact()
+}
+
+func sqrt32(v float32) float32 {
+	return float32(math.Sqrt(float64(v)))
+}
@@ -0,0 +1,147 @@
+package mesh
+
+import "sort"
+
+// SmoothOptions controls mesh smoothing.
+type SmoothOptions struct {
+	// Iterations is the number of smoothing passes.
+	Iterations int
+	// Laplacian uses plain Laplacian smoothing (which shrinks the mesh)
+	// instead of Taubin smoothing (which alternates shrinking and inflating
+	// passes to avoid shrinkage).
+	Laplacian bool
+	// MaxDisplacement (if non-zero) limits the distance that any vertex
+	// may move from its original position.
+	MaxDisplacement float32
+	// PreserveVolume offsets each shell along its vertex normals after every
+	// pass so that it keeps its original volume.
+	PreserveVolume bool
+}
+
+const (
+	// taubinLambda and taubinMu are the shrinking and inflating factors
+	// of Taubin smoothing ("Curve and surface smoothing without shrinkage", 1995).
+	taubinLambda = 0.5
+	taubinMu     = -0.53
+)
+
+// Smooth smooths the mesh (in place) to remove stair-stepping artifacts
+// while keeping its connectivity.
+func (m *Mesh) Smooth(opts SmoothOptions) {
+	if opts.Iterations <= 0 || len(m.Tris) == 0 {
+		return
+	}
+
+	neighbors := m.vertexNeighbors()
+	orig := append([][3]float32(nil), m.Verts...)
+	tmp := make([][3]float32, len(m.Verts))
+
+	shellOf, numShells := m.vertexShells()
+	volumes := make([]float64, numShells)
+	if opts.PreserveVolume {
+		volumes = m.shellVolumes(shellOf, numShells)
+	}
+
+	for i := 0; i < opts.Iterations; i++ {
+		m.umbrella(neighbors, taubinLambda, tmp)
+		if !opts.Laplacian {
+			m.umbrella(neighbors, taubinMu, tmp)
+		}
+		if opts.PreserveVolume {
+			m.restoreVolumes(shellOf, volumes)
+		}
+		if opts.MaxDisplacement > 0 {
+			for v, p := range m.Verts {
+				d := sub(p, orig[v])
+				if l := length(d); l > opts.MaxDisplacement {
+					m.Verts[v] = add(orig[v], scale(d, opts.MaxDisplacement/l))
+				}
+			}
+		}
+	}
+}
+
+// vertexNeighbors returns the vertices that share an edge with each vertex.
+func (m *Mesh) vertexNeighbors() [][]int {
+	neighbors := make([][]int, len(m.Verts))
+	for key := range m.edges() {
+		neighbors[key[0]] = append(neighbors[key[0]], key[1])
+		neighbors[key[1]] = append(neighbors[key[1]], key[0])
+	}
+	// Sort the neighbors so that the results do not depend on map order.
+	for _, ns := range neighbors {
+		sort.Ints(ns)
+	}
+	return neighbors
+}
+
+// umbrella moves each vertex by factor times the vector
+// to the centroid of its neighbors.
+func (m *Mesh) umbrella(neighbors [][]int, factor float32, tmp [][3]float32) {
+	for v, p := range m.Verts {
+		ns := neighbors[v]
+		if len(ns) == 0 {
+			tmp[v] = p
+			continue
+		}
+		var c [3]float32
+		for _, n := range ns {
+			c = add(c, m.Verts[n])
+		}
+		c = scale(c, 1/float32(len(ns)))
+		tmp[v] = add(p, scale(sub(c, p), factor))
+	}
+	copy(m.Verts, tmp)
+}
+
+// vertexShells returns the shell number of each vertex
+// and the number of shells.
+func (m *Mesh) vertexShells() ([]int, int) {
+	shellOf := make([]int, len(m.Verts))
+	shells := m.Shells()
+	for n, shell := range shells {
+		for _, t := range shell {
+			for _, v := range m.Tris[t] {
+				shellOf[v] = n
+			}
+		}
+	}
+	return shellOf, len(shells)
+}
+
+// shellVolumes returns the signed volume of each shell.
+func (m *Mesh) shellVolumes(shellOf []int, numShells int) []float64 {
+	volumes := make([]float64, numShells)
+	for _, t := range m.Tris {
+		a, b, c := m.Verts[t[0]], m.Verts[t[1]], m.Verts[t[2]]
+		volumes[shellOf[t[0]]] += float64(dot(a, cross(b, c))) / 6
+	}
+	return volumes
+}
+
+// restoreVolumes offsets the vertices of each shell along their normals
+// so that the shell's volume is restored to (approximately) want.
+func (m *Mesh) restoreVolumes(shellOf []int, want []float64) {
+	got := m.shellVolumes(shellOf, len(want))
+
+	// Area-weighted vertex normals and the area of each shell.
+	normals := make([][3]float32, len(m.Verts))
+	areas := make([]float64, len(want))
+	for _, t := range m.Tris {
+		a, b, c := m.Verts[t[0]], m.Verts[t[1]], m.Verts[t[2]]
+		n := cross(sub(b, a), sub(c, a)) // length is twice the area
+		for _, v := range t {
+			normals[v] = add(normals[v], n)
+		}
+		areas[shellOf[t[0]]] += float64(length(n)) / 2
+	}
+
+	for v := range m.Verts {
+		s := shellOf[v]
+		if areas[s] == 0 {
+			continue
+		}
+		offset := float32((want[s] - got[s]) / areas[s])
+		m.Verts[v] = add(m.Verts[v], scale(normalize(normals[v]), offset))
+	}
+}
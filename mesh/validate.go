@@ -0,0 +1,381 @@
+package mesh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Report represents the result of validating a mesh.
+type Report struct {
+	Triangles int `json:"triangles"`
+	Vertices  int `json:"vertices"`
+	Shells    int `json:"shells"`
+	// BoundaryEdges are used by only one triangle (holes in the surface).
+	BoundaryEdges int `json:"boundaryEdges"`
+	// NonManifoldEdges are used by more than two triangles.
+	NonManifoldEdges int `json:"nonManifoldEdges"`
+	// FlippedEdges are shared by two triangles that traverse them in the
+	// same direction, meaning that the triangles have inconsistent orientation.
+	FlippedEdges int `json:"flippedEdges"`
+	// DegenerateTris have (nearly) zero area.
+	DegenerateTris int     `json:"degenerateTris"`
+	Volume         float64 `json:"volume"`
+
+	// Repair is the result of repairing the mesh (if it was repaired).
+	Repair *RepairReport `json:"repair,omitempty"`
+}
+
+// RepairReport represents the changes made while repairing a mesh.
+type RepairReport struct {
+	RemovedDegenerate int `json:"removedDegenerate"`
+	RemovedDuplicate  int `json:"removedDuplicate"`
+	FlippedTris       int `json:"flippedTris"`
+}
+
+// Watertight reports whether every edge of the mesh is shared
+// by exactly two consistently-oriented triangles.
+func (r *Report) Watertight() bool {
+	return r.BoundaryEdges == 0 && r.NonManifoldEdges == 0 && r.FlippedEdges == 0
+}
+
+// String returns a one-line summary of the report.
+func (r *Report) String() string {
+	var problems []string
+	problem := func(n int, what string) {
+		if n > 0 {
+			problems = append(problems, fmt.Sprintf("%v %v", n, what))
+		}
+	}
+	problem(r.BoundaryEdges, "boundary edges")
+	problem(r.NonManifoldEdges, "non-manifold edges")
+	problem(r.FlippedEdges, "inconsistently-oriented edges")
+	problem(r.DegenerateTris, "degenerate triangles")
+
+	status := "watertight"
+	if !r.Watertight() {
+		status = "NOT watertight"
+	}
+	s := fmt.Sprintf("%v, %v shells, %v triangles, volume %.4f", status, r.Shells, r.Triangles, r.Volume)
+	if len(problems) > 0 {
+		s += ": " + strings.Join(problems, ", ")
+	}
+	if rr := r.Repair; rr != nil {
+		s += fmt.Sprintf(" (repaired: removed %v degenerate and %v duplicate triangles, flipped %v triangles)",
+			rr.RemovedDegenerate, rr.RemovedDuplicate, rr.FlippedTris)
+	}
+	return s
+}
+
+// degenerateArea is the area below which a triangle is considered degenerate.
+const degenerateArea = 1e-12
+
+// edgeUse records a triangle that uses an (undirected) edge.
+type edgeUse struct {
+	tri     int
+	forward bool // whether the triangle traverses the edge from the lower vertex index
+}
+
+// edgeKey returns the undirected edge between v1 and v2.
+func edgeKey(v1, v2 int) [2]int {
+	if v1 > v2 {
+		return [2]int{v2, v1}
+	}
+	return [2]int{v1, v2}
+}
+
+// edges returns every triangle that uses each edge.
+func (m *Mesh) edges() map[[2]int][]edgeUse {
+	edges := make(map[[2]int][]edgeUse, 3*len(m.Tris)/2)
+	for i, t := range m.Tris {
+		for k := 0; k < 3; k++ {
+			v1, v2 := t[k], t[(k+1)%3]
+			if v1 == v2 {
+				continue
+			}
+			key := edgeKey(v1, v2)
+			edges[key] = append(edges[key], edgeUse{tri: i, forward: v1 < v2})
+		}
+	}
+	return edges
+}
+
+// area2 returns twice the area of triangle t.
+func (m *Mesh) area2(t int) float32 {
+	v1, v2, v3 := m.Verts[m.Tris[t][0]], m.Verts[m.Tris[t][1]], m.Verts[m.Tris[t][2]]
+	return length(cross(sub(v2, v1), sub(v3, v1)))
+}
+
+// Validate checks the mesh for holes, non-manifold edges,
+// inconsistent orientation and degenerate triangles.
+func (m *Mesh) Validate() *Report {
+	r := &Report{
+		Triangles: len(m.Tris),
+		Vertices:  len(m.Verts),
+		Shells:    len(m.Shells()),
+		Volume:    m.Volume(),
+	}
+	for _, uses := range m.edges() {
+		switch {
+		case len(uses) == 1:
+			r.BoundaryEdges++
+		case len(uses) > 2:
+			r.NonManifoldEdges++
+		case uses[0].forward == uses[1].forward:
+			r.FlippedEdges++
+		}
+	}
+	for i := range m.Tris {
+		if m.area2(i) <= 2*degenerateArea {
+			r.DegenerateTris++
+		}
+	}
+	return r
+}
+
+// Shells returns the triangle indices of each connected shell of the mesh.
+func (m *Mesh) Shells() [][]int {
+	parent := make([]int, len(m.Verts))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(v int) int
+	find = func(v int) int {
+		for parent[v] != v {
+			parent[v] = parent[parent[v]]
+			v = parent[v]
+		}
+		return v
+	}
+	for _, t := range m.Tris {
+		a := find(t[0])
+		for _, v := range t[1:] {
+			if b := find(v); b != a {
+				parent[b] = a
+			}
+		}
+	}
+
+	index := map[int]int{}
+	var shells [][]int
+	for i, t := range m.Tris {
+		root := find(t[0])
+		n, ok := index[root]
+		if !ok {
+			n = len(shells)
+			index[root] = n
+			shells = append(shells, nil)
+		}
+		shells[n] = append(shells[n], i)
+	}
+	return shells
+}
+
+// Repair removes collapsed (zero-area with repeated vertices) and duplicate
+// triangles, makes the orientation of the triangles of each shell consistent,
+// and orients each shell outward (or inward for shells that form cavities
+// within other shells). It does not fill holes.
+func (m *Mesh) Repair() *RepairReport {
+	rr := &RepairReport{}
+
+	seen := map[[3]int]bool{}
+	tris := m.Tris[:0]
+	for _, t := range m.Tris {
+		if t[0] == t[1] || t[1] == t[2] || t[2] == t[0] {
+			rr.RemovedDegenerate++
+			continue
+		}
+		// Triangles with the same vertices (in either orientation)
+		// are duplicates.
+		key := sortedTri(t)
+		if seen[key] {
+			rr.RemovedDuplicate++
+			continue
+		}
+		seen[key] = true
+		tris = append(tris, t)
+	}
+	m.Tris = tris
+
+	flipped := make([]bool, len(m.Tris))
+	edges := m.edges()
+	shells := m.Shells()
+	for _, shell := range shells {
+		m.orientShell(shell, edges, flipped)
+	}
+	containers := m.shellContainers(shells)
+	for i, shell := range shells {
+		want := 1.0
+		if len(containers[i])%2 == 1 { // a cavity
+			want = -1
+		}
+		if m.shellVolume(shell)*want < 0 {
+			for _, t := range shell {
+				flipped[t] = !flipped[t]
+				m.flip(t)
+			}
+		}
+	}
+	for _, f := range flipped {
+		if f {
+			rr.FlippedTris++
+		}
+	}
+
+	m.compact()
+	return rr
+}
+
+func sortedTri(t [3]int) [3]int {
+	if t[0] > t[1] {
+		t[0], t[1] = t[1], t[0]
+	}
+	if t[1] > t[2] {
+		t[1], t[2] = t[2], t[1]
+	}
+	if t[0] > t[1] {
+		t[0], t[1] = t[1], t[0]
+	}
+	return t
+}
+
+func (m *Mesh) flip(t int) {
+	m.Tris[t][1], m.Tris[t][2] = m.Tris[t][2], m.Tris[t][1]
+}
+
+// orientShell flips triangles of the shell so that each one is oriented
+// consistently with the first, by walking across the manifold edges.
+func (m *Mesh) orientShell(shell []int, edges map[[2]int][]edgeUse, flipped []bool) {
+	visited := map[int]bool{}
+	for _, start := range shell {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+		queue := []int{start}
+		for len(queue) > 0 {
+			t := queue[0]
+			queue = queue[1:]
+			tri := m.Tris[t]
+			for k := 0; k < 3; k++ {
+				v1, v2 := tri[k], tri[(k+1)%3]
+				uses := edges[edgeKey(v1, v2)]
+				if len(uses) != 2 {
+					continue
+				}
+				o := uses[0].tri
+				if o == t {
+					o = uses[1].tri
+				}
+				if visited[o] {
+					continue
+				}
+				visited[o] = true
+				// The neighbor must traverse the edge from v2 to v1.
+				if m.traverses(o, v1, v2) {
+					flipped[o] = !flipped[o]
+					m.flip(o)
+				}
+				queue = append(queue, o)
+			}
+		}
+	}
+}
+
+// traverses reports whether triangle t traverses the directed edge (v1,v2).
+func (m *Mesh) traverses(t, v1, v2 int) bool {
+	tri := m.Tris[t]
+	for k := 0; k < 3; k++ {
+		if tri[k] == v1 && tri[(k+1)%3] == v2 {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Mesh) shellVolume(shell []int) float64 {
+	var v float64
+	for _, t := range shell {
+		a, b, c := m.Verts[m.Tris[t][0]], m.Verts[m.Tris[t][1]], m.Verts[m.Tris[t][2]]
+		v += float64(dot(a, cross(b, c))) / 6
+	}
+	return v
+}
+
+func (m *Mesh) shellBounds(shell []int) [2][3]float32 {
+	b := [2][3]float32{m.Verts[m.Tris[shell[0]][0]], m.Verts[m.Tris[shell[0]][0]]}
+	for _, t := range shell {
+		for _, v := range m.Tris[t] {
+			for k := 0; k < 3; k++ {
+				if p := m.Verts[v][k]; p < b[0][k] {
+					b[0][k] = p
+				} else if p > b[1][k] {
+					b[1][k] = p
+				}
+			}
+		}
+	}
+	return b
+}
+
+// shellContainers returns the other shells that enclose each shell.
+// Shells within an odd number of other shells are cavities.
+func (m *Mesh) shellContainers(shells [][]int) [][]int {
+	bounds := make([][2][3]float32, len(shells))
+	for i, shell := range shells {
+		bounds[i] = m.shellBounds(shell)
+	}
+
+	containers := make([][]int, len(shells))
+	for n := range shells {
+		p := m.Verts[m.Tris[shells[n][0]][0]]
+		for i, shell := range shells {
+			b := bounds[i]
+			if i == n || p[0] < b[0][0] || p[1] < b[0][1] || p[2] < b[0][2] || p[0] > b[1][0] || p[1] > b[1][1] || p[2] > b[1][2] {
+				continue
+			}
+			if m.contains(shell, p) {
+				containers[n] = append(containers[n], i)
+			}
+		}
+	}
+	return containers
+}
+
+// contains reports whether point p is inside the closed shell,
+// by counting the crossings of a ray from p.
+func (m *Mesh) contains(shell []int, p [3]float32) bool {
+	// An irrational-ish direction avoids hitting edges and vertices
+	// of axis-aligned voxel meshes.
+	dir := normalize([3]float32{0.5773, 0.5774, 0.5775})
+	var crossings int
+	for _, t := range shell {
+		if rayHits(p, dir, m.Verts[m.Tris[t][0]], m.Verts[m.Tris[t][1]], m.Verts[m.Tris[t][2]]) {
+			crossings++
+		}
+	}
+	return crossings%2 == 1
+}
+
+// rayHits reports whether the ray from p in direction dir
+// intersects triangle abc (Möller–Trumbore).
+func rayHits(p, dir, a, b, c [3]float32) bool {
+	const eps = 1e-9
+	e1, e2 := sub(b, a), sub(c, a)
+	h := cross(dir, e2)
+	det := dot(e1, h)
+	if det > -eps && det < eps {
+		return false
+	}
+	f := 1 / det
+	s := sub(p, a)
+	u := f * dot(s, h)
+	if u < 0 || u > 1 {
+		return false
+	}
+	q := cross(s, e1)
+	v := f * dot(dir, q)
+	if v < 0 || u+v > 1 {
+		return false
+	}
+	return f*dot(e2, q) > eps
+}
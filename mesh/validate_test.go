@@ -0,0 +1,161 @@
+package mesh
+
+import (
+	"math"
+	"testing"
+)
+
+// nestedCubes returns a 4mm cube containing a 2mm cube,
+// both (incorrectly) oriented outward.
+func nestedCubes() *Mesh {
+	m := gridCube(2, 4)
+	inner := gridCube(1, 2)
+	n := len(m.Verts)
+	for _, v := range inner.Verts {
+		m.Verts = append(m.Verts, add(v, [3]float32{1, 1, 1}))
+	}
+	for _, t := range inner.Tris {
+		m.Tris = append(m.Tris, [3]int{t[0] + n, t[1] + n, t[2] + n})
+	}
+	return m
+}
+
+func TestValidateAndRepair(t *testing.T) {
+	tests := []struct {
+		name   string
+		mesh   func() *Mesh
+		want   Report
+		repair RepairReport
+		volume float64 // after repair
+	}{
+		{
+			name:   "cube",
+			mesh:   func() *Mesh { return gridCube(2, 2) },
+			want:   Report{Triangles: 48, Vertices: 26, Shells: 1, Volume: 8},
+			volume: 8,
+		},
+		{
+			name: "flipped triangle",
+			mesh: func() *Mesh {
+				m := gridCube(2, 2)
+				m.flip(5)
+				return m
+			},
+			want:   Report{Triangles: 48, Vertices: 26, Shells: 1, FlippedEdges: 3, Volume: 8 - 2*triVolume(gridCube(2, 2), 5)},
+			repair: RepairReport{FlippedTris: 1},
+			volume: 8,
+		},
+		{
+			name: "inside out",
+			mesh: func() *Mesh {
+				m := gridCube(2, 2)
+				for i := range m.Tris {
+					m.flip(i)
+				}
+				return m
+			},
+			want:   Report{Triangles: 48, Vertices: 26, Shells: 1, Volume: -8},
+			repair: RepairReport{FlippedTris: 48},
+			volume: 8,
+		},
+		{
+			name: "duplicate and collapsed triangles",
+			mesh: func() *Mesh {
+				m := gridCube(2, 2)
+				m.Tris = append(m.Tris, m.Tris[0], [3]int{0, 0, 1})
+				return m
+			},
+			want:   Report{Triangles: 50, Vertices: 26, Shells: 1, NonManifoldEdges: 3, DegenerateTris: 1, Volume: 8 + triVolume(gridCube(2, 2), 0)},
+			repair: RepairReport{RemovedDuplicate: 1, RemovedDegenerate: 1},
+			volume: 8,
+		},
+		{
+			name: "hole",
+			mesh: func() *Mesh {
+				m := gridCube(2, 2)
+				m.Tris = m.Tris[1:]
+				return m
+			},
+			want:   Report{Triangles: 47, Vertices: 26, Shells: 1, BoundaryEdges: 3, Volume: 8 - triVolume(gridCube(2, 2), 0)},
+			volume: 8 - triVolume(gridCube(2, 2), 0),
+		},
+		{
+			name:   "cavity",
+			mesh:   nestedCubes,
+			want:   Report{Triangles: 60, Vertices: 34, Shells: 2, Volume: 72},
+			repair: RepairReport{FlippedTris: 12},
+			volume: 56,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := tt.mesh()
+			got := m.Validate()
+			if math.Abs(got.Volume-tt.want.Volume) > 1e-4 {
+				t.Errorf("Volume = %v, want %v", got.Volume, tt.want.Volume)
+			}
+			got.Volume = tt.want.Volume
+			if *got != tt.want {
+				t.Errorf("Validate = %+v, want %+v", *got, tt.want)
+			}
+			if got.Watertight() != (tt.want.BoundaryEdges == 0 && tt.want.NonManifoldEdges == 0 && tt.want.FlippedEdges == 0) {
+				t.Errorf("Watertight = %v", got.Watertight())
+			}
+
+			if rr := m.Repair(); *rr != tt.repair {
+				t.Errorf("Repair = %+v, want %+v", *rr, tt.repair)
+			}
+			after := m.Validate()
+			if after.FlippedEdges != 0 || after.NonManifoldEdges != 0 || after.DegenerateTris != 0 {
+				t.Errorf("after Repair, Validate = %+v", *after)
+			}
+			if math.Abs(after.Volume-tt.volume) > 1e-4 {
+				t.Errorf("after Repair, Volume = %v, want %v", after.Volume, tt.volume)
+			}
+		})
+	}
+}
+
+// triVolume returns the signed volume contributed by triangle t of m.
+func triVolume(m *Mesh, t int) float64 {
+	return m.shellVolume([]int{t})
+}
+
+func TestBodies(t *testing.T) {
+	// Two separate 4mm cubes (one with a 2mm cavity),
+	// plus a 1mm cube within the cavity.
+	m := nestedCubes()
+	m.Repair()
+	for _, body := range []struct {
+		size, offset float32
+	}{{4, 10}, {1, 1.5}} {
+		c := gridCube(1, body.size)
+		n := len(m.Verts)
+		for _, v := range c.Verts {
+			m.Verts = append(m.Verts, add(v, [3]float32{body.offset, body.offset, body.offset}))
+		}
+		for _, tri := range c.Tris {
+			m.Tris = append(m.Tris, [3]int{tri[0] + n, tri[1] + n, tri[2] + n})
+		}
+	}
+
+	bodies := m.Bodies()
+	var got []float64
+	for _, b := range bodies {
+		checkClosed(t, b)
+		got = append(got, math.Round(b.Volume()))
+	}
+	want := []float64{64, 56, 1}
+	if len(got) != len(want) {
+		t.Fatalf("Bodies volumes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Bodies volumes = %v, want %v", got, want)
+		}
+	}
+	if n := len(bodies[1].Shells()); n != 2 {
+		t.Errorf("body with cavity has %v shells, want 2", n)
+	}
+}
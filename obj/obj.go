@@ -0,0 +1,181 @@
+// Package obj writes all the materials of an IRMF model into a single
+// Wavefront OBJ file (one group per material) and its MTL material library.
+package obj
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	voxels.Slicer
+	IRMF() *irmf.IRMF
+}
+
+// Options controls the OBJ output.
+type Options struct {
+	// Mesh controls how each material is meshed (may be nil).
+	Mesh *voxels.Options
+	// Colors overrides the color of materials (keyed by 1-based material number).
+	// See matcolor.Colors for the default colors.
+	Colors map[int]color.NRGBA
+}
+
+// Slice slices an IRMF model into a single OBJ file (plus its MTL file)
+// using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single OBJ file with
+// one group per material, plus an MTL file defining the material colors.
+// opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	names := materialNames(slicer)
+	colors := matcolor.Colors(names, opts.Colors)
+
+	mtlFilename := baseFilename + ".mtl"
+	log.Printf("Writing: %v", mtlFilename)
+	if err := writeFile(mtlFilename, func(w io.Writer) error {
+		return writeMTL(w, names, colors)
+	}); err != nil {
+		return err
+	}
+
+	filename := baseFilename + ".obj"
+	log.Printf("Writing: %v", filename)
+	return writeFile(filename, func(w io.Writer) error {
+		ow := newWriter(w, slicer.IRMF(), filepath.Base(mtlFilename))
+		for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+			m, err := voxels.Mesh(slicer, materialNum, opts.Mesh)
+			if err != nil {
+				return fmt.Errorf("material %v: %v", materialNum, err)
+			}
+			if len(m.Tris) == 0 {
+				log.Printf("Material %v (%v) is empty; skipping", materialNum, names[materialNum-1])
+				continue
+			}
+			ow.group(names[materialNum-1], m)
+			log.Printf("Wrote %v triangles for material %v (%v)", len(m.Tris), materialNum, names[materialNum-1])
+		}
+		return ow.flush()
+	})
+}
+
+// materialNames returns the OBJ/MTL names of the materials,
+// which may not contain whitespace.
+func materialNames(slicer Slicer) []string {
+	var names []string
+	for n := 1; n <= slicer.NumMaterials(); n++ {
+		names = append(names, strings.Join(strings.Fields(slicer.MaterialName(n)), "-"))
+	}
+	return names
+}
+
+// writeFile creates filename and writes it with write.
+func writeFile(filename string, write func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close %v: %v", filename, err)
+	}
+	return nil
+}
+
+// writeMTL writes the material library with the diffuse color of each material.
+func writeMTL(w io.Writer, names []string, colors []color.NRGBA) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Written by irmf-slicer\n")
+	for i, name := range names {
+		c := colors[i]
+		fmt.Fprintf(bw, "\nnewmtl %v\n", name)
+		fmt.Fprintf(bw, "Ka 0 0 0\n")
+		fmt.Fprintf(bw, "Kd %v %v %v\n", unit(c.R), unit(c.G), unit(c.B))
+		fmt.Fprintf(bw, "Ks 0 0 0\n")
+		fmt.Fprintf(bw, "d %v\n", unit(c.A))
+		fmt.Fprintf(bw, "illum 1\n")
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write MTL: %v", err)
+	}
+	return nil
+}
+
+// writer streams the OBJ file one material group at a time.
+type writer struct {
+	w   *bufio.Writer
+	err error
+	// base is the number of vertices written so far
+	// (OBJ vertex indices are 1-based and global to the file).
+	base int
+}
+
+func newWriter(w io.Writer, i *irmf.IRMF, mtlFilename string) *writer {
+	ow := &writer{w: bufio.NewWriter(w)}
+	ow.printf("# Written by irmf-slicer\n")
+	for _, line := range []string{i.Title, i.Author, i.Copyright, i.Date} {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			ow.printf("# %v\n", line)
+		}
+	}
+	ow.printf("mtllib %v\n", mtlFilename)
+	return ow
+}
+
+func (ow *writer) printf(format string, args ...interface{}) {
+	if ow.err != nil {
+		return
+	}
+	_, ow.err = fmt.Fprintf(ow.w, format, args...)
+}
+
+// group writes the mesh as a group using the named material.
+func (ow *writer) group(name string, m *mesh.Mesh) {
+	ow.printf("\ng %v\nusemtl %v\n", name, name)
+	for _, v := range m.Verts {
+		ow.printf("v %v %v %v\n", textfmt.Float32(v[0]), textfmt.Float32(v[1]), textfmt.Float32(v[2]))
+	}
+	for _, t := range m.Tris {
+		ow.printf("f %v %v %v\n", ow.base+t[0]+1, ow.base+t[1]+1, ow.base+t[2]+1)
+	}
+	ow.base += len(m.Verts)
+}
+
+func (ow *writer) flush() error {
+	if ow.err != nil {
+		return fmt.Errorf("write OBJ: %v", ow.err)
+	}
+	if err := ow.w.Flush(); err != nil {
+		return fmt.Errorf("write OBJ: %v", err)
+	}
+	return nil
+}
+
+// unit formats a color component in the range 0 to 1.
+func unit(c uint8) string {
+	return strconv.FormatFloat(float64(c)/255, 'g', 4, 64)
+}
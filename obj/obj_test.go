@@ -0,0 +1,56 @@
+package obj
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+)
+
+func TestWrite(t *testing.T) {
+	tri := &mesh.Mesh{
+		Verts: [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0.5}},
+		Tris:  [][3]int{{0, 1, 2}},
+	}
+
+	var buf bytes.Buffer
+	ow := newWriter(&buf, &irmf.IRMF{Title: "Test"}, "test.mtl")
+	ow.group("PLA", tri)
+	ow.group("Flexible-TPU", tri)
+	if err := ow.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Written by irmf-slicer
+# Test
+mtllib test.mtl
+
+g PLA
+usemtl PLA
+v 0 0 0
+v 1 0 0
+v 0 1 0.5
+f 1 2 3
+
+g Flexible-TPU
+usemtl Flexible-TPU
+v 0 0 0
+v 1 0 0
+v 0 1 0.5
+f 4 5 6
+`
+	if got := buf.String(); got != want {
+		t.Errorf("OBJ =\n%v\nwant:\n%v", got, want)
+	}
+
+	buf.Reset()
+	if err := writeMTL(&buf, []string{"PLA"}, []color.NRGBA{{255, 0, 51, 255}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "newmtl PLA\nKa 0 0 0\nKd 1 0 0.2\nKs 0 0 0\nd 1\nillum 1\n"; !strings.HasSuffix(got, want) {
+		t.Errorf("MTL =\n%v\nwant suffix:\n%v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+// Package ply writes all the materials of an IRMF model into a single
+// binary PLY (Stanford polygon) file with per-vertex material colors.
+package ply
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	voxels.Slicer
+	IRMF() *irmf.IRMF
+}
+
+// Options controls the PLY output.
+type Options struct {
+	// Mesh controls how each material is meshed (may be nil).
+	Mesh *voxels.Options
+	// Colors overrides the color of materials (keyed by 1-based material number).
+	// See matcolor.Colors for the default colors.
+	Colors map[int]color.NRGBA
+}
+
+// Slice slices an IRMF model into a single PLY file using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single binary PLY file
+// whose vertices are colored by material. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	// The PLY header holds the vertex and face counts,
+	// so all the materials are meshed before writing.
+	var names []string
+	var meshes []*mesh.Mesh
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		m, err := voxels.Mesh(slicer, materialNum, opts.Mesh)
+		if err != nil {
+			return fmt.Errorf("material %v: %v", materialNum, err)
+		}
+		log.Printf("Meshed %v triangles for material %v (%v)", len(m.Tris), materialNum, slicer.MaterialName(materialNum))
+		names = append(names, slicer.MaterialName(materialNum))
+		meshes = append(meshes, m)
+	}
+
+	filename := baseFilename + ".ply"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+
+	if err := write(f, slicer.IRMF(), names, matcolor.Colors(names, opts.Colors), meshes); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close PLY file: %v", err)
+	}
+	return nil
+}
+
+// write writes the meshes (one per material) to w as a binary PLY file.
+func write(w io.Writer, i *irmf.IRMF, names []string, colors []color.NRGBA, meshes []*mesh.Mesh) error {
+	var numVerts, numTris int
+	for _, m := range meshes {
+		numVerts += len(m.Verts)
+		numTris += len(m.Tris)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat binary_little_endian 1.0\n")
+	fmt.Fprintf(bw, "comment Written by irmf-slicer\n")
+	for _, line := range []string{i.Title, i.Author, i.Copyright, i.Date} {
+		if line = oneLine(line); line != "" {
+			fmt.Fprintf(bw, "comment %v\n", line)
+		}
+	}
+	for n, name := range names {
+		fmt.Fprintf(bw, "comment material %v: %v %v\n", n+1, oneLine(name), matcolor.Hex(colors[n]))
+	}
+	fmt.Fprintf(bw, "element vertex %v\n", numVerts)
+	fmt.Fprintf(bw, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprintf(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\n")
+	fmt.Fprintf(bw, "element face %v\n", numTris)
+	fmt.Fprintf(bw, "property list uchar int vertex_indices\n")
+	fmt.Fprintf(bw, "property uchar material\n")
+	fmt.Fprintf(bw, "end_header\n")
+
+	// Each vertex is 3 floats and 3 color bytes.
+	vbuf := make([]byte, 15)
+	for n, m := range meshes {
+		c := colors[n]
+		vbuf[12], vbuf[13], vbuf[14] = c.R, c.G, c.B
+		for _, v := range m.Verts {
+			for k := 0; k < 3; k++ {
+				binary.LittleEndian.PutUint32(vbuf[4*k:], math.Float32bits(v[k]))
+			}
+			bw.Write(vbuf)
+		}
+	}
+
+	// Each face is a count byte, 3 indices and the material byte.
+	fbuf := make([]byte, 14)
+	fbuf[0] = 3
+	var base int
+	for n, m := range meshes {
+		fbuf[13] = uint8(n + 1)
+		for _, t := range m.Tris {
+			for k := 0; k < 3; k++ {
+				binary.LittleEndian.PutUint32(fbuf[1+4*k:], uint32(base+t[k]))
+			}
+			bw.Write(fbuf)
+		}
+		base += len(m.Verts)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("write PLY: %v", err)
+	}
+	return nil
+}
+
+// oneLine collapses s onto a single line for a PLY header comment.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
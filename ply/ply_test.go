@@ -0,0 +1,83 @@
+package ply
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+)
+
+func TestWrite(t *testing.T) {
+	tri := &mesh.Mesh{
+		Verts: [][3]float32{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}},
+		Tris:  [][3]int{{0, 1, 2}},
+	}
+	meshes := []*mesh.Mesh{tri, {}, tri}
+	names := []string{"PLA", "empty", "TPU"}
+	colors := []color.NRGBA{{1, 2, 3, 255}, {4, 5, 6, 255}, {7, 8, 9, 255}}
+
+	var buf bytes.Buffer
+	if err := write(&buf, &irmf.IRMF{Title: "two\ntriangles"}, names, colors, meshes); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	r := bufio.NewReader(&buf)
+	var header []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("header: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "end_header" {
+			break
+		}
+		header = append(header, line)
+	}
+	h := strings.Join(header, "\n")
+	for _, want := range []string{
+		"format binary_little_endian 1.0",
+		"comment two triangles",
+		"comment material 3: TPU #070809",
+		"element vertex 6",
+		"element face 2",
+	} {
+		if !strings.Contains(h, want) {
+			t.Errorf("header missing %q:\n%v", want, h)
+		}
+	}
+
+	type vertex struct {
+		X, Y, Z float32
+		R, G, B uint8
+	}
+	type face struct {
+		N        uint8
+		V        [3]int32
+		Material uint8
+	}
+	var verts [6]vertex
+	var faces [2]face
+	if err := binary.Read(r, binary.LittleEndian, &verts); err != nil {
+		t.Fatalf("vertices: %v", err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &faces); err != nil {
+		t.Fatalf("faces: %v", err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("trailing data after faces")
+	}
+
+	if got, want := verts[4], (vertex{1, 0, 0, 7, 8, 9}); got != want {
+		t.Errorf("vertex 4 = %+v, want %+v", got, want)
+	}
+	if got, want := faces[1], (face{3, [3]int32{3, 4, 5}, 3}); got != want {
+		t.Errorf("face 1 = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,89 @@
+package schem
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// NBT tag types. See https://minecraft.wiki/w/NBT_format.
+const (
+	tagEnd       = 0
+	tagByte      = 1
+	tagShort     = 2
+	tagInt       = 3
+	tagLong      = 4
+	tagByteArray = 7
+	tagString    = 8
+	tagCompound  = 10
+	tagIntArray  = 11
+)
+
+// nbtWriter writes big-endian named NBT tags with a sticky error.
+type nbtWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func newNBTWriter(w io.Writer) *nbtWriter {
+	return &nbtWriter{w: bufio.NewWriter(w)}
+}
+
+func (nw *nbtWriter) value(v interface{}) {
+	if nw.err == nil {
+		nw.err = binary.Write(nw.w, binary.BigEndian, v)
+	}
+}
+
+func (nw *nbtWriter) str(s string) {
+	nw.value(uint16(len(s)))
+	nw.value([]byte(s))
+}
+
+// header writes the type and name of a tag.
+func (nw *nbtWriter) header(typ byte, name string) {
+	nw.value(typ)
+	nw.str(name)
+}
+
+func (nw *nbtWriter) beginCompound(name string) { nw.header(tagCompound, name) }
+func (nw *nbtWriter) endCompound()              { nw.value(byte(tagEnd)) }
+
+func (nw *nbtWriter) short(name string, v int16) {
+	nw.header(tagShort, name)
+	nw.value(v)
+}
+
+func (nw *nbtWriter) int(name string, v int32) {
+	nw.header(tagInt, name)
+	nw.value(v)
+}
+
+func (nw *nbtWriter) long(name string, v int64) {
+	nw.header(tagLong, name)
+	nw.value(v)
+}
+
+func (nw *nbtWriter) string(name, v string) {
+	nw.header(tagString, name)
+	nw.str(v)
+}
+
+func (nw *nbtWriter) byteArray(name string, v []byte) {
+	nw.header(tagByteArray, name)
+	nw.value(int32(len(v)))
+	nw.value(v)
+}
+
+func (nw *nbtWriter) intArray(name string, v []int32) {
+	nw.header(tagIntArray, name)
+	nw.value(int32(len(v)))
+	nw.value(v)
+}
+
+func (nw *nbtWriter) flush() error {
+	if nw.err != nil {
+		return nw.err
+	}
+	return nw.w.Flush()
+}
@@ -0,0 +1,311 @@
+// Package schem slices the model and writes a Sponge schematic (.schem)
+// file that can be pasted into a Minecraft world (e.g. with WorldEdit),
+// with one block per voxel.
+// See https://github.com/SpongePowered/Schematic-Specification.
+package schem
+
+import (
+	"compress/gzip"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	IRMF() *irmf.IRMF
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the schematic output.
+type Options struct {
+	// Blocks overrides the block state of materials (keyed by 1-based
+	// material number), e.g. "minecraft:oak_planks" or
+	// "minecraft:oak_stairs[facing=north]". By default, each material is
+	// the concrete (or, if translucent, stained glass) block closest to
+	// the material's color.
+	Blocks map[int]string
+	// Colors overrides the color of materials (keyed by 1-based material number)
+	// used to choose their default blocks. See matcolor.Colors.
+	Colors map[int]color.NRGBA
+}
+
+const (
+	// spongeVersion is the version of the Sponge schematic format written.
+	spongeVersion = 2
+	// dataVersion is the Minecraft data version of the block states
+	// (Minecraft 1.20.1). Newer versions of Minecraft upgrade them.
+	dataVersion = 3465
+	// maxBuildHeight is the height of a Minecraft world (from y=-64 to y=319).
+	maxBuildHeight = 384
+)
+
+// Slice slices an IRMF model into a single schematic using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single schematic in which
+// each voxel that is at least half occupied is a block of the material
+// covering the most of it. opts may be nil.
+//
+// IRMF's Z axis is Minecraft's (vertical) Y axis, and IRMF's Y axis is
+// Minecraft's -Z (north) axis, so the model is not mirrored.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	width, length, height := slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()
+	for _, n := range []int{width, length, height} {
+		if n > math.MaxUint16 {
+			return fmt.Errorf("schematic is %vx%vx%v blocks; at most %v are allowed along each axis (use a coarser resolution)", width, height, length, math.MaxUint16)
+		}
+	}
+	if n := slicer.NumMaterials(); n > math.MaxUint8 {
+		return fmt.Errorf("at most %v materials are supported, got %v", math.MaxUint8, n)
+	}
+	if height > maxBuildHeight {
+		log.Printf("WARNING: schematic is %v blocks high, but Minecraft worlds are only %v blocks high", height, maxBuildHeight)
+	}
+
+	var names []string
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		names = append(names, slicer.MaterialName(materialNum))
+	}
+	colors := matcolor.Colors(names, opts.Colors)
+
+	// The palette lists the block states by index; air is 0.
+	palette := []string{"minecraft:air"}
+	index := map[string]int{"minecraft:air": 0}
+	materialBlocks := []uint8{0}
+	for i := range names {
+		block, ok := opts.Blocks[i+1]
+		if !ok {
+			block = nearestBlock(colors[i])
+		}
+		if _, ok := index[block]; !ok {
+			index[block] = len(palette)
+			palette = append(palette, block)
+		}
+		log.Printf("Material %v (%v): %v", i+1, names[i], block)
+		materialBlocks = append(materialBlocks, uint8(index[block]))
+	}
+
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+	b := &builder{
+		width:    width,
+		length:   length,
+		height:   height,
+		material: make([]uint8, width*length*height),
+		coverage: make([]uint8, width*length*height),
+	}
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		b.materialNum = uint8(materialNum)
+		if err := slicer.RenderZSlices(materialNum, b, irmf.MinToMax); err != nil {
+			return fmt.Errorf("RenderZSlices: %v", err)
+		}
+	}
+
+	// Block data holds the palette index of each block as a varint.
+	blockData := make([]byte, 0, len(b.material))
+	for _, m := range b.material {
+		v := materialBlocks[m]
+		if v < 0x80 {
+			blockData = append(blockData, v)
+		} else {
+			blockData = append(blockData, v|0x80, v>>7)
+		}
+	}
+
+	filename := baseFilename + ".schem"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	zw := gzip.NewWriter(f)
+	nw := newNBTWriter(zw)
+	nw.beginCompound("Schematic")
+	nw.int("Version", spongeVersion)
+	nw.int("DataVersion", dataVersion)
+	nw.beginCompound("Metadata")
+	if i := slicer.IRMF(); i != nil {
+		nw.string("Name", i.Title)
+		nw.string("Author", i.Author)
+	}
+	nw.long("Date", time.Now().UnixNano()/int64(time.Millisecond))
+	nw.endCompound()
+	nw.short("Width", int16(uint16(width)))
+	nw.short("Height", int16(uint16(height)))
+	nw.short("Length", int16(uint16(length)))
+	nw.intArray("Offset", []int32{0, 0, 0})
+	nw.int("PaletteMax", int32(len(palette)))
+	nw.beginCompound("Palette")
+	for i, block := range palette {
+		nw.int(block, int32(i))
+	}
+	nw.endCompound()
+	nw.byteArray("BlockData", blockData)
+	nw.endCompound()
+	if err := nw.flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("write schematic: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("gzip: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close schematic file: %v", err)
+	}
+	return nil
+}
+
+// builder assigns each block to the material covering the most of it
+// (if at least half).
+// It implements the irmf.ZSliceProcessor interface.
+type builder struct {
+	width, length, height int
+	materialNum           uint8
+
+	// material and coverage are indexed in Minecraft's Y, Z, X order.
+	material []uint8
+	coverage []uint8
+}
+
+var _ irmf.ZSliceProcessor = &builder{}
+
+func (b *builder) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	bounds := img.Bounds()
+	if bounds.Dx() != b.width || bounds.Dy() != b.length {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, bounds.Dx(), bounds.Dy(), b.width, b.length)
+	}
+	if sliceNum < 0 || sliceNum >= b.height {
+		return fmt.Errorf("slice %v out of range [0,%v)", sliceNum, b.height)
+	}
+	rgba, _ := img.(*image.RGBA)
+	for y := 0; y < b.length; y++ {
+		// Image row 0 is the minimum IRMF Y, which is the southmost row.
+		row := (sliceNum*b.length + b.length - 1 - y) * b.width
+		for x := 0; x < b.width; x++ {
+			var v uint8
+			if rgba != nil {
+				v = rgba.Pix[y*rgba.Stride+x*4]
+			} else {
+				v = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			}
+			if i := row + x; v >= 128 && v > b.coverage[i] {
+				b.material[i], b.coverage[i] = b.materialNum, v
+			}
+		}
+	}
+	return nil
+}
+
+// concrete lists the colors of the concrete blocks.
+var concrete = []struct {
+	name string
+	c    color.NRGBA
+}{
+	{"white", color.NRGBA{207, 213, 214, 255}},
+	{"orange", color.NRGBA{224, 97, 1, 255}},
+	{"magenta", color.NRGBA{169, 48, 159, 255}},
+	{"light_blue", color.NRGBA{36, 137, 199, 255}},
+	{"yellow", color.NRGBA{241, 175, 21, 255}},
+	{"lime", color.NRGBA{94, 169, 24, 255}},
+	{"pink", color.NRGBA{214, 101, 143, 255}},
+	{"gray", color.NRGBA{55, 58, 62, 255}},
+	{"light_gray", color.NRGBA{125, 125, 115, 255}},
+	{"cyan", color.NRGBA{21, 119, 136, 255}},
+	{"purple", color.NRGBA{100, 32, 156, 255}},
+	{"blue", color.NRGBA{45, 47, 143, 255}},
+	{"brown", color.NRGBA{96, 60, 32, 255}},
+	{"green", color.NRGBA{73, 91, 36, 255}},
+	{"red", color.NRGBA{142, 33, 33, 255}},
+	{"black", color.NRGBA{8, 10, 15, 255}},
+}
+
+// nearestBlock returns the concrete block closest to c,
+// or the stained glass block of the same color if c is translucent.
+func nearestBlock(c color.NRGBA) string {
+	best, bestDist := "", math.MaxInt32
+	for _, b := range concrete {
+		dr, dg, db := int(c.R)-int(b.c.R), int(c.G)-int(b.c.G), int(c.B)-int(b.c.B)
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = b.name, d
+		}
+	}
+	if c.A < 0xff {
+		return "minecraft:" + best + "_stained_glass"
+	}
+	return "minecraft:" + best + "_concrete"
+}
+
+// ParseBlocks parses per-material block states such as
+// "1=minecraft:stone,3=oak_stairs[facing=north,half=top]".
+// Block states without a namespace are in the "minecraft" namespace.
+func ParseBlocks(s string) (map[int]string, error) {
+	blocks := map[int]string{}
+	for _, part := range splitTopLevel(s) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid material block %q (want N=block)", part)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid material number in %q", part)
+		}
+		block := strings.TrimSpace(kv[1])
+		if block == "" || strings.ContainsAny(block, " \t") {
+			return nil, fmt.Errorf("invalid block state in %q", part)
+		}
+		if name := strings.SplitN(block, "[", 2)[0]; !strings.Contains(name, ":") {
+			block = "minecraft:" + block
+		}
+		blocks[n] = block
+	}
+	return blocks, nil
+}
+
+// splitTopLevel splits s at the commas that are not within brackets.
+func splitTopLevel(s string) []string {
+	var parts []string
+	var depth, start int
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
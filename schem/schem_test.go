@@ -0,0 +1,235 @@
+package schem
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+func TestSliceWithOptions(t *testing.T) {
+	slicer := &slicertest.Slicer{
+		Model: &irmf.IRMF{Title: "Test", Author: "Test Author"},
+		Names: []string{"red PLA", "TPU"},
+		Size:  [3]int{3, 2, 2},
+		Voxels: []map[[3]int]uint8{
+			{{0, 0, 0}: 255, {1, 0, 0}: 200, {2, 1, 1}: 255},
+			{{1, 0, 0}: 255, {2, 0, 1}: 100},
+		},
+	}
+	blocks, err := ParseBlocks("2=oak_stairs[facing=north,half=top]")
+	if err != nil {
+		t.Fatalf("ParseBlocks: %v", err)
+	}
+
+	name, root := slice(t, slicer, &Options{Blocks: blocks})
+
+	if name != "Schematic" || root["Version"] != int32(spongeVersion) || root["DataVersion"] != int32(dataVersion) {
+		t.Errorf("got %q %+v, want a version %v schematic", name, root, spongeVersion)
+	}
+	if got := root["Metadata"].(map[string]interface{})["Name"]; got != "Test" {
+		t.Errorf("Metadata.Name = %v, want Test", got)
+	}
+	if root["Width"] != int16(3) || root["Height"] != int16(2) || root["Length"] != int16(2) {
+		t.Errorf("size = %v x %v x %v, want 3 x 2 x 2", root["Width"], root["Height"], root["Length"])
+	}
+	wantPalette := map[string]interface{}{
+		"minecraft:air":                               int32(0),
+		"minecraft:red_concrete":                      int32(1),
+		"minecraft:oak_stairs[facing=north,half=top]": int32(2),
+	}
+	if !reflect.DeepEqual(root["Palette"], wantPalette) || root["PaletteMax"] != int32(3) {
+		t.Errorf("palette = %v (max %v), want %v", root["Palette"], root["PaletteMax"], wantPalette)
+	}
+
+	// Blocks are in Y (IRMF Z), Z (IRMF -Y), X order.
+	want := []byte{
+		0, 0, 0, // y=0, z=0 is IRMF y=1
+		1, 2, 0, // y=0, z=1 is IRMF y=0: the greater coverage wins
+		0, 0, 1,
+		0, 0, 0, // IRMF (2,0,1) is less than half covered
+	}
+	if got := root["BlockData"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("BlockData = %v, want %v", got, want)
+	}
+}
+
+func TestLargePalette(t *testing.T) {
+	// Each material has its own block state, so the last one has palette
+	// index 255, which needs a two-byte varint.
+	const n = 255
+	slicer := &slicertest.Slicer{Size: [3]int{2, 1, 1}, Voxels: make([]map[[3]int]uint8, n)}
+	blocks := map[int]string{}
+	for i := range slicer.Voxels {
+		blocks[i+1] = fmt.Sprintf("mymod:block%v", i+1)
+	}
+	slicer.Voxels[n-1] = map[[3]int]uint8{{1, 0, 0}: 255}
+
+	_, root := slice(t, slicer, &Options{Blocks: blocks})
+	if root["PaletteMax"] != int32(n+1) {
+		t.Errorf("PaletteMax = %v, want %v", root["PaletteMax"], n+1)
+	}
+	if got, want := root["BlockData"], []byte{0, 0xff, 0x01}; !reflect.DeepEqual(got, want) {
+		t.Errorf("BlockData = %v, want %v", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		size [3]int
+		n    int
+	}{
+		{name: "too many materials", size: [3]int{1, 1, 1}, n: 256}, // even if they share blocks
+		{name: "too wide", size: [3]int{1 << 16, 1, 1}, n: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slicer := &slicertest.Slicer{Size: tt.size, Voxels: make([]map[[3]int]uint8, tt.n)}
+			base := filepath.Join(t.TempDir(), "test")
+			if err := SliceWithOptions(base, slicer, nil); err == nil {
+				t.Error("SliceWithOptions = nil, want error")
+			}
+		})
+	}
+}
+
+// slice writes the schematic of the slicer and decodes it.
+func slice(t *testing.T, slicer Slicer, opts *Options) (string, map[string]interface{}) {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "test")
+	if err := SliceWithOptions(base, slicer, opts); err != nil {
+		t.Fatalf("SliceWithOptions: %v", err)
+	}
+	f, err := os.Open(base + ".schem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, root, err := readNBT(zr)
+	if err != nil {
+		t.Fatalf("readNBT: %v", err)
+	}
+	return name, root
+}
+
+func TestParseBlocks(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    map[int]string
+		wantErr bool
+	}{
+		{s: "", want: map[int]string{}},
+		{s: "1=stone, 3=mymod:thing", want: map[int]string{1: "minecraft:stone", 3: "mymod:thing"}},
+		{s: "2=oak_stairs[facing=north,half=top],1=glass", want: map[int]string{1: "minecraft:glass", 2: "minecraft:oak_stairs[facing=north,half=top]"}},
+		{s: "stone", wantErr: true},
+		{s: "0=stone", wantErr: true},
+		{s: "1=", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseBlocks(tt.s)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseBlocks = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBlocks: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBlocks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// readNBT decodes the named root compound of an NBT stream
+// (supporting the tag types written by this package).
+func readNBT(r io.Reader) (string, map[string]interface{}, error) {
+	var err error
+	read := func(v interface{}) {
+		if err == nil {
+			err = binary.Read(r, binary.BigEndian, v)
+		}
+	}
+	readString := func() string {
+		var n uint16
+		read(&n)
+		b := make([]byte, n)
+		read(b)
+		return string(b)
+	}
+	var readPayload func(typ byte) interface{}
+	readPayload = func(typ byte) interface{} {
+		switch typ {
+		case tagShort:
+			var v int16
+			read(&v)
+			return v
+		case tagInt:
+			var v int32
+			read(&v)
+			return v
+		case tagLong:
+			var v int64
+			read(&v)
+			return v
+		case tagString:
+			return readString()
+		case tagByteArray, tagIntArray:
+			var n int32
+			read(&n)
+			if err != nil || n < 0 || n > 1<<20 {
+				return nil
+			}
+			if typ == tagByteArray {
+				v := make([]byte, n)
+				read(v)
+				return v
+			}
+			v := make([]int32, n)
+			read(v)
+			return v
+		case tagCompound:
+			m := map[string]interface{}{}
+			for err == nil {
+				var t byte
+				read(&t)
+				if t == tagEnd {
+					break
+				}
+				name := readString()
+				m[name] = readPayload(t)
+			}
+			return m
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected tag type %v", typ)
+		}
+		return nil
+	}
+
+	var typ byte
+	read(&typ)
+	if err == nil && typ != tagCompound {
+		return "", nil, fmt.Errorf("root tag type = %v, want compound", typ)
+	}
+	name := readString()
+	root, _ := readPayload(tagCompound).(map[string]interface{})
+	return name, root, err
+}
@@ -0,0 +1,233 @@
+package stl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reader is a streaming STL file reader that supports
+// both binary and ASCII STL files.
+type Reader struct {
+	r     *bufio.Reader
+	ascii bool
+
+	// binary
+	header [headerSize]byte
+	count  uint32
+	read   uint32
+
+	// ASCII
+	name   string
+	lineNo int
+}
+
+// NewReader returns a new STL reader. The format (binary or ASCII)
+// is detected from the start of the stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	sr := &Reader{r: bufio.NewReaderSize(r, 4096)}
+
+	// Many binary STL files start their header with "solid" too,
+	// so an ASCII file must also contain a facet (or end) soon after.
+	peek, _ := sr.r.Peek(4096)
+	if bytes.HasPrefix(bytes.TrimLeft(peek, " \t\r\n"), []byte("solid")) {
+		rest := peek[bytes.Index(peek, []byte("solid"))+5:]
+		if nl := bytes.IndexByte(rest, '\n'); nl >= 0 {
+			next := bytes.Fields(rest[nl+1:])
+			if len(next) > 0 && (string(next[0]) == "facet" || string(next[0]) == "endsolid") {
+				sr.ascii = true
+			}
+		}
+	}
+
+	if sr.ascii {
+		line, err := sr.line()
+		if err != nil {
+			return nil, err
+		}
+		sr.name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "solid"))
+		return sr, nil
+	}
+
+	if _, err := io.ReadFull(sr.r, sr.header[:]); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if err := binary.Read(sr.r, binary.LittleEndian, &sr.count); err != nil {
+		return nil, fmt.Errorf("read count: %v", err)
+	}
+	return sr, nil
+}
+
+// ASCII reports whether the STL file is in ASCII format.
+func (r *Reader) ASCII() bool {
+	return r.ascii
+}
+
+// Header returns the 80-byte header of a binary STL file.
+func (r *Reader) Header() [headerSize]byte {
+	return r.header
+}
+
+// Name returns the name of the (first) solid in an ASCII STL file.
+func (r *Reader) Name() string {
+	return r.name
+}
+
+// Read returns the next triangle, or io.EOF after the last one.
+func (r *Reader) Read() (*Tri, error) {
+	if r.ascii {
+		return r.readASCII()
+	}
+
+	if r.read >= r.count {
+		return nil, io.EOF
+	}
+	var buf [50]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("triangle %v of %v: %v", r.read, r.count, err)
+	}
+	r.read++
+
+	t := &Tri{}
+	for i, v := range []*[3]float32{&t.N, &t.V1, &t.V2, &t.V3} {
+		for k := 0; k < 3; k++ {
+			v[k] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*(3*i+k):]))
+		}
+	}
+	return t, nil
+}
+
+// line returns the next non-blank line.
+func (r *Reader) line() (string, error) {
+	for {
+		line, err := r.r.ReadString('\n')
+		if line != "" || err == nil {
+			r.lineNo++
+		}
+		if strings.TrimSpace(line) != "" {
+			return line, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// fields returns the fields of the next non-blank line,
+// which must start with the keywords in want.
+func (r *Reader) fields(want ...string) ([]string, error) {
+	line, err := r.line()
+	if err == io.EOF {
+		return nil, fmt.Errorf("line %v: expected %q: %v", r.lineNo, strings.Join(want, " "), io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(line)
+	if len(f) < len(want) {
+		return nil, fmt.Errorf("line %v: expected %q, got %q", r.lineNo, strings.Join(want, " "), strings.TrimSpace(line))
+	}
+	for i, w := range want {
+		if f[i] != w {
+			return nil, fmt.Errorf("line %v: expected %q, got %q", r.lineNo, strings.Join(want, " "), strings.TrimSpace(line))
+		}
+	}
+	return f[len(want):], nil
+}
+
+// vec parses the three numbers of a "facet normal" or "vertex" line.
+func (r *Reader) vec(f []string) ([3]float32, error) {
+	var v [3]float32
+	if len(f) != 3 {
+		return v, fmt.Errorf("line %v: expected 3 numbers, got %v", r.lineNo, len(f))
+	}
+	for k, s := range f {
+		x, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return v, fmt.Errorf("line %v: %v", r.lineNo, err)
+		}
+		v[k] = float32(x)
+	}
+	return v, nil
+}
+
+func (r *Reader) readASCII() (*Tri, error) {
+	for {
+		line, err := r.line()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := strings.Fields(line)
+		switch f[0] {
+		case "endsolid", "solid": // files may contain several solids
+			continue
+		case "facet":
+		default:
+			return nil, fmt.Errorf("line %v: expected \"facet\", got %q", r.lineNo, strings.TrimSpace(line))
+		}
+		if len(f) < 2 || f[1] != "normal" {
+			return nil, fmt.Errorf("line %v: expected \"facet normal\", got %q", r.lineNo, strings.TrimSpace(line))
+		}
+
+		t := &Tri{}
+		if t.N, err = r.vec(f[2:]); err != nil {
+			return nil, err
+		}
+		if _, err := r.fields("outer", "loop"); err != nil {
+			return nil, err
+		}
+		for _, v := range []*[3]float32{&t.V1, &t.V2, &t.V3} {
+			f, err := r.fields("vertex")
+			if err != nil {
+				return nil, err
+			}
+			if *v, err = r.vec(f); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := r.fields("endloop"); err != nil {
+			return nil, err
+		}
+		if _, err := r.fields("endfacet"); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+}
+
+// ReadFile reads all the triangles of a binary or ASCII STL file.
+func ReadFile(filename string) ([]Tri, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	var tris []Tri
+	for {
+		t, err := r.Read()
+		if err == io.EOF {
+			return tris, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", filename, err)
+		}
+		tris = append(tris, *t)
+	}
+}
@@ -0,0 +1,188 @@
+package stl
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testTris = []Tri{
+	{N: [3]float32{0, 0, 1}, V1: [3]float32{0, 0, 0}, V2: [3]float32{1, 0, 0}, V3: [3]float32{0, 1, 0}},
+	{N: [3]float32{0, -1, 0}, V1: [3]float32{-1.5, 1e-7, 3.14159}, V2: [3]float32{1e20, -0.1, 7}, V3: [3]float32{float32(math.Pi), 0.3, -2.5e-10}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		ascii  bool
+		header string
+		tris   []Tri
+	}{
+		{name: "binary, no triangles"},
+		{name: "binary", tris: testTris},
+		{name: "binary with header", header: "IRMF test; PLA; 42x42x42um", tris: testTris},
+		{name: "ascii, no triangles", ascii: true},
+		{name: "ascii", ascii: true, tris: testTris},
+		{name: "ascii with header", ascii: true, header: "IRMF test; PLA; 42x42x42um", tris: testTris},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test #%v: %v", i, tt.name), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.stl")
+			var c *Client
+			var err error
+			if tt.ascii {
+				c, err = NewASCII(filename, "test part")
+			} else {
+				c, err = New(filename)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				if err := c.SetHeader(tt.header); err != nil {
+					t.Fatalf("SetHeader: %v", err)
+				}
+			}
+			for i, tri := range tt.tris {
+				if err := c.Write(&tri); err != nil {
+					t.Fatalf("c.Write: i=%v, %v", i, err)
+				}
+			}
+			if err := c.Close(); err != nil {
+				t.Fatalf("c.Close: %v", err)
+			}
+
+			f, err := os.Open(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			r, err := NewReader(f)
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.ASCII() != tt.ascii {
+				t.Errorf("ASCII = %v, want %v", r.ASCII(), tt.ascii)
+			}
+			wantHeader := tt.header
+			if tt.ascii {
+				if wantHeader == "" {
+					wantHeader = "test part"
+				}
+				if r.Name() != wantHeader {
+					t.Errorf("Name = %q, want %q", r.Name(), wantHeader)
+				}
+			} else if h := r.Header(); strings.TrimRight(string(h[:]), "\x00") != wantHeader {
+				t.Errorf("Header = %q, want %q", h, wantHeader)
+			}
+
+			for i, want := range tt.tris {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatalf("Read #%v: %v", i, err)
+				}
+				if *got != want {
+					t.Errorf("Read #%v = %+v, want %+v", i, *got, want)
+				}
+			}
+			if _, err := r.Read(); err != io.EOF {
+				t.Errorf("final Read = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestSetHeader(t *testing.T) {
+	c := &Client{ch: make(chan Tri, bufSize)}
+	c.start(&fakeFile{})
+	if err := c.SetHeader("solid lies"); err == nil {
+		t.Error("SetHeader(solid...) = nil, want error")
+	}
+	if err := c.SetHeader("two\nlines"); err == nil {
+		t.Error("SetHeader(two lines) = nil, want error")
+	}
+	if err := c.Write(&testTris[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetHeader("too late"); err == nil {
+		t.Error("SetHeader after Write = nil, want error")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    int
+		wantErr string
+	}{
+		{
+			name: "binary header starting with solid",
+			data: "solid binary" + strings.Repeat(" ", 68) + "\x01\x00\x00\x00" + strings.Repeat("\x00", 50),
+			want: 1,
+		},
+		{
+			name: "ascii with multiple solids",
+			data: `solid a
+facet normal 0 0 1
+ outer loop
+  vertex 0 0 0
+  vertex 1 0 0
+  vertex 0 1 0
+ endloop
+endfacet
+endsolid a
+solid b
+facet normal 0 0 1
+ outer loop
+  vertex 0 0 0
+  vertex 1 0 0
+  vertex 0 1 0
+ endloop
+endfacet
+endsolid b
+`,
+			want: 2,
+		},
+		{
+			name:    "truncated binary",
+			data:    strings.Repeat("\x00", 80) + "\x02\x00\x00\x00" + strings.Repeat("\x00", 50),
+			wantErr: "triangle 1 of 2: unexpected EOF",
+		},
+		{
+			name:    "bad ascii vertex",
+			data:    "solid a\nfacet normal 0 0 1\nouter loop\nvertex 0 0\n",
+			wantErr: "line 4: expected 3 numbers, got 2",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test #%v: %v", i, tt.name), func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "test.stl")
+			if err := os.WriteFile(filename, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			tris, err := ReadFile(filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadFile = %v, want error ending with %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadFile: %v", err)
+			}
+			if len(tris) != tt.want {
+				t.Errorf("ReadFile = %v triangles, want %v", len(tris), tt.want)
+			}
+		})
+	}
+}
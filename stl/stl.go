@@ -1,11 +1,16 @@
-// Package stl provides a streaming binary STL file writer.
+// Package stl provides streaming binary and ASCII STL file writers
+// and a streaming STL file reader.
 package stl
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -14,13 +19,15 @@ const (
 	bufSize    = 10000
 )
 
-// Client is a streaming binary STL file writer client.
+// Client is a streaming STL file writer client.
 type Client struct {
 	wg sync.WaitGroup // ensures file is closed
 	ch chan Tri
 
-	mu  sync.RWMutex
-	err error
+	mu      sync.RWMutex
+	err     error
+	header  string
+	started bool // whether any triangles have been written
 }
 
 // Tri represents an STL triangle.
@@ -38,8 +45,8 @@ func New(filename string) (*Client, error) {
 	}
 	// Write header
 	header := struct {
-		_ [headerSize]uint8
-		_ uint32 // count will be overwritten on channel close.
+		_ [headerSize]uint8 // header will be overwritten on channel close.
+		_ uint32            // count will be overwritten on channel close.
 	}{}
 	if err := binary.Write(out, binary.LittleEndian, &header); err != nil {
 		return nil, fmt.Errorf("error writing header: %v", err)
@@ -53,10 +60,69 @@ func New(filename string) (*Client, error) {
 	return c, nil
 }
 
+// NewASCII creates a new streaming ASCII STL file writer
+// for a solid with the given name.
+func NewASCII(filename, name string) (*Client, error) {
+	out, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan Tri, bufSize)
+	c := &Client{
+		ch: ch,
+	}
+	c.run(func() error { return asciiWriter(out, c.name(name), c.ch) })
+	return c, nil
+}
+
 func (c *Client) start(out writeSeekCloser) {
+	c.run(func() error { return writer(out, c.ch, c.getHeader) })
+}
+
+// SetHeader sets the header of the STL file, which is typically used to
+// record where the file came from. Binary STL headers are truncated to
+// 80 bytes and must not start with "solid" (which would confuse readers
+// into treating the file as ASCII STL). For ASCII STL files, the header
+// replaces the name of the solid. It must be called before the first Write.
+func (c *Client) SetHeader(header string) error {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(header)), "solid") {
+		return fmt.Errorf("STL header must not start with 'solid': %q", header)
+	}
+	if strings.ContainsAny(header, "\r\n") {
+		return fmt.Errorf("STL header must be a single line: %q", header)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return errors.New("SetHeader must be called before the first Write")
+	}
+	c.header = header
+	return nil
+}
+
+func (c *Client) getHeader() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.header
+}
+
+// name returns a function that returns the ASCII solid name
+// (the header if set, otherwise name).
+func (c *Client) name(name string) func() string {
+	return func() string {
+		if h := c.getHeader(); h != "" {
+			return h
+		}
+		return name
+	}
+}
+
+// run runs the writer in a goroutine until the channel is closed.
+func (c *Client) run(writer func() error) {
 	c.wg.Add(1)
 	go func() {
-		err := writer(out, c.ch)
+		err := writer()
 		c.mu.Lock()
 		c.err = err
 		c.mu.Unlock()
@@ -66,6 +132,9 @@ func (c *Client) start(out writeSeekCloser) {
 
 // Write writes a triangle to the STL file.
 func (c *Client) Write(t *Tri) error {
+	c.mu.Lock()
+	c.started = true
+	c.mu.Unlock()
 	c.ch <- *t
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -85,22 +154,86 @@ type writeSeekCloser interface {
 	io.Closer
 }
 
-func writer(out writeSeekCloser, ch <-chan Tri) error {
+func writer(out writeSeekCloser, ch <-chan Tri, header func() string) error {
 	var count uint32
 	for t := range ch {
 		if err := binary.Write(out, binary.LittleEndian, &t); err != nil {
+			// Drain the channel so that Write never blocks.
+			for range ch {
+			}
+			out.Close()
 			return fmt.Errorf("write triangle %#v: %v", t, err)
 		}
 		count++
 	}
 
-	if _, err := out.Seek(headerSize, io.SeekStart); err != nil {
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
 		return fmt.Errorf("seek: %v", err)
 	}
 
-	if err := binary.Write(out, binary.LittleEndian, &count); err != nil {
-		return fmt.Errorf("write count %v: %v", count, err)
+	var buf [headerSize + 4]byte
+	copy(buf[:headerSize], header())
+	binary.LittleEndian.PutUint32(buf[headerSize:], count)
+	if _, err := out.Write(buf[:]); err != nil {
+		return fmt.Errorf("write header and count %v: %v", count, err)
 	}
 
 	return out.Close()
 }
+
+func asciiWriter(out io.WriteCloser, name func() string, ch <-chan Tri) error {
+	w := bufio.NewWriter(out)
+	fail := func(err error) error {
+		// Drain the channel so that Write never blocks.
+		for range ch {
+		}
+		out.Close()
+		return err
+	}
+
+	// The solid line is written once the first triangle (or Close) arrives,
+	// so that it includes any header set before the first Write.
+	var solid string
+	begin := func() error {
+		solid = name()
+		if _, err := fmt.Fprintf(w, "solid %v\n", solid); err != nil {
+			return fmt.Errorf("write header: %v", err)
+		}
+		return nil
+	}
+
+	var started bool
+	for t := range ch {
+		if !started {
+			started = true
+			if err := begin(); err != nil {
+				return fail(err)
+			}
+		}
+		if _, err := fmt.Fprintf(w, "  facet normal %v\n    outer loop\n      vertex %v\n      vertex %v\n      vertex %v\n    endloop\n  endfacet\n",
+			asciiVec(t.N), asciiVec(t.V1), asciiVec(t.V2), asciiVec(t.V3)); err != nil {
+			return fail(fmt.Errorf("write triangle %#v: %v", t, err))
+		}
+	}
+	if !started {
+		if err := begin(); err != nil {
+			return fail(err)
+		}
+	}
+	if _, err := fmt.Fprintf(w, "endsolid %v\n", solid); err != nil {
+		return fail(fmt.Errorf("write footer: %v", err))
+	}
+
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return fmt.Errorf("flush: %v", err)
+	}
+	return out.Close()
+}
+
+// asciiVec formats v with the fewest digits that read back as the same float32 values.
+func asciiVec(v [3]float32) string {
+	return strconv.FormatFloat(float64(v[0]), 'e', -1, 32) + " " +
+		strconv.FormatFloat(float64(v[1]), 'e', -1, 32) + " " +
+		strconv.FormatFloat(float64(v[2]), 'e', -1, 32)
+}
@@ -0,0 +1,250 @@
+// Package threemf writes all the materials of an IRMF model
+// into a single 3MF (3D Manufacturing Format) package.
+// See https://3mf.io/specification.
+package threemf
+
+import (
+	"archive/zip"
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/voxels"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	voxels.Slicer
+	IRMF() *irmf.IRMF
+}
+
+const (
+	modelPath = "3D/3dmodel.model"
+
+	contentTypes = `<?xml version="1.0" encoding="UTF-8"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+  <Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+  <Default Extension="model" ContentType="application/vnd.ms-package.3dmanufacturing-3dmodel+xml"/>
+</Types>
+`
+	rels = `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Target="/` + modelPath + `" Id="rel0" Type="http://schemas.microsoft.com/3dmanufacturing/2013/01/3dmodel"/>
+</Relationships>
+`
+	coreNamespace = "http://schemas.microsoft.com/3dmanufacturing/core/2015/02"
+)
+
+// Options controls the 3MF output.
+type Options struct {
+	// Mesh controls how each material is meshed (may be nil).
+	Mesh *voxels.Options
+	// Colors overrides the display color of materials (keyed by 1-based
+	// material number). See matcolor.Colors for the default colors.
+	Colors map[int]color.NRGBA
+}
+
+// Slice slices an IRMF model into a single 3MF file containing
+// one mesh object per material, using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single 3MF file containing
+// one mesh object per material. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	filename := baseFilename + ".3mf"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+
+	if err := write(f, slicer, opts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close 3MF file: %v", err)
+	}
+	return nil
+}
+
+// write writes the 3MF package to w.
+func write(w io.Writer, slicer Slicer, opts *Options) error {
+	zw := zip.NewWriter(w)
+	for _, part := range []struct{ name, data string }{
+		{"[Content_Types].xml", contentTypes},
+		{"_rels/.rels", rels},
+	} {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return fmt.Errorf("zip.Create(%q): %v", part.name, err)
+		}
+		if _, err := io.WriteString(pw, part.data); err != nil {
+			return fmt.Errorf("write %q: %v", part.name, err)
+		}
+	}
+
+	pw, err := zw.Create(modelPath)
+	if err != nil {
+		return fmt.Errorf("zip.Create(%q): %v", modelPath, err)
+	}
+	mw := &modelWriter{w: bufio.NewWriter(pw)}
+	mw.header(slicer.IRMF(), slicer, opts.Colors)
+
+	var objects []int
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		m, err := voxels.Mesh(slicer, materialNum, opts.Mesh)
+		if err != nil {
+			return fmt.Errorf("material %v: %v", materialNum, err)
+		}
+		if len(m.Tris) == 0 {
+			log.Printf("Material %v (%v) is empty; skipping", materialNum, slicer.MaterialName(materialNum))
+			continue
+		}
+		if err := validateMesh(m); err != nil {
+			return fmt.Errorf("material %v (%v): %v", materialNum, slicer.MaterialName(materialNum), err)
+		}
+		id := materialNum + 1 // object IDs follow the basematerials ID (1)
+		mw.object(id, slicer.MaterialName(materialNum), materialNum-1, m)
+		objects = append(objects, id)
+		log.Printf("Wrote %v triangles for material %v (%v)", len(m.Tris), materialNum, slicer.MaterialName(materialNum))
+	}
+	mw.footer(objects)
+
+	if mw.err != nil {
+		return fmt.Errorf("write %q: %v", modelPath, mw.err)
+	}
+	if err := mw.w.Flush(); err != nil {
+		return fmt.Errorf("write %q: %v", modelPath, err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("Unable to close ZIP writer: %v", err)
+	}
+	return nil
+}
+
+// validateMesh checks that every triangle references three distinct,
+// existing vertices, and that the mesh is manifold and consistently
+// oriented, as required by the 3MF core specification for model objects.
+// A mesh that fails these checks is repaired; if it is still not
+// watertight (e.g. because it has holes), it is rejected.
+func validateMesh(m *mesh.Mesh) error {
+	collapsed := false
+	for i, t := range m.Tris {
+		for _, v := range t {
+			if v < 0 || v >= len(m.Verts) {
+				return fmt.Errorf("triangle %v: vertex index %v out of range", i, v)
+			}
+		}
+		if t[0] == t[1] || t[1] == t[2] || t[2] == t[0] {
+			collapsed = true
+		}
+	}
+	if !collapsed && m.Validate().Watertight() {
+		return nil
+	}
+	rr := m.Repair()
+	if r := m.Validate(); !r.Watertight() || len(m.Tris) == 0 {
+		return fmt.Errorf("3MF requires manifold meshes, but the mesh is %v", r)
+	}
+	log.Printf("Repaired the mesh for 3MF (removed %v degenerate and %v duplicate triangles, flipped %v triangles)",
+		rr.RemovedDegenerate, rr.RemovedDuplicate, rr.FlippedTris)
+	return nil
+}
+
+// modelWriter streams the 3D model XML part.
+type modelWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (mw *modelWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+// metadataNames maps the 3MF metadata names to their IRMF header values.
+func metadataNames(i *irmf.IRMF) [][2]string {
+	return [][2]string{
+		{"Title", i.Title},
+		{"Designer", i.Author},
+		{"Copyright", i.Copyright},
+		{"CreationDate", i.Date},
+		{"Description", i.Notes},
+		{"Application", "irmf-slicer"},
+	}
+}
+
+// unit returns the 3MF unit corresponding to the IRMF units.
+func unit(units string) string {
+	switch strings.ToLower(units) {
+	case "um", "micron", "microns", "micrometer":
+		return "micron"
+	case "cm", "centimeter":
+		return "centimeter"
+	case "in", "inch", "inches":
+		return "inch"
+	case "ft", "foot", "feet":
+		return "foot"
+	case "m", "meter":
+		return "meter"
+	}
+	return "millimeter"
+}
+
+func (mw *modelWriter) header(i *irmf.IRMF, slicer Slicer, colors map[int]color.NRGBA) {
+	mw.printf("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	mw.printf("<model unit=%q xml:lang=\"en-US\" xmlns=%q>\n", unit(i.Units), coreNamespace)
+	for _, md := range metadataNames(i) {
+		if md[1] != "" {
+			mw.printf("  <metadata name=%q>%v</metadata>\n", md[0], textfmt.XMLEscape(md[1]))
+		}
+	}
+	mw.printf("  <resources>\n")
+	var names []string
+	for n := 1; n <= slicer.NumMaterials(); n++ {
+		names = append(names, slicer.MaterialName(n))
+	}
+	mw.printf("    <basematerials id=\"1\">\n")
+	for n, c := range matcolor.Colors(names, colors) {
+		mw.printf("      <base name=\"%v\" displaycolor=\"%v\"/>\n", textfmt.XMLEscape(names[n]), matcolor.Hex(c))
+	}
+	mw.printf("    </basematerials>\n")
+}
+
+func (mw *modelWriter) object(id int, name string, pindex int, m *mesh.Mesh) {
+	mw.printf("    <object id=\"%v\" type=\"model\" name=\"%v\" pid=\"1\" pindex=\"%v\">\n", id, textfmt.XMLEscape(name), pindex)
+	mw.printf("      <mesh>\n        <vertices>\n")
+	for _, v := range m.Verts {
+		mw.printf("          <vertex x=\"%v\" y=\"%v\" z=\"%v\"/>\n", textfmt.Float32(v[0]), textfmt.Float32(v[1]), textfmt.Float32(v[2]))
+	}
+	mw.printf("        </vertices>\n        <triangles>\n")
+	for _, t := range m.Tris {
+		mw.printf("          <triangle v1=\"%v\" v2=\"%v\" v3=\"%v\"/>\n", t[0], t[1], t[2])
+	}
+	mw.printf("        </triangles>\n      </mesh>\n    </object>\n")
+}
+
+func (mw *modelWriter) footer(objects []int) {
+	mw.printf("  </resources>\n  <build>\n")
+	for _, id := range objects {
+		mw.printf("    <item objectid=\"%v\"/>\n", id)
+	}
+	mw.printf("  </build>\n</model>\n")
+}
@@ -0,0 +1,268 @@
+package threemf
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"image/color"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+)
+
+type vertex struct {
+	X float32 `xml:"x,attr"`
+	Y float32 `xml:"y,attr"`
+	Z float32 `xml:"z,attr"`
+}
+
+type triangle struct {
+	V1 int `xml:"v1,attr"`
+	V2 int `xml:"v2,attr"`
+	V3 int `xml:"v3,attr"`
+}
+
+// model is the subset of the 3MF core specification checked by the test.
+type model struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/3dmanufacturing/core/2015/02 model"`
+	Unit     string   `xml:"unit,attr"`
+	Metadata []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"metadata"`
+	Bases []struct {
+		Name         string `xml:"name,attr"`
+		DisplayColor string `xml:"displaycolor,attr"`
+	} `xml:"resources>basematerials>base"`
+	Objects []struct {
+		ID        int        `xml:"id,attr"`
+		Name      string     `xml:"name,attr"`
+		PID       int        `xml:"pid,attr"`
+		PIndex    int        `xml:"pindex,attr"`
+		Vertices  []vertex   `xml:"mesh>vertices>vertex"`
+		Triangles []triangle `xml:"mesh>triangles>triangle"`
+	} `xml:"resources>object"`
+	Items []struct {
+		ObjectID int `xml:"objectid,attr"`
+	} `xml:"build>item"`
+}
+
+func TestWrite(t *testing.T) {
+	model := &irmf.IRMF{
+		Title:     "Cubes & <things>",
+		Author:    "Test Author",
+		Copyright: "Public Domain",
+		Date:      "2026-10-18",
+		Materials: []string{"PLA", "empty", "Flexible TPU"},
+		Units:     "mm",
+	}
+	cube := slicertest.Box([3]int{1, 1, 1}, [3]int{4, 4, 4}, 255)
+	slicer := &slicertest.Slicer{
+		Model:  model,
+		Names:  model.Materials,
+		Size:   [3]int{6, 6, 6},
+		Voxels: []map[[3]int]uint8{cube, {}, cube},
+	}
+
+	m := writeModel(t, slicer, &Options{Colors: map[int]color.NRGBA{3: {0, 0, 255, 128}}})
+	if m.Unit != "millimeter" {
+		t.Errorf("unit = %q, want millimeter", m.Unit)
+	}
+	metadata := map[string]string{}
+	for _, md := range m.Metadata {
+		metadata[md.Name] = md.Value
+	}
+	for name, want := range map[string]string{
+		"Title":        "Cubes & <things>",
+		"Designer":     "Test Author",
+		"Copyright":    "Public Domain",
+		"CreationDate": "2026-10-18",
+	} {
+		if got := metadata[name]; got != want {
+			t.Errorf("metadata %v = %q, want %q", name, got, want)
+		}
+	}
+	if len(m.Bases) != 3 || m.Bases[2].Name != "Flexible TPU" {
+		t.Errorf("basematerials = %+v, want 3 materials", m.Bases)
+	} else if got, want := m.Bases[2].DisplayColor, "#0000FF80"; got != want {
+		t.Errorf("displaycolor = %v, want the override %v", got, want)
+	}
+
+	// The empty material is skipped.
+	if len(m.Objects) != 2 || len(m.Items) != 2 {
+		t.Fatalf("got %v objects and %v build items, want 2", len(m.Objects), len(m.Items))
+	}
+	wantPIndex := []int{0, 2}
+	for i, obj := range m.Objects {
+		if obj.PID != 1 || obj.PIndex != wantPIndex[i] {
+			t.Errorf("object %v: pid=%v pindex=%v, want pid=1 pindex=%v", obj.ID, obj.PID, obj.PIndex, wantPIndex[i])
+		}
+		if m.Items[i].ObjectID != obj.ID {
+			t.Errorf("build item %v = %v, want %v", i, m.Items[i].ObjectID, obj.ID)
+		}
+		checkClosed(t, obj.ID, obj.Vertices, obj.Triangles)
+	}
+}
+
+func TestShapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		voxels map[[3]int]uint8
+	}{
+		{name: "touching the MBB", voxels: slicertest.Box([3]int{0, 0, 0}, [3]int{3, 3, 3}, 255)},
+		{name: "two parts", voxels: map[[3]int]uint8{{0, 0, 0}: 255, {2, 2, 2}: 255}},
+		{name: "thin wall", voxels: slicertest.Box([3]int{0, 1, 0}, [3]int{3, 1, 3}, 255)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slicer := &slicertest.Slicer{
+				Model:  &irmf.IRMF{Materials: []string{"PLA"}, Units: "mm"},
+				Size:   [3]int{4, 4, 4},
+				Voxels: []map[[3]int]uint8{tt.voxels},
+			}
+			m := writeModel(t, slicer, &Options{})
+			if len(m.Objects) != 1 {
+				t.Fatalf("got %v objects, want 1", len(m.Objects))
+			}
+			obj := m.Objects[0]
+			checkClosed(t, obj.ID, obj.Vertices, obj.Triangles)
+			for _, v := range obj.Vertices {
+				if v.X < 0 || v.X > 4 || v.Y < 0 || v.Y > 4 || v.Z < 0 || v.Z > 4 {
+					t.Fatalf("vertex %+v is outside the MBB", v)
+				}
+			}
+		})
+	}
+}
+
+// writeModel writes the 3MF package of the slicer and decodes its model.
+func writeModel(t *testing.T, slicer Slicer, opts *Options) *model {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := write(&buf, slicer, opts); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	parts := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name], err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", modelPath} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("missing part %q", name)
+		}
+	}
+
+	m := &model{}
+	if err := xml.Unmarshal(parts[modelPath], m); err != nil {
+		t.Fatalf("xml.Unmarshal: %v", err)
+	}
+	return m
+}
+
+// checkClosed checks that every directed edge of the object's triangles
+// is matched by exactly one opposite edge.
+func checkClosed(t *testing.T, id int, vertices []vertex, triangles []triangle) {
+	t.Helper()
+	if len(triangles) == 0 {
+		t.Errorf("object %v has no triangles", id)
+	}
+	edges := map[[2]int]int{}
+	for _, tri := range triangles {
+		vs := [3]int{tri.V1, tri.V2, tri.V3}
+		for k, v := range vs {
+			if v < 0 || v >= len(vertices) {
+				t.Fatalf("object %v: vertex index %v out of range", id, v)
+			}
+			edges[[2]int{v, vs[(k+1)%3]}]++
+		}
+	}
+	for e, n := range edges {
+		if n != 1 || edges[[2]int{e[1], e[0]}] != 1 {
+			t.Fatalf("object %v: edge %v is not manifold", id, e)
+		}
+	}
+}
+
+// cube returns a unit cube (12 triangles), consistently oriented outward.
+func cube() *mesh.Mesh {
+	m := &mesh.Mesh{}
+	for i := 0; i < 8; i++ {
+		m.Verts = append(m.Verts, [3]float32{float32(i & 1), float32(i >> 1 & 1), float32(i >> 2 & 1)})
+	}
+	m.Tris = [][3]int{
+		{0, 2, 1}, {1, 2, 3}, {4, 5, 6}, {5, 7, 6}, // -Z, +Z
+		{0, 1, 4}, {1, 5, 4}, {2, 6, 3}, {3, 6, 7}, // -Y, +Y
+		{0, 4, 2}, {2, 4, 6}, {1, 3, 5}, {3, 7, 5}, // -X, +X
+	}
+	return m
+}
+
+func TestValidateMesh(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(m *mesh.Mesh)
+		wantErr  bool
+		wantTris int
+	}{
+		{name: "closed", wantTris: 12},
+		{
+			name:     "flipped triangle",
+			edit:     func(m *mesh.Mesh) { m.Tris[0] = [3]int{0, 1, 2} },
+			wantTris: 12,
+		},
+		{
+			name:     "collapsed triangle",
+			edit:     func(m *mesh.Mesh) { m.Tris = append(m.Tris, [3]int{0, 0, 1}) },
+			wantTris: 12,
+		},
+		{
+			name:    "open",
+			edit:    func(m *mesh.Mesh) { m.Tris = m.Tris[2:] }, // no -Z face
+			wantErr: true,
+		},
+		{
+			name:    "out of range",
+			edit:    func(m *mesh.Mesh) { m.Tris[0][0] = 8 },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := cube()
+			if tt.edit != nil {
+				tt.edit(m)
+			}
+			err := validateMesh(m)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("validateMesh = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateMesh: %v", err)
+			}
+			if r := m.Validate(); !r.Watertight() || len(m.Tris) != tt.wantTris {
+				t.Errorf("mesh is %v, want %v watertight triangles", r, tt.wantTris)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package tiff
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// TIFF tags and field types (see the TIFF 6.0 specification).
+const (
+	tagImageWidth                = 256
+	tagImageLength               = 257
+	tagBitsPerSample             = 258
+	tagCompression               = 259
+	tagPhotometricInterpretation = 262
+	tagImageDescription          = 270
+	tagStripOffsets              = 273
+	tagSamplesPerPixel           = 277
+	tagRowsPerStrip              = 278
+	tagStripByteCounts           = 279
+	tagXResolution               = 282
+	tagYResolution               = 283
+	tagResolutionUnit            = 296
+	tagPageNumber                = 297
+	tagSoftware                  = 305
+
+	typeASCII    = 2
+	typeShort    = 3
+	typeLong     = 4
+	typeRational = 5
+
+	headerSize = 8
+)
+
+// entry is a field of an image file directory. Numeric values are
+// held in value (two SHORT values as the low and high 16 bits);
+// other values are held in data.
+type entry struct {
+	tag, typ uint16
+	count    uint32
+	value    uint32
+	data     []byte
+}
+
+var putUint32 = binary.LittleEndian.PutUint32
+
+// header returns the little-endian TIFF header.
+func header(firstIFD uint32) []byte {
+	b := []byte{'I', 'I', 42, 0, 0, 0, 0, 0}
+	putUint32(b[4:], firstIFD)
+	return b
+}
+
+// ascii returns s as a NUL-terminated ASCII value.
+func ascii(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// numEntries returns the number of entries of an encoded directory.
+func numEntries(ifd []byte) int {
+	return int(binary.LittleEndian.Uint16(ifd))
+}
+
+// encodeIFD encodes the entries as an image file directory located at pos,
+// followed by the values that do not fit in the entries.
+// The next directory offset is 0.
+func encodeIFD(pos uint32, entries []entry) []byte {
+	sort.Slice(entries, func(a, b int) bool { return entries[a].tag < entries[b].tag })
+
+	le := binary.LittleEndian
+	size := 2 + 12*len(entries) + 4
+	b := make([]byte, size)
+	le.PutUint16(b, uint16(len(entries)))
+	for i, e := range entries {
+		p := b[2+12*i:]
+		le.PutUint16(p, e.tag)
+		le.PutUint16(p[2:], e.typ)
+		if e.data == nil {
+			le.PutUint32(p[4:], e.count)
+			if e.typ == typeShort { // up to two values, left-justified
+				le.PutUint16(p[8:], uint16(e.value))
+				le.PutUint16(p[10:], uint16(e.value>>16))
+			} else {
+				le.PutUint32(p[8:], e.value)
+			}
+			continue
+		}
+
+		count := e.count
+		if e.typ == typeASCII {
+			count = uint32(len(e.data))
+		}
+		le.PutUint32(p[4:], count)
+		if len(e.data) <= 4 {
+			copy(p[8:], e.data)
+			continue
+		}
+		le.PutUint32(p[8:], pos+uint32(len(b)))
+		b = append(b, e.data...)
+		if len(b)%2 != 0 {
+			b = append(b, 0) // values start on a word boundary
+		}
+	}
+	return b
+}
@@ -0,0 +1,238 @@
+// Package tiff slices the model and writes multi-page TIFF stacks
+// (one page per slice) that can be opened by ImageJ/Fiji and other
+// microscopy and CT tools.
+package tiff
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the TIFF output.
+type Options struct {
+	// BitDepth is the number of bits per pixel: 1 (voxels that are at
+	// least half occupied), 8 or 16 (the fractional coverage of each voxel).
+	// The default (0) is 8.
+	BitDepth int
+}
+
+// Slice slices an IRMF model into one or more 8-bit TIFF stacks (one per material).
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into one or more TIFF stacks
+// (one per material). opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	bitDepth := 8
+	if opts != nil && opts.BitDepth != 0 {
+		bitDepth = opts.BitDepth
+	}
+	if bitDepth != 1 && bitDepth != 8 && bitDepth != 16 {
+		return fmt.Errorf("unsupported TIFF bit depth %v (want 1, 8 or 16)", bitDepth)
+	}
+
+	min, max := slicer.MBB()
+	var voxelSize [3]float32
+	for i, n := range []int{slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()} {
+		voxelSize[i] = (max[i] - min[i]) / float32(n)
+	}
+
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
+
+		filename := fmt.Sprintf("%v-mat%02d-%v.tiff", baseFilename, materialNum, materialName)
+
+		if err := slicer.PrepareRenderZ(); err != nil {
+			return fmt.Errorf("PrepareRenderZ: %v", err)
+		}
+
+		log.Printf("Writing: %v", filename)
+		f, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("Create: %v", err)
+		}
+		s := newStack(bufio.NewWriter(f), slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices(), bitDepth, voxelSize)
+		if err := slicer.RenderZSlices(materialNum, s, irmf.MinToMax); err != nil {
+			f.Close()
+			return fmt.Errorf("RenderZSlices: %v", err)
+		}
+		if err := s.finish(); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("Unable to close TIFF file: %v", err)
+		}
+	}
+	return nil
+}
+
+// stack writes each slice as a page of a TIFF file as it arrives.
+// Since every page has the same size, the offset of the next page's
+// directory is known when a page is written, so no seeking is needed.
+// It implements the irmf.ZSliceProcessor interface.
+type stack struct {
+	w          *bufio.Writer
+	nx, ny, nz int
+	bitDepth   int
+	voxelSize  [3]float32 // in millimeters
+
+	pos  int64 // position in the file
+	next int   // number of the next slice
+	buf  []byte
+}
+
+var _ irmf.ZSliceProcessor = &stack{}
+
+func newStack(w *bufio.Writer, nx, ny, nz, bitDepth int, voxelSize [3]float32) *stack {
+	return &stack{w: w, nx: nx, ny: ny, nz: nz, bitDepth: bitDepth, voxelSize: voxelSize}
+}
+
+// rowSize returns the number of bytes in each row of a page.
+func (s *stack) rowSize() int {
+	return (s.nx*s.bitDepth + 7) / 8
+}
+
+// pageSize returns the number of bytes of pixel data in each page,
+// padded so that the page's directory starts on a word boundary.
+func (s *stack) pageSize() int {
+	n := s.rowSize() * s.ny
+	return n + n%2
+}
+
+func (s *stack) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != s.nx || b.Dy() != s.ny {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, b.Dx(), b.Dy(), s.nx, s.ny)
+	}
+	if sliceNum != s.next {
+		return fmt.Errorf("got slice %v, want slice %v", sliceNum, s.next)
+	}
+
+	if s.pos == 0 {
+		// The first directory follows the first page.
+		s.write(header(uint32(headerSize + s.pageSize())))
+		s.buf = make([]byte, s.pageSize())
+	}
+	s.encode(img)
+	s.write(s.buf)
+
+	ifd := s.directory(uint32(s.pos - int64(s.pageSize())))
+	if s.next < s.nz-1 {
+		putUint32(ifd[2+12*numEntries(ifd):], uint32(s.pos+int64(len(ifd))+int64(s.pageSize())))
+	}
+	s.write(ifd)
+	if s.pos > math.MaxUint32 {
+		return fmt.Errorf("TIFF file is larger than 4GB; use a coarser resolution")
+	}
+	s.next++
+	return nil
+}
+
+// encode encodes the slice into s.buf. Image row 0 is the minimum Y.
+func (s *stack) encode(img image.Image) {
+	for i := range s.buf {
+		s.buf[i] = 0
+	}
+	b := img.Bounds()
+	rgba, _ := img.(*image.RGBA)
+	rowSize := s.rowSize()
+	for y := 0; y < s.ny; y++ {
+		row := s.buf[y*rowSize:]
+		for x := 0; x < s.nx; x++ {
+			var v uint16 // 16-bit coverage
+			if rgba != nil {
+				v = uint16(rgba.Pix[y*rgba.Stride+x*4]) * 0x101
+			} else {
+				v = color.Gray16Model.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray16).Y
+			}
+			switch s.bitDepth {
+			case 1:
+				if v >= 0x8000 {
+					row[x/8] |= 0x80 >> (x % 8)
+				}
+			case 8:
+				row[x] = uint8(v >> 8)
+			case 16:
+				row[2*x], row[2*x+1] = uint8(v), uint8(v>>8)
+			}
+		}
+	}
+}
+
+// directory returns the image file directory of the current page,
+// whose pixel data starts at dataOffset. Its next directory offset is 0.
+func (s *stack) directory(dataOffset uint32) []byte {
+	entries := []entry{
+		{tag: tagImageWidth, typ: typeLong, count: 1, value: uint32(s.nx)},
+		{tag: tagImageLength, typ: typeLong, count: 1, value: uint32(s.ny)},
+		{tag: tagBitsPerSample, typ: typeShort, count: 1, value: uint32(s.bitDepth)},
+		{tag: tagCompression, typ: typeShort, count: 1, value: 1},               // none
+		{tag: tagPhotometricInterpretation, typ: typeShort, count: 1, value: 1}, // black is zero
+		{tag: tagStripOffsets, typ: typeLong, count: 1, value: dataOffset},
+		{tag: tagSamplesPerPixel, typ: typeShort, count: 1, value: 1},
+		{tag: tagRowsPerStrip, typ: typeLong, count: 1, value: uint32(s.ny)},
+		{tag: tagStripByteCounts, typ: typeLong, count: 1, value: uint32(s.rowSize() * s.ny)},
+		{tag: tagXResolution, typ: typeRational, count: 1, data: resolution(s.voxelSize[0])},
+		{tag: tagYResolution, typ: typeRational, count: 1, data: resolution(s.voxelSize[1])},
+		{tag: tagResolutionUnit, typ: typeShort, count: 1, value: 3}, // centimeters
+		{tag: tagPageNumber, typ: typeShort, count: 2, value: uint32(s.next) | uint32(s.nz)<<16},
+		{tag: tagSoftware, typ: typeASCII, data: ascii("irmf-slicer")},
+	}
+	if s.next == 0 {
+		// ImageJ reads the stack's Z spacing from the first page's description.
+		desc := fmt.Sprintf("ImageJ=1.11a\nimages=%v\nslices=%v\nunit=cm\nspacing=%v\nloop=false\n",
+			s.nz, s.nz, strconv.FormatFloat(float64(s.voxelSize[2]/10), 'g', -1, 32))
+		entries = append(entries, entry{tag: tagImageDescription, typ: typeASCII, data: ascii(desc)})
+	}
+	return encodeIFD(uint32(s.pos), entries)
+}
+
+func (s *stack) write(b []byte) {
+	s.w.Write(b) // errors are sticky and reported by Flush
+	s.pos += int64(len(b))
+}
+
+// finish checks that all the slices were written and flushes the file.
+func (s *stack) finish() error {
+	if s.next != s.nz {
+		return fmt.Errorf("got %v slices, want %v", s.next, s.nz)
+	}
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("write TIFF: %v", err)
+	}
+	return nil
+}
+
+// resolution returns the number of pixels per centimeter for the given
+// voxel size (in millimeters) as a RATIONAL value with nanometer precision.
+func resolution(voxelSize float32) []byte {
+	b := make([]byte, 8)
+	putUint32(b, 10*1000*1000)
+	putUint32(b[4:], uint32(math.Max(1, math.Round(float64(voxelSize)*1000*1000))))
+	return b
+}
@@ -0,0 +1,222 @@
+package tiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+)
+
+func value(x, y, z int) uint8 { return uint8(20*x + 50*y + z) }
+
+// newSlicer returns a fake slicer of three 5x3 slices of
+// 0.05mm x 0.1mm x 0.2mm voxels in which voxel (x,y,z) has
+// the coverage value(x,y,z).
+func newSlicer() *slicertest.Slicer {
+	voxels := map[[3]int]uint8{}
+	for z := 0; z < 3; z++ {
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 5; x++ {
+				voxels[[3]int{x, y, z}] = value(x, y, z)
+			}
+		}
+	}
+	return &slicertest.Slicer{
+		Names:  []string{"PLA"},
+		Size:   [3]int{5, 3, 3},
+		Max:    [3]float32{0.25, 0.3, 0.6},
+		Voxels: []map[[3]int]uint8{voxels},
+	}
+}
+
+func TestSliceWithOptions(t *testing.T) {
+	tests := []struct {
+		bitDepth int
+		want     func(v uint8) uint16
+	}{
+		{bitDepth: 1, want: func(v uint8) uint16 {
+			if v >= 128 {
+				return 1
+			}
+			return 0
+		}},
+		{bitDepth: 8, want: func(v uint8) uint16 { return uint16(v) }},
+		{bitDepth: 16, want: func(v uint8) uint16 { return uint16(v) * 257 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v-bit", tt.bitDepth), func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "test")
+			if err := SliceWithOptions(base, newSlicer(), &Options{BitDepth: tt.bitDepth}); err != nil {
+				t.Fatalf("SliceWithOptions: %v", err)
+			}
+			pages := readFile(t, base+"-mat01-PLA.tiff")
+			if len(pages) != 3 {
+				t.Fatalf("got %v pages, want 3", len(pages))
+			}
+
+			first := pages[0]
+			if got, want := first.rational(tagXResolution), 200.0; got != want { // pixels per cm
+				t.Errorf("XResolution = %v, want %v", got, want)
+			}
+			if got, want := first.rational(tagYResolution), 100.0; got != want {
+				t.Errorf("YResolution = %v, want %v", got, want)
+			}
+			if desc := first.ascii(tagImageDescription); !strings.Contains(desc, "images=3\n") || !strings.Contains(desc, "unit=cm\nspacing=0.02\n") {
+				t.Errorf("ImageDescription = %q, want an ImageJ stack with a 0.02cm spacing", desc)
+			}
+
+			for z, p := range pages {
+				if p.value(tagBitsPerSample) != uint32(tt.bitDepth) || p.value(tagImageWidth) != 5 || p.value(tagImageLength) != 3 {
+					t.Fatalf("page %v: got fields %v", z, p.fields)
+				}
+				if got := p.value(tagPageNumber); got != uint32(z)|3<<16 {
+					t.Errorf("page %v: PageNumber = %x", z, got)
+				}
+				for y := 0; y < 3; y++ {
+					for x := 0; x < 5; x++ {
+						if got, want := p.pixel(x, y), tt.want(value(x, y, z)); got != want {
+							t.Errorf("page %v pixel (%v,%v) = %v, want %v", z, x, y, got, want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSliceWithOptions_BadBitDepth(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "test")
+	if err := SliceWithOptions(base, newSlicer(), &Options{BitDepth: 4}); err == nil {
+		t.Error("SliceWithOptions = nil, want error")
+	}
+}
+
+func TestEmptySlices(t *testing.T) {
+	// 10 pixels per row need two bytes at 1 bit per pixel, and each
+	// material has a band of empty slices (or is empty altogether).
+	full := []byte{0xff, 0xc0, 0xff, 0xc0}
+	empty := make([]byte, len(full))
+	material1 := slicertest.Box([3]int{0, 0, 0}, [3]int{9, 1, 0}, 255)
+	for p, v := range slicertest.Box([3]int{0, 0, 4}, [3]int{9, 1, 4}, 255) {
+		material1[p] = v
+	}
+	slicer := &slicertest.Slicer{
+		Names:  []string{"PLA", "TPU"},
+		Size:   [3]int{10, 2, 5},
+		Voxels: []map[[3]int]uint8{material1, {}},
+	}
+
+	base := filepath.Join(t.TempDir(), "test")
+	if err := SliceWithOptions(base, slicer, &Options{BitDepth: 1}); err != nil {
+		t.Fatalf("SliceWithOptions: %v", err)
+	}
+	for _, tt := range []struct {
+		filename string
+		want     [][]byte
+	}{
+		{filename: base + "-mat01-PLA.tiff", want: [][]byte{full, empty, empty, empty, full}},
+		{filename: base + "-mat02-TPU.tiff", want: [][]byte{empty, empty, empty, empty, empty}},
+	} {
+		pages := readFile(t, tt.filename)
+		if len(pages) != len(tt.want) {
+			t.Fatalf("%v: got %v pages, want %v", tt.filename, len(pages), len(tt.want))
+		}
+		for z, p := range pages {
+			if !bytes.Equal(p.strip, tt.want[z]) {
+				t.Errorf("%v: page %v = % x, want % x", tt.filename, z, p.strip, tt.want[z])
+			}
+		}
+	}
+}
+
+// readFile reads and decodes the pages of a TIFF file.
+func readFile(t *testing.T, filename string) []*page {
+	t.Helper()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := readTIFF(data)
+	if err != nil {
+		t.Fatalf("readTIFF: %v", err)
+	}
+	return pages
+}
+
+// page is a decoded TIFF page.
+type page struct {
+	data   []byte
+	fields map[uint16][]byte // the raw value (or the values it points to)
+	strip  []byte
+}
+
+// readTIFF decodes the pages of a little-endian TIFF file.
+func readTIFF(data []byte) ([]*page, error) {
+	le := binary.LittleEndian
+	if string(data[:4]) != "II*\x00" {
+		return nil, fmt.Errorf("bad header % x", data[:8])
+	}
+	var pages []*page
+	for offset := le.Uint32(data[4:]); offset != 0; {
+		if offset%2 != 0 || int(offset) >= len(data) {
+			return nil, fmt.Errorf("bad directory offset %v", offset)
+		}
+		p := &page{data: data, fields: map[uint16][]byte{}}
+		n := int(le.Uint16(data[offset:]))
+		var last uint16
+		for i := 0; i < n; i++ {
+			e := data[int(offset)+2+12*i:]
+			tag, typ, count := le.Uint16(e), le.Uint16(e[2:]), le.Uint32(e[4:])
+			if tag <= last {
+				return nil, fmt.Errorf("tag %v is out of order", tag)
+			}
+			last = tag
+			size := int(count) * map[uint16]int{typeASCII: 1, typeShort: 2, typeLong: 4, typeRational: 8}[typ]
+			if size <= 4 {
+				p.fields[tag] = e[8 : 8+size]
+			} else {
+				at := le.Uint32(e[8:])
+				p.fields[tag] = data[at : int(at)+size]
+			}
+		}
+		stripOffset, stripSize := p.value(tagStripOffsets), p.value(tagStripByteCounts)
+		p.strip = data[stripOffset : stripOffset+stripSize]
+		pages = append(pages, p)
+		offset = le.Uint32(data[int(offset)+2+12*n:])
+	}
+	return pages, nil
+}
+
+// value returns the SHORT or LONG value of the tag
+// (or two SHORT values as the low and high 16 bits).
+func (p *page) value(tag uint16) uint32 {
+	b := append(append([]byte{}, p.fields[tag]...), 0, 0, 0, 0)
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (p *page) rational(tag uint16) float64 {
+	b := p.fields[tag]
+	return float64(binary.LittleEndian.Uint32(b)) / float64(binary.LittleEndian.Uint32(b[4:]))
+}
+
+func (p *page) ascii(tag uint16) string {
+	return strings.TrimSuffix(string(p.fields[tag]), "\x00")
+}
+
+func (p *page) pixel(x, y int) uint16 {
+	width := int(p.value(tagImageWidth))
+	switch p.value(tagBitsPerSample) {
+	case 1:
+		return uint16(p.strip[y*((width+7)/8)+x/8]>>(7-x%8)) & 1
+	case 8:
+		return uint16(p.strip[y*width+x])
+	default:
+		return binary.LittleEndian.Uint16(p.strip[2*(y*width+x):])
+	}
+}
@@ -0,0 +1,297 @@
+// Package vdb slices the model and writes OpenVDB files
+// with one sparse grid per material.
+// See https://www.openvdb.org/documentation/doxygen/codeExamples.html.
+package vdb
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the VDB output.
+type Options struct {
+	// Density writes float grids holding the fractional coverage of each
+	// voxel (as a fog volume) instead of bool grids holding its occupancy.
+	// It is meant to be used with supersampled slices.
+	Density bool
+}
+
+// Slice slices an IRMF model into a single VDB file using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single VDB file containing
+// one grid per material. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	min, max := slicer.MBB()
+	var voxelSize [3]float64
+	for i, n := range []int{slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()} {
+		voxelSize[i] = float64(max[i]-min[i]) / float64(n)
+	}
+
+	var grids []*grid
+	names := map[string]bool{}
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		// Grid names must be unique within a file.
+		name := slicer.MaterialName(materialNum)
+		if names[name] {
+			name = fmt.Sprintf("%v-mat%02d", name, materialNum)
+		}
+		names[name] = true
+
+		g := newGrid(name, opts.Density)
+		for i := range min {
+			g.voxelSize[i] = voxelSize[i]
+			g.origin[i] = float64(min[i]) + voxelSize[i]/2 // index (0,0,0) is the center of the first voxel
+		}
+		if err := slicer.RenderZSlices(materialNum, g, irmf.MinToMax); err != nil {
+			return fmt.Errorf("RenderZSlices: %v", err)
+		}
+		g.finish()
+		log.Printf("Material %v (%v): %v active voxels in %v leaf nodes and %v tiles", materialNum, name, g.activeVoxels, g.numLeaves, g.numTiles)
+		grids = append(grids, g)
+	}
+
+	filename := baseFilename + ".vdb"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	if err := write(f, grids); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close VDB file: %v", err)
+	}
+	return nil
+}
+
+// The standard OpenVDB tree configuration (Tree_*_5_4_3): a root node
+// holding 32^3 internal nodes, each holding 16^3 internal nodes,
+// each holding 8^3 leaf nodes.
+const (
+	leafLog2  = 3
+	leafDim   = 1 << leafLog2
+	leafSize  = leafDim * leafDim * leafDim
+	node4Log2 = 4 + leafLog2
+	node4Size = 1 << (3 * 4)
+	node5Log2 = 5 + node4Log2
+	node5Size = 1 << (3 * 5)
+)
+
+// leaf is an 8^3 block of voxels.
+type leaf struct {
+	origin [3]int32
+	active [leafSize / 64]uint64
+	values []float32 // nil for bool grids, whose values are the active mask
+}
+
+// node4 is an internal node with 16^3 children (leaves or active tiles).
+type node4 struct {
+	leaves map[int]*leaf
+	tiles  map[int]float32
+}
+
+// node5 is an internal node with 32^3 node4 children.
+type node5 struct {
+	children map[int]*node4
+}
+
+// grid builds a sparse VDB tree from the slices of one material
+// eight slices at a time, so that only the occupied voxels are kept.
+// It implements the irmf.ZSliceProcessor interface.
+type grid struct {
+	name      string
+	density   bool
+	voxelSize [3]float64
+	origin    [3]float64 // world position of index (0,0,0)
+
+	root map[[3]int32]*node5
+
+	// band holds up to eight slices (one leaf deep) of voxel values.
+	band      [leafDim][]uint8
+	bandZ     int // index of the first slice in band
+	bandCount int
+	nx, ny    int
+
+	activeVoxels int
+	numLeaves    int
+	numTiles     int
+}
+
+var _ irmf.ZSliceProcessor = &grid{}
+
+func newGrid(name string, density bool) *grid {
+	return &grid{name: name, density: density, root: map[[3]int32]*node5{}}
+}
+
+func (g *grid) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	b := img.Bounds()
+	if g.band[0] == nil {
+		g.nx, g.ny = b.Dx(), b.Dy()
+		for i := range g.band {
+			g.band[i] = make([]uint8, g.nx*g.ny)
+		}
+	}
+	if b.Dx() != g.nx || b.Dy() != g.ny {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, b.Dx(), b.Dy(), g.nx, g.ny)
+	}
+	if sliceNum>>leafLog2 != g.bandZ>>leafLog2 {
+		g.flush()
+		g.bandZ = sliceNum &^ (leafDim - 1)
+	}
+
+	dst := g.band[sliceNum&(leafDim-1)]
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < g.ny; y++ {
+			row := rgba.Pix[y*rgba.Stride:]
+			for x := 0; x < g.nx; x++ {
+				dst[y*g.nx+x] = row[x*4]
+			}
+		}
+	} else {
+		for y := 0; y < g.ny; y++ {
+			for x := 0; x < g.nx; x++ {
+				dst[y*g.nx+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+	g.bandCount++
+	return nil
+}
+
+// finish adds the last (partial) band of slices to the tree.
+func (g *grid) finish() {
+	g.flush()
+}
+
+// flush adds the leaves of the current band of slices to the tree
+// and clears the band.
+func (g *grid) flush() {
+	if g.bandCount == 0 {
+		return
+	}
+	for y0 := 0; y0 < g.ny; y0 += leafDim {
+		for x0 := 0; x0 < g.nx; x0 += leafDim {
+			g.addLeaf(x0, y0)
+		}
+	}
+	for _, s := range g.band {
+		for i := range s {
+			s[i] = 0
+		}
+	}
+	g.bandCount = 0
+}
+
+// addLeaf adds the leaf whose minimum corner is (x0,y0,g.bandZ)
+// unless it is empty. A full leaf with a single value becomes a tile.
+func (g *grid) addLeaf(x0, y0 int) {
+	l := &leaf{origin: [3]int32{int32(x0), int32(y0), int32(g.bandZ)}}
+	if g.density {
+		l.values = make([]float32, leafSize)
+	}
+	var count int
+	uniform := true
+	first := g.band[0][y0*g.nx+x0]
+	for dx := 0; dx < leafDim; dx++ {
+		for dy := 0; dy < leafDim; dy++ {
+			for dz := 0; dz < leafDim; dz++ {
+				x, y := x0+dx, y0+dy
+				var v uint8
+				if x < g.nx && y < g.ny {
+					v = g.band[dz][y*g.nx+x]
+				}
+				if v != first {
+					uniform = false
+				}
+				if v == 0 || (!g.density && v < 128) {
+					continue
+				}
+				i := dx<<(2*leafLog2) | dy<<leafLog2 | dz
+				l.active[i>>6] |= 1 << (i & 63)
+				if g.density {
+					l.values[i] = float32(v) / 255
+				}
+				count++
+			}
+		}
+	}
+	if count == 0 {
+		return
+	}
+	g.activeVoxels += count
+
+	n4 := g.node4(l.origin)
+	i := offset(l.origin, node4Log2, leafLog2)
+	if count == leafSize && (uniform || !g.density) {
+		n4.tiles[i] = 1
+		if g.density {
+			n4.tiles[i] = float32(first) / 255
+		}
+		g.numTiles++
+		return
+	}
+	n4.leaves[i] = l
+	g.numLeaves++
+}
+
+// node4 returns the internal node containing the voxel at p, creating it if needed.
+func (g *grid) node4(p [3]int32) *node4 {
+	var key [3]int32
+	for i := range p {
+		key[i] = p[i] &^ (1<<node5Log2 - 1)
+	}
+	n5, ok := g.root[key]
+	if !ok {
+		n5 = &node5{children: map[int]*node4{}}
+		g.root[key] = n5
+	}
+	i := offset(p, node5Log2, node4Log2)
+	n4, ok := n5.children[i]
+	if !ok {
+		n4 = &node4{leaves: map[int]*leaf{}, tiles: map[int]float32{}}
+		n5.children[i] = n4
+	}
+	return n4
+}
+
+// offset returns the index within a node (of 2^log2 voxels per side)
+// of the child (of 2^childLog2 voxels per side) containing p.
+func offset(p [3]int32, log2, childLog2 uint) int {
+	mask := int32(1)<<log2 - 1
+	dim := log2 - childLog2
+	x := int((p[0] & mask) >> childLog2)
+	y := int((p[1] & mask) >> childLog2)
+	z := int((p[2] & mask) >> childLog2)
+	return x<<(2*dim) | y<<dim | z
+}
@@ -0,0 +1,350 @@
+package vdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+)
+
+// newSlicer returns a fake slicer of a 20^3 volume of 0.5mm voxels
+// in which material 1 is a 16^3 cube (from voxel 2 to voxel 17) with
+// the given coverage, and material 2 is empty.
+func newSlicer(value uint8) *slicertest.Slicer {
+	return &slicertest.Slicer{
+		Names:  []string{"PLA", "PLA"},
+		Size:   [3]int{20, 20, 20},
+		Min:    [3]float32{-5, -5, 0},
+		Max:    [3]float32{5, 5, 10},
+		Voxels: []map[[3]int]uint8{slicertest.Box([3]int{2, 2, 2}, [3]int{17, 17, 17}, value), {}},
+	}
+}
+
+func TestSlice(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *Options
+		value   uint8
+		want    float32
+		wantTyp string
+	}{
+		{name: "bool", value: 255, want: 1, wantTyp: "Tree_bool_5_4_3"},
+		{name: "bool below half coverage", value: 100, wantTyp: "Tree_bool_5_4_3"},
+		{name: "density", opts: &Options{Density: true}, value: 51, want: 0.2, wantTyp: "Tree_float_5_4_3"},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test #%v: %v", i, tt.name), func(t *testing.T) {
+			grids := slice(t, newSlicer(tt.value), tt.opts)
+			if len(grids) != 2 || grids[0].name != "PLA" || grids[1].name != "PLA-mat02" {
+				t.Fatalf("got grids %+v, want PLA and PLA-mat02", grids)
+			}
+			g := grids[0]
+			if g.typ != tt.wantTyp {
+				t.Errorf("type = %v, want %v", g.typ, tt.wantTyp)
+			}
+			if want := [3]float64{-4.75, -4.75, 0.25}; g.translation != want {
+				t.Errorf("translation = %v, want %v", g.translation, want)
+			}
+			if want := [3]float64{0.5, 0.5, 0.5}; g.scale != want {
+				t.Errorf("scale = %v, want %v", g.scale, want)
+			}
+			if len(grids[1].voxels) != 0 {
+				t.Errorf("empty material has %v active voxels", len(grids[1].voxels))
+			}
+
+			if tt.want == 0 {
+				if len(g.voxels) != 0 {
+					t.Errorf("got %v active voxels, want 0", len(g.voxels))
+				}
+				return
+			}
+			if len(g.voxels) != 16*16*16 {
+				t.Errorf("got %v active voxels, want %v", len(g.voxels), 16*16*16)
+			}
+			for p, v := range g.voxels {
+				for k := 0; k < 3; k++ {
+					if p[k] < 2 || p[k] > 17 {
+						t.Fatalf("voxel %v is outside the cube", p)
+					}
+				}
+				if v != tt.want {
+					t.Fatalf("voxel %v = %v, want %v", p, v, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSparse(t *testing.T) {
+	nonUniform := slicertest.Box([3]int{0, 0, 0}, [3]int{7, 7, 7}, 255)
+	nonUniform[[3]int{3, 4, 5}] = 204
+	wantNonUniform := map[[3]int32]float32{}
+	for p, v := range nonUniform {
+		wantNonUniform[[3]int32{int32(p[0]), int32(p[1]), int32(p[2])}] = float32(v) / 255
+	}
+
+	tests := []struct {
+		name   string
+		opts   *Options
+		size   [3]int
+		voxels map[[3]int]uint8
+		want   map[[3]int32]float32
+	}{
+		{
+			name:   "two internal nodes and a partial band",
+			size:   [3]int{130, 1, 10},
+			voxels: map[[3]int]uint8{{0, 0, 0}: 255, {129, 0, 9}: 255},
+			want:   map[[3]int32]float32{{0, 0, 0}: 1, {129, 0, 9}: 1},
+		},
+		{
+			name:   "band of empty slices",
+			size:   [3]int{4, 4, 20},
+			voxels: map[[3]int]uint8{{0, 0, 0}: 255, {3, 3, 19}: 255},
+			want:   map[[3]int32]float32{{0, 0, 0}: 1, {3, 3, 19}: 1},
+		},
+		{
+			name:   "full non-uniform leaf",
+			opts:   &Options{Density: true},
+			size:   [3]int{8, 8, 8},
+			voxels: nonUniform,
+			want:   wantNonUniform,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slicer := &slicertest.Slicer{Size: tt.size, Voxels: []map[[3]int]uint8{tt.voxels}}
+			grids := slice(t, slicer, tt.opts)
+			if len(grids) != 1 {
+				t.Fatalf("got %v grids, want 1", len(grids))
+			}
+			if got := grids[0].voxels; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("voxels = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// slice writes the VDB file of the slicer and decodes its grids.
+func slice(t *testing.T, slicer Slicer, opts *Options) []*testGrid {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "test")
+	if err := SliceWithOptions(base, slicer, opts); err != nil {
+		t.Fatalf("SliceWithOptions: %v", err)
+	}
+	f, err := os.Open(base + ".vdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	grids, err := readVDB(f)
+	if err != nil {
+		t.Fatalf("readVDB: %v", err)
+	}
+	return grids
+}
+
+// testGrid is a grid decoded by readVDB.
+type testGrid struct {
+	name, typ          string
+	translation, scale [3]float64
+	voxels             map[[3]int32]float32
+}
+
+// reader reads the little-endian values written by writer.
+type reader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *reader) read(v interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.LittleEndian, v)
+	}
+}
+
+func (r *reader) string() string {
+	var n uint32
+	r.read(&n)
+	if r.err != nil || n > 1<<20 {
+		return ""
+	}
+	b := make([]byte, n)
+	r.read(b)
+	return string(b)
+}
+
+func (r *reader) skipMetadata() {
+	var n uint32
+	r.read(&n)
+	for i := 0; i < int(n); i++ {
+		r.string()
+		r.string()
+		r.string()
+	}
+}
+
+// readVDB decodes the subset of the VDB format written by this package.
+func readVDB(f io.ReadSeeker) ([]*testGrid, error) {
+	r := &reader{r: bufio.NewReader(f)}
+	var header struct {
+		Magic          int64
+		Version        uint32
+		Major, Minor   uint32
+		HasGridOffsets uint8
+		UUID           [36]byte
+	}
+	r.read(&header)
+	if header.Magic != magic || header.Version != fileVersion || header.HasGridOffsets != 1 {
+		return nil, fmt.Errorf("bad header %+v", header)
+	}
+	r.skipMetadata()
+	var numGrids int32
+	r.read(&numGrids)
+
+	var grids []*testGrid
+	for n := 0; n < int(numGrids) && r.err == nil; n++ {
+		g := &testGrid{name: r.string(), typ: r.string(), voxels: map[[3]int32]float32{}}
+		r.string() // instance parent
+		var offsets [3]int64
+		r.read(&offsets)
+		var compression uint32
+		r.read(&compression)
+		if compression != compressActiveMask {
+			return nil, fmt.Errorf("compression = %v", compression)
+		}
+		r.skipMetadata()
+		r.string() // map type
+		r.read(&g.translation)
+		r.read(&g.scale)
+		var rest [4][3]float64
+		r.read(&rest)
+
+		isFloat := g.typ == "Tree_float_5_4_3"
+		readValue := func() float32 {
+			if isFloat {
+				var v float32
+				r.read(&v)
+				return v
+			}
+			var b uint8
+			r.read(&b)
+			return float32(b)
+		}
+
+		var bufferCount int32
+		r.read(&bufferCount)
+		readValue() // background
+		var numTiles, numChildren uint32
+		r.read(&numTiles)
+		r.read(&numChildren)
+		type leafInfo struct{ origin [3]int32 }
+		var leaves []leafInfo
+		for c := 0; c < int(numChildren); c++ {
+			var origin [3]int32
+			r.read(&origin)
+			var childMask5, valueMask5 [node5Size / 64]uint64
+			r.read(&childMask5)
+			r.read(&valueMask5)
+			var meta uint8
+			r.read(&meta)
+			for i := 0; i < node5Size; i++ {
+				if childMask5[i>>6]&(1<<(i&63)) == 0 {
+					continue
+				}
+				o4 := childOrigin(origin, i, 5, node4Log2)
+				var childMask4, valueMask4 [node4Size / 64]uint64
+				r.read(&childMask4)
+				r.read(&valueMask4)
+				r.read(&meta)
+				for j := 0; j < node4Size; j++ {
+					if valueMask4[j>>6]&(1<<(j&63)) == 0 {
+						continue
+					}
+					v := readValue()
+					lo := childOrigin(o4, j, 4, leafLog2)
+					for k := 0; k < leafSize; k++ {
+						g.voxels[childOrigin(lo, k, 3, 0)] = v
+					}
+				}
+				for j := 0; j < node4Size; j++ {
+					if childMask4[j>>6]&(1<<(j&63)) != 0 {
+						var mask [leafSize / 64]uint64
+						r.read(&mask)
+						leaves = append(leaves, leafInfo{childOrigin(o4, j, 4, leafLog2)})
+					}
+				}
+			}
+		}
+
+		pos, _ := f.Seek(0, io.SeekCurrent)
+		if blockPos := pos - int64(r.r.Buffered()); r.err == nil && blockPos != offsets[1] {
+			return nil, fmt.Errorf("grid %v: buffers at %v, want %v", g.name, blockPos, offsets[1])
+		}
+		for _, l := range leaves {
+			var mask [leafSize / 64]uint64
+			r.read(&mask)
+			var values []float32
+			if isFloat {
+				var meta uint8
+				r.read(&meta)
+				count := 0
+				for _, w := range mask {
+					count += bits.OnesCount64(w)
+				}
+				values = make([]float32, count)
+				r.read(values)
+			} else {
+				var origin [3]int32
+				var on [leafSize / 64]uint64
+				r.read(&origin)
+				r.read(&on)
+				if origin != l.origin {
+					return nil, fmt.Errorf("leaf origin = %v, want %v", origin, l.origin)
+				}
+			}
+			for k, next := 0, 0; k < leafSize; k++ {
+				if mask[k>>6]&(1<<(k&63)) == 0 {
+					continue
+				}
+				v := float32(1)
+				if isFloat {
+					v = values[next]
+					next++
+				}
+				g.voxels[childOrigin(l.origin, k, 3, 0)] = v
+			}
+		}
+		pos, _ = f.Seek(0, io.SeekCurrent)
+		if endPos := pos - int64(r.r.Buffered()); r.err == nil && endPos != offsets[2] {
+			return nil, fmt.Errorf("grid %v: ends at %v, want %v", g.name, endPos, offsets[2])
+		}
+		grids = append(grids, g)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if _, err := r.r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after grids")
+	}
+	return grids, nil
+}
+
+// childOrigin returns the origin of child i of a node with 2^dim children
+// per side, each 2^childLog2 voxels per side.
+func childOrigin(origin [3]int32, i int, dim, childLog2 uint) [3]int32 {
+	mask := 1<<dim - 1
+	return [3]int32{
+		origin[0] + int32((i>>(2*dim))&mask)<<childLog2,
+		origin[1] + int32((i>>dim)&mask)<<childLog2,
+		origin[2] + int32(i&mask)<<childLog2,
+	}
+}
@@ -0,0 +1,298 @@
+package vdb
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// File format constants (matching OpenVDB's io/Archive and io/Compression).
+const (
+	magic       = 0x56444220 // " BDV"
+	fileVersion = 224        // OPENVDB_FILE_VERSION_MULTIPASS_IO
+	libMajor    = 9
+	libMinor    = 0
+
+	// compressActiveMask saves only the active values of each node.
+	compressActiveMask = 0x2
+	// noMaskOrInactiveVals marks a node whose inactive values
+	// all equal the background.
+	noMaskOrInactiveVals = 0
+)
+
+// write writes the grids to w as a VDB file. The grid offsets are
+// filled in after each grid is written, so w must be seekable.
+func write(w io.WriteSeeker, grids []*grid) error {
+	vw := &writer{w: bufio.NewWriter(w)}
+	vw.header()
+	vw.metadata([][3]string{{"creator", "string", "irmf-slicer"}})
+	vw.int32(int32(len(grids)))
+	for _, g := range grids {
+		if err := vw.grid(w, g); err != nil {
+			return err
+		}
+	}
+	if err := vw.flush(); err != nil {
+		return fmt.Errorf("write VDB: %v", err)
+	}
+	return nil
+}
+
+// writer writes little-endian values and tracks the stream position.
+type writer struct {
+	w   *bufio.Writer
+	pos int64
+	err error
+}
+
+func (vw *writer) bytes(b []byte) {
+	if vw.err != nil {
+		return
+	}
+	var n int
+	n, vw.err = vw.w.Write(b)
+	vw.pos += int64(n)
+}
+
+func (vw *writer) value(v interface{}) {
+	if vw.err != nil {
+		return
+	}
+	vw.err = binary.Write(vw.w, binary.LittleEndian, v)
+	vw.pos += int64(binary.Size(v))
+}
+
+func (vw *writer) int32(v int32)   { vw.value(v) }
+func (vw *writer) uint32(v uint32) { vw.value(v) }
+
+// string writes a length-prefixed string.
+func (vw *writer) string(s string) {
+	vw.uint32(uint32(len(s)))
+	vw.bytes([]byte(s))
+}
+
+func (vw *writer) flush() error {
+	if vw.err != nil {
+		return vw.err
+	}
+	return vw.w.Flush()
+}
+
+func (vw *writer) header() {
+	vw.value(int64(magic))
+	vw.uint32(fileVersion)
+	vw.uint32(libMajor)
+	vw.uint32(libMinor)
+	vw.bytes([]byte{1}) // the file has grid offsets
+	vw.bytes([]byte(newUUID()))
+}
+
+// newUUID returns a random (version 4) UUID in its 36-character form.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// metadata writes a metadata map of (name, type, value) strings.
+// Only "string" values are supported.
+func (vw *writer) metadata(entries [][3]string) {
+	vw.uint32(uint32(len(entries)))
+	for _, e := range entries {
+		vw.string(e[0])
+		vw.string(e[1])
+		vw.string(e[2]) // a metadata value is its size followed by its bytes
+	}
+}
+
+// grid writes the grid descriptor and the grid, then goes back
+// to fill in the grid descriptor's stream offsets.
+func (vw *writer) grid(w io.WriteSeeker, g *grid) error {
+	typ, class := "Tree_bool_5_4_3", "unknown"
+	if g.density {
+		typ, class = "Tree_float_5_4_3", "fog volume"
+	}
+	vw.string(g.name)
+	vw.string(typ)
+	vw.string("") // instance parent
+
+	offsetPos := vw.pos
+	vw.value([3]int64{}) // grid, block and end positions, filled in below
+	gridPos := vw.pos
+
+	vw.uint32(compressActiveMask)
+	vw.metadata([][3]string{
+		{"class", "string", class},
+		{"name", "string", g.name},
+		{"creator", "string", "irmf-slicer"},
+	})
+	vw.transform(g)
+
+	roots := g.sortedRoots()
+	vw.int32(1) // buffer count
+	vw.background(g)
+	vw.uint32(0) // root tiles
+	vw.uint32(uint32(len(roots)))
+	for _, key := range roots {
+		vw.value(key)
+		vw.node5Topology(g, g.root[key])
+	}
+
+	blockPos := vw.pos
+	for _, key := range roots {
+		n5 := g.root[key]
+		for _, i := range sortedKeys4(n5.children) {
+			n4 := n5.children[i]
+			for _, j := range sortedLeaves(n4.leaves) {
+				vw.leafBuffers(g, n4.leaves[j])
+			}
+		}
+	}
+	endPos := vw.pos
+
+	if err := vw.flush(); err != nil {
+		return fmt.Errorf("write VDB: %v", err)
+	}
+	if _, err := w.Seek(offsetPos, io.SeekStart); err != nil {
+		return fmt.Errorf("Seek: %v", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, [3]int64{gridPos, blockPos, endPos}); err != nil {
+		return fmt.Errorf("write VDB: %v", err)
+	}
+	if _, err := w.Seek(endPos, io.SeekStart); err != nil {
+		return fmt.Errorf("Seek: %v", err)
+	}
+	return nil
+}
+
+// transform writes the map from index space to world space.
+func (vw *writer) transform(g *grid) {
+	s := g.voxelSize
+	if s[0] == s[1] && s[1] == s[2] {
+		vw.string("UniformScaleTranslateMap")
+	} else {
+		vw.string("ScaleTranslateMap")
+	}
+	vec := func(f func(float64) float64) {
+		vw.value([3]float64{f(s[0]), f(s[1]), f(s[2])})
+	}
+	vw.value(g.origin)                                  // translation
+	vec(func(v float64) float64 { return v })           // scale
+	vec(math.Abs)                                       // voxel size
+	vec(func(v float64) float64 { return 1 / v })       // inverse scale
+	vec(func(v float64) float64 { return 1 / (v * v) }) // inverse scale squared
+	vec(func(v float64) float64 { return 1 / (2 * v) }) // inverse twice scale
+}
+
+// background writes the background (inactive) value of the grid.
+func (vw *writer) background(g *grid) {
+	if g.density {
+		vw.value(float32(0))
+	} else {
+		vw.bytes([]byte{0})
+	}
+}
+
+func (vw *writer) node5Topology(g *grid, n5 *node5) {
+	var childMask [node5Size / 64]uint64
+	keys := sortedKeys4(n5.children)
+	for _, i := range keys {
+		childMask[i>>6] |= 1 << (i & 63)
+	}
+	vw.value(childMask)
+	vw.value([node5Size / 64]uint64{}) // no active tiles
+	vw.bytes([]byte{noMaskOrInactiveVals})
+	for _, i := range keys {
+		vw.node4Topology(g, n5.children[i])
+	}
+}
+
+func (vw *writer) node4Topology(g *grid, n4 *node4) {
+	var childMask, valueMask [node4Size / 64]uint64
+	for i := range n4.leaves {
+		childMask[i>>6] |= 1 << (i & 63)
+	}
+	tiles := make([]int, 0, len(n4.tiles))
+	for i := range n4.tiles {
+		valueMask[i>>6] |= 1 << (i & 63)
+		tiles = append(tiles, i)
+	}
+	sort.Ints(tiles)
+	vw.value(childMask)
+	vw.value(valueMask)
+
+	// Only the active tile values are saved.
+	vw.bytes([]byte{noMaskOrInactiveVals})
+	for _, i := range tiles {
+		if g.density {
+			vw.value(n4.tiles[i])
+		} else {
+			vw.bytes([]byte{1})
+		}
+	}
+
+	for _, i := range sortedLeaves(n4.leaves) {
+		vw.value(n4.leaves[i].active)
+	}
+}
+
+func (vw *writer) leafBuffers(g *grid, l *leaf) {
+	vw.value(l.active)
+	if !g.density {
+		// Bool leaves save their origin and their values as a bit mask.
+		vw.value(l.origin)
+		vw.value(l.active)
+		return
+	}
+	// Only the active values are saved.
+	vw.bytes([]byte{noMaskOrInactiveVals})
+	values := make([]float32, 0, leafSize)
+	for i, v := range l.values {
+		if l.active[i>>6]&(1<<(i&63)) != 0 {
+			values = append(values, v)
+		}
+	}
+	vw.value(values)
+}
+
+// sortedRoots returns the origins of the root's children in OpenVDB's
+// (lexicographic) order.
+func (g *grid) sortedRoots() [][3]int32 {
+	keys := make([][3]int32, 0, len(g.root))
+	for k := range g.root {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		for i := 0; i < 3; i++ {
+			if keys[a][i] != keys[b][i] {
+				return keys[a][i] < keys[b][i]
+			}
+		}
+		return false
+	})
+	return keys
+}
+
+func sortedKeys4(m map[int]*node4) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+func sortedLeaves(m map[int]*leaf) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
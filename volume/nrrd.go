@@ -0,0 +1,155 @@
+package volume
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NRRDSlice slices an IRMF model into a single NRRD file or, with
+// opts.Detached, into a ".nhdr" header and a raw data file. opts may be nil.
+// See http://teem.sourceforge.net/nrrd/format.html.
+func NRRDSlice(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	v, err := slice(slicer)
+	if err != nil {
+		return err
+	}
+	arrays, err := v.arrays(opts.Labels)
+	if err != nil {
+		return err
+	}
+
+	if !opts.Detached {
+		filename := baseFilename + ".nrrd"
+		log.Printf("Writing: %v", filename)
+		return writeFile(filename, func(w io.Writer) error {
+			if err := v.writeNRRDHeader(w, opts, ""); err != nil {
+				return err
+			}
+			return v.writeNRRDData(w, arrays, opts.Compress)
+		})
+	}
+
+	dataFilename := baseFilename + ".raw"
+	if opts.Compress {
+		dataFilename += ".gz"
+	}
+	filename := baseFilename + ".nhdr"
+	log.Printf("Writing: %v", filename)
+	if err := writeFile(filename, func(w io.Writer) error {
+		return v.writeNRRDHeader(w, opts, filepath.Base(dataFilename))
+	}); err != nil {
+		return err
+	}
+	log.Printf("Writing: %v", dataFilename)
+	return writeFile(dataFilename, func(w io.Writer) error {
+		return v.writeNRRDData(w, arrays, opts.Compress)
+	})
+}
+
+// writeFile creates the named file and writes it with fn.
+func writeFile(filename string, fn func(w io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	bw := bufio.NewWriter(f)
+	if err := fn(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("write NRRD: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close NRRD file: %v", err)
+	}
+	return nil
+}
+
+// writeNRRDHeader writes the NRRD header. The voxels are sampled at their
+// centers, and multiple materials are stacked along a fourth (slowest) axis.
+// If dataFilename is empty, the data follows the header in the same file.
+func (v *volume) writeNRRDHeader(w io.Writer, opts *Options, dataFilename string) error {
+	perMaterial := !opts.Labels && len(v.materials) > 1
+	sizes := fmt.Sprintf("%v %v %v", v.nx, v.ny, v.nz)
+	directions := fmt.Sprintf("(%v,0,0) (0,%v,0) (0,0,%v)", v.spacing[0], v.spacing[1], v.spacing[2])
+	kinds := "domain domain domain"
+	dimension := 3
+	if perMaterial {
+		sizes += fmt.Sprintf(" %v", len(v.materials))
+		directions += " none"
+		kinds += " list"
+		dimension = 4
+	}
+	encoding := "raw"
+	if opts.Compress {
+		encoding = "gzip"
+	}
+
+	lines := []string{
+		"NRRD0004",
+		"# Complete NRRD file format specification at:",
+		"# http://teem.sourceforge.net/nrrd/format.html",
+		"type: uint8",
+		fmt.Sprintf("dimension: %v", dimension),
+		"space: right-anterior-superior",
+		"sizes: " + sizes,
+		"space directions: " + directions,
+		"kinds: " + kinds,
+		"space units: \"mm\" \"mm\" \"mm\"",
+		"encoding: " + encoding,
+		fmt.Sprintf("space origin: (%v,%v,%v)", v.origin[0], v.origin[1], v.origin[2]),
+	}
+	if opts.Labels {
+		lines = append(lines, "label00:=empty")
+	}
+	for i, m := range v.materials {
+		key := "material"
+		if opts.Labels {
+			key = "label"
+		}
+		lines = append(lines, fmt.Sprintf("%v%02d:=%v", key, i+1, strings.ReplaceAll(m.name, "\n", " ")))
+	}
+	if dataFilename != "" {
+		lines = append(lines, "data file: "+dataFilename)
+	}
+
+	// An attached header ends with a blank line.
+	if dataFilename == "" {
+		lines = append(lines, "")
+	}
+	if _, err := io.WriteString(w, strings.Join(lines, "\n")+"\n"); err != nil {
+		return fmt.Errorf("write NRRD: %v", err)
+	}
+	return nil
+}
+
+// writeNRRDData writes the voxels of the arrays, optionally gzip-compressed.
+func (v *volume) writeNRRDData(w io.Writer, arrays []*material, compress bool) error {
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(w)
+		w = gw
+	}
+	for _, m := range arrays {
+		if err := v.writeRaw(w, m); err != nil {
+			return fmt.Errorf("write NRRD: %v", err)
+		}
+	}
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("write NRRD: %v", err)
+		}
+	}
+	return nil
+}
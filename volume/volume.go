@@ -0,0 +1,237 @@
+// Package volume slices the model and writes scalar volume files
+// for analysis tools: VTK XML image data (.vti) and NRRD.
+package volume
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the volume output.
+type Options struct {
+	// Labels writes a single label volume whose voxels hold the number
+	// of the material occupying them (or 0 if empty) instead of
+	// one coverage volume (0 to 255) per material.
+	Labels bool
+	// Compress compresses the voxel data (zlib for VTI, gzip for NRRD).
+	Compress bool
+	// Detached writes the NRRD voxel data to a separate file
+	// next to a ".nhdr" header instead of into the ".nrrd" file.
+	Detached bool
+}
+
+// grid describes the voxel grid.
+type grid struct {
+	nx, ny, nz int
+	origin     [3]float64 // center of the first voxel
+	spacing    [3]float64
+}
+
+// material holds the zlib-compressed slices of one material.
+type material struct {
+	name   string
+	slices [][]byte
+}
+
+// volume holds the sliced materials of the model.
+type volume struct {
+	grid
+	materials []*material
+}
+
+// slice renders all the materials of the model. Each slice is compressed
+// as it arrives, so that the uncompressed volume is never held in memory.
+func slice(slicer Slicer) (*volume, error) {
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return nil, fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	v := &volume{grid: grid{nx: slicer.NumXSlices(), ny: slicer.NumYSlices(), nz: slicer.NumZSlices()}}
+	min, max := slicer.MBB()
+	for i, n := range []int{v.nx, v.ny, v.nz} {
+		v.spacing[i] = float64(max[i]-min[i]) / float64(n)
+		v.origin[i] = float64(min[i]) + v.spacing[i]/2
+	}
+
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		c := &capturer{
+			grid: v.grid,
+			m:    &material{name: slicer.MaterialName(materialNum), slices: make([][]byte, v.nz)},
+		}
+		if err := slicer.RenderZSlices(materialNum, c, irmf.MinToMax); err != nil {
+			return nil, fmt.Errorf("RenderZSlices: %v", err)
+		}
+		v.materials = append(v.materials, c.m)
+	}
+	return v, nil
+}
+
+// capturer compresses the slices of one material.
+// It implements the irmf.ZSliceProcessor interface.
+type capturer struct {
+	grid
+	m   *material
+	buf []byte
+}
+
+var _ irmf.ZSliceProcessor = &capturer{}
+
+func (c *capturer) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != c.nx || b.Dy() != c.ny {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, b.Dx(), b.Dy(), c.nx, c.ny)
+	}
+	if sliceNum < 0 || sliceNum >= c.nz {
+		return fmt.Errorf("slice %v out of range [0,%v)", sliceNum, c.nz)
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, c.nx*c.ny)
+	}
+
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < c.ny; y++ {
+			row := rgba.Pix[y*rgba.Stride:]
+			for x := 0; x < c.nx; x++ {
+				c.buf[y*c.nx+x] = row[x*4]
+			}
+		}
+	} else {
+		for y := 0; y < c.ny; y++ {
+			for x := 0; x < c.nx; x++ {
+				c.buf[y*c.nx+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+
+	// Empty slices are not stored.
+	for _, v := range c.buf {
+		if v != 0 {
+			data, err := compress(c.buf)
+			if err != nil {
+				return err
+			}
+			c.m.slices[sliceNum] = data
+			return nil
+		}
+	}
+	return nil
+}
+
+// compress returns the zlib-compressed data.
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("zlib: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("zlib: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// slice decompresses slice z of the material into dst.
+// Slices that were never rendered are empty.
+func (m *material) slice(z int, dst []byte) error {
+	if m.slices[z] == nil {
+		for i := range dst {
+			dst[i] = 0
+		}
+		return nil
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(m.slices[z]))
+	if err != nil {
+		return fmt.Errorf("zlib: %v", err)
+	}
+	if _, err := io.ReadFull(zr, dst); err != nil {
+		return fmt.Errorf("zlib: %v", err)
+	}
+	return zr.Close()
+}
+
+// labels returns the label volume: each voxel holds the number of the
+// material with the greatest coverage of at least one half, or 0 if no
+// material covers half of the voxel.
+func (v *volume) labels() (*material, error) {
+	if n := len(v.materials); n > math.MaxUint8 {
+		return nil, fmt.Errorf("label volumes support at most %v materials, got %v", math.MaxUint8, n)
+	}
+	l := &material{name: "material", slices: make([][]byte, v.nz)}
+	dst, tmp, best := make([]byte, v.nx*v.ny), make([]byte, v.nx*v.ny), make([]byte, v.nx*v.ny)
+	for z := 0; z < v.nz; z++ {
+		for i := range dst {
+			dst[i], best[i] = 0, 127
+		}
+		var occupied bool
+		for n, m := range v.materials {
+			if m.slices[z] == nil {
+				continue
+			}
+			if err := m.slice(z, tmp); err != nil {
+				return nil, err
+			}
+			for i, c := range tmp {
+				if c > best[i] {
+					dst[i], best[i] = byte(n+1), c
+					occupied = true
+				}
+			}
+		}
+		if !occupied {
+			continue
+		}
+		data, err := compress(dst)
+		if err != nil {
+			return nil, err
+		}
+		l.slices[z] = data
+	}
+	return l, nil
+}
+
+// arrays returns the volumes to write: one per material, or the label volume.
+func (v *volume) arrays(labels bool) ([]*material, error) {
+	if !labels {
+		return v.materials, nil
+	}
+	l, err := v.labels()
+	if err != nil {
+		return nil, err
+	}
+	return []*material{l}, nil
+}
+
+// writeRaw writes the uncompressed slices of the material to w.
+func (v *volume) writeRaw(w io.Writer, m *material) error {
+	buf := make([]byte, v.nx*v.ny)
+	for z := 0; z < v.nz; z++ {
+		if err := m.slice(z, buf); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package volume
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+)
+
+// fakeVoxels are the voxels of a 4x3x2 volume in which material 1
+// half covers voxel (1,0,0) and fully covers voxel (2,1,1), and material 2
+// fully covers voxel (1,0,0) and a quarter of voxel (3,2,1).
+var fakeVoxels = []map[[3]int]uint8{
+	{{1, 0, 0}: 128, {2, 1, 1}: 255},
+	{{1, 0, 0}: 255, {3, 2, 1}: 64},
+}
+
+// newSlicer returns a fake slicer of fakeVoxels with 0.5mm voxels.
+func newSlicer() *slicertest.Slicer {
+	return &slicertest.Slicer{
+		Names:  []string{"PLA", "TPU"},
+		Size:   [3]int{4, 3, 2},
+		Min:    [3]float32{-1, 0, 0},
+		Max:    [3]float32{1, 1.5, 1},
+		Voxels: fakeVoxels,
+	}
+}
+
+// want returns the expected arrays in x, y, z order.
+func want(labels bool) [][]byte {
+	index := func(p [3]int) int { return p[0] + 4*(p[1]+3*p[2]) }
+	if labels {
+		l := make([]byte, 24)
+		l[index([3]int{1, 0, 0})] = 2 // the greater coverage wins
+		l[index([3]int{2, 1, 1})] = 1
+		return [][]byte{l} // (3,2,1) is less than half covered
+	}
+	var arrays [][]byte
+	for _, voxels := range fakeVoxels {
+		a := make([]byte, 24)
+		for p, v := range voxels {
+			a[index(p)] = v
+		}
+		arrays = append(arrays, a)
+	}
+	return arrays
+}
+
+func TestEmptySlices(t *testing.T) {
+	// Material 1 occupies slices 1 and 3, but only less than half of
+	// slice 3, and material 2 is empty.
+	slicer := &slicertest.Slicer{
+		Size:   [3]int{2, 1, 4},
+		Voxels: []map[[3]int]uint8{{{0, 0, 1}: 255, {1, 0, 3}: 100}, {}},
+	}
+	v, err := slice(slicer)
+	if err != nil {
+		t.Fatalf("slice: %v", err)
+	}
+	l, err := v.labels()
+	if err != nil {
+		t.Fatalf("labels: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		m          *material
+		wantStored []bool
+		want       []byte
+	}{
+		{name: "material 1", m: v.materials[0], wantStored: []bool{false, true, false, true}, want: []byte{0, 0, 255, 0, 0, 0, 0, 100}},
+		{name: "material 2", m: v.materials[1], wantStored: []bool{false, false, false, false}, want: make([]byte, 8)},
+		{name: "labels", m: l, wantStored: []bool{false, true, false, false}, want: []byte{0, 0, 1, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for z, want := range tt.wantStored {
+				if got := tt.m.slices[z] != nil; got != want {
+					t.Errorf("slice %v stored = %v, want %v", z, got, want)
+				}
+			}
+			var buf bytes.Buffer
+			if err := v.writeRaw(&buf, tt.m); err != nil {
+				t.Fatalf("writeRaw: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("writeRaw = %v, want %v", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTooManyLabels(t *testing.T) {
+	slicer := &slicertest.Slicer{Size: [3]int{1, 1, 1}, Voxels: make([]map[[3]int]uint8, 256)}
+	base := filepath.Join(t.TempDir(), "test")
+	if err := VTISlice(base, slicer, &Options{Labels: true}); err == nil {
+		t.Error("VTISlice = nil, want error")
+	}
+	if err := VTISlice(base, slicer, &Options{}); err != nil {
+		t.Errorf("VTISlice without labels: %v", err)
+	}
+}
+
+func TestVTISlice(t *testing.T) {
+	tests := []struct {
+		opts      Options
+		wantNames []string
+	}{
+		{opts: Options{}, wantNames: []string{"PLA", "TPU"}},
+		{opts: Options{Compress: true}, wantNames: []string{"PLA", "TPU"}},
+		{opts: Options{Labels: true, Compress: true}, wantNames: []string{"material"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%+v", tt.opts), func(t *testing.T) {
+			base := filepath.Join(t.TempDir(), "test")
+			if err := VTISlice(base, newSlicer(), &tt.opts); err != nil {
+				t.Fatalf("VTISlice: %v", err)
+			}
+			data, err := ioutil.ReadFile(base + ".vti")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The XML ends with the appended data, which starts after '_'.
+			marker := []byte("<AppendedData encoding=\"raw\">\n   _")
+			start := bytes.Index(data, marker)
+			end := bytes.LastIndex(data, []byte("\n  </AppendedData>"))
+			if start < 0 || end < start {
+				t.Fatalf("missing appended data")
+			}
+			appended := data[start+len(marker) : end]
+			var doc struct {
+				Compressor string `xml:"compressor,attr"`
+				Image      struct {
+					WholeExtent string `xml:"WholeExtent,attr"`
+					Origin      string `xml:"Origin,attr"`
+					Spacing     string `xml:"Spacing,attr"`
+					Arrays      []struct {
+						Name   string `xml:"Name,attr"`
+						Offset int    `xml:"offset,attr"`
+					} `xml:"Piece>PointData>DataArray"`
+				} `xml:"ImageData"`
+			}
+			header := string(data[:start]) + "</VTKFile>"
+			if err := xml.Unmarshal([]byte(header), &doc); err != nil {
+				t.Fatalf("xml.Unmarshal: %v", err)
+			}
+			if doc.Image.WholeExtent != "0 3 0 2 0 1" || doc.Image.Origin != "-0.75 0.25 0.25" || doc.Image.Spacing != "0.5 0.5 0.5" {
+				t.Errorf("image = %+v", doc.Image)
+			}
+			if got := doc.Compressor != ""; got != tt.opts.Compress {
+				t.Errorf("compressor = %q, want compression %v", doc.Compressor, tt.opts.Compress)
+			}
+
+			if len(doc.Image.Arrays) != len(tt.wantNames) {
+				t.Fatalf("got %v arrays, want %v", len(doc.Image.Arrays), len(tt.wantNames))
+			}
+			for i, a := range doc.Image.Arrays {
+				if a.Name != tt.wantNames[i] {
+					t.Errorf("array %v name = %q, want %q", i, a.Name, tt.wantNames[i])
+				}
+				got, err := readVTIArray(appended[a.Offset:], tt.opts.Compress)
+				if err != nil {
+					t.Fatalf("array %v: %v", i, err)
+				}
+				if w := want(tt.opts.Labels)[i]; !bytes.Equal(got, w) {
+					t.Errorf("array %v = %v, want %v", i, got, w)
+				}
+			}
+		})
+	}
+}
+
+// readVTIArray decodes an appended array.
+func readVTIArray(data []byte, compressed bool) ([]byte, error) {
+	le := binary.LittleEndian
+	if !compressed {
+		n := le.Uint64(data)
+		return data[8 : 8+n], nil
+	}
+	numBlocks, blockSize, last := le.Uint64(data), le.Uint64(data[8:]), le.Uint64(data[16:])
+	if last != 0 {
+		return nil, fmt.Errorf("last block size = %v, want 0", last)
+	}
+	var out []byte
+	pos := 8 * (3 + numBlocks)
+	for i := uint64(0); i < numBlocks; i++ {
+		size := le.Uint64(data[8*(3+i):])
+		zr, err := zlib.NewReader(bytes.NewReader(data[pos : pos+size]))
+		if err != nil {
+			return nil, err
+		}
+		block, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(block)) != blockSize {
+			return nil, fmt.Errorf("block %v has %v bytes, want %v", i, len(block), blockSize)
+		}
+		out = append(out, block...)
+		pos += size
+	}
+	return out, nil
+}
+
+func TestNRRDSlice(t *testing.T) {
+	tests := []struct {
+		opts      Options
+		wantFile  string
+		wantSizes string
+		wantKeys  []string
+	}{
+		{opts: Options{}, wantFile: ".nrrd", wantSizes: "4 3 2 2", wantKeys: []string{"material01:=PLA", "material02:=TPU"}},
+		{opts: Options{Compress: true}, wantFile: ".nrrd", wantSizes: "4 3 2 2"},
+		{opts: Options{Detached: true}, wantFile: ".nhdr", wantSizes: "4 3 2 2"},
+		{opts: Options{Detached: true, Compress: true, Labels: true}, wantFile: ".nhdr", wantSizes: "4 3 2", wantKeys: []string{"label00:=empty", "label02:=TPU"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%+v", tt.opts), func(t *testing.T) {
+			dir := t.TempDir()
+			base := filepath.Join(dir, "test")
+			if err := NRRDSlice(base, newSlicer(), &tt.opts); err != nil {
+				t.Fatalf("NRRDSlice: %v", err)
+			}
+			f, err := os.Open(base + tt.wantFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r := bufio.NewReader(f)
+			fields := map[string]string{}
+			var keys []string
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break // a detached header has no blank line
+				}
+				line = strings.TrimSuffix(line, "\n")
+				if line == "" {
+					break
+				}
+				if i := strings.Index(line, ":="); i > 0 {
+					keys = append(keys, line)
+				} else if i := strings.Index(line, ": "); i > 0 {
+					fields[line[:i]] = line[i+2:]
+				}
+			}
+			if fields["sizes"] != tt.wantSizes {
+				t.Errorf("sizes = %q, want %q", fields["sizes"], tt.wantSizes)
+			}
+			if got := fields["space origin"]; got != "(-0.75,0.25,0.25)" {
+				t.Errorf("space origin = %q", got)
+			}
+			if got := fields["space directions"]; !strings.HasPrefix(got, "(0.5,0,0) (0,0.5,0) (0,0,0.5)") {
+				t.Errorf("space directions = %q", got)
+			}
+			for _, k := range tt.wantKeys {
+				if !strings.Contains(strings.Join(keys, "\n"), k) {
+					t.Errorf("keys = %v, want %q", keys, k)
+				}
+			}
+
+			var data io.Reader = r
+			if tt.opts.Detached {
+				df, err := os.Open(filepath.Join(dir, fields["data file"]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer df.Close()
+				data = df
+			}
+			if fields["encoding"] == "gzip" {
+				gr, err := gzip.NewReader(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = gr
+			}
+			got, err := ioutil.ReadAll(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w := bytes.Join(want(tt.opts.Labels), nil); !bytes.Equal(got, w) {
+				t.Errorf("data = %v, want %v", got, w)
+			}
+		})
+	}
+}
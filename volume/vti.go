@@ -0,0 +1,148 @@
+package volume
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/textfmt"
+)
+
+// VTISlice slices an IRMF model into a single VTK XML image data (.vti)
+// file with its data appended in binary. opts may be nil.
+func VTISlice(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	v, err := slice(slicer)
+	if err != nil {
+		return err
+	}
+
+	filename := baseFilename + ".vti"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	if err := v.writeVTI(f, opts); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close VTI file: %v", err)
+	}
+	return nil
+}
+
+// writeVTI writes the volume as point data, so that each voxel center
+// is a point of the image. Each array is stored in the appended data
+// section as a UInt64 byte count followed by its bytes or, when
+// compressed, as a UInt64 block header followed by one zlib block per slice.
+func (v *volume) writeVTI(w io.Writer, opts *Options) error {
+	arrays, err := v.arrays(opts.Labels)
+	if err != nil {
+		return err
+	}
+	sliceSize := v.nx * v.ny
+
+	// Compressed arrays reuse the compressed slices, with a shared block
+	// for the empty slices.
+	var empty []byte
+	if opts.Compress {
+		if empty, err = compress(make([]byte, sliceSize)); err != nil {
+			return err
+		}
+	}
+	block := func(m *material, z int) []byte {
+		if m.slices[z] == nil {
+			return empty
+		}
+		return m.slices[z]
+	}
+
+	bw := bufio.NewWriter(w)
+	var werr error
+	printf := func(format string, args ...interface{}) {
+		if werr == nil {
+			_, werr = fmt.Fprintf(bw, format, args...)
+		}
+	}
+	write := func(data interface{}) {
+		if werr == nil {
+			werr = binary.Write(bw, binary.LittleEndian, data)
+		}
+	}
+
+	printf("<?xml version=\"1.0\"?>\n")
+	printf(`<VTKFile type="ImageData" version="1.0" byte_order="LittleEndian" header_type="UInt64"`)
+	if opts.Compress {
+		printf(` compressor="vtkZLibDataCompressor"`)
+	}
+	printf(">\n")
+	if opts.Labels {
+		names := []string{"0: empty"}
+		for i, m := range v.materials {
+			names = append(names, fmt.Sprintf("%v: %v", i+1, textfmt.XMLComment(m.name)))
+		}
+		printf("  <!-- material labels: %v -->\n", strings.Join(names, ", "))
+	}
+	extent := fmt.Sprintf("0 %v 0 %v 0 %v", v.nx-1, v.ny-1, v.nz-1)
+	printf("  <ImageData WholeExtent=\"%v\" Origin=\"%v %v %v\" Spacing=\"%v %v %v\">\n", extent,
+		v.origin[0], v.origin[1], v.origin[2], v.spacing[0], v.spacing[1], v.spacing[2])
+	printf("    <Piece Extent=\"%v\">\n", extent)
+	printf("      <PointData Scalars=\"%v\">\n", textfmt.XMLEscape(arrays[0].name))
+	var offset int
+	for _, m := range arrays {
+		printf("        <DataArray type=\"UInt8\" Name=\"%v\" NumberOfComponents=\"1\" format=\"appended\" offset=\"%v\"/>\n", textfmt.XMLEscape(m.name), offset)
+		if opts.Compress {
+			offset += 8 * (3 + v.nz)
+			for z := 0; z < v.nz; z++ {
+				offset += len(block(m, z))
+			}
+		} else {
+			offset += 8 + sliceSize*v.nz
+		}
+	}
+	printf("      </PointData>\n")
+	printf("    </Piece>\n")
+	printf("  </ImageData>\n")
+	printf("  <AppendedData encoding=\"raw\">\n   _")
+
+	for _, m := range arrays {
+		if werr != nil {
+			break
+		}
+		if !opts.Compress {
+			write(uint64(sliceSize * v.nz))
+			if werr == nil {
+				werr = v.writeRaw(bw, m)
+			}
+			continue
+		}
+		// Header: number of blocks, block size, size of the last
+		// (partial) block or 0 if it is full, and each compressed size.
+		header := []uint64{uint64(v.nz), uint64(sliceSize), 0}
+		for z := 0; z < v.nz; z++ {
+			header = append(header, uint64(len(block(m, z))))
+		}
+		write(header)
+		for z := 0; z < v.nz; z++ {
+			write(block(m, z))
+		}
+	}
+	printf("\n  </AppendedData>\n")
+	printf("</VTKFile>\n")
+
+	if werr == nil {
+		werr = bw.Flush()
+	}
+	if werr != nil {
+		return fmt.Errorf("write VTI: %v", werr)
+	}
+	return nil
+}
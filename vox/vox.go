@@ -0,0 +1,145 @@
+// Package vox slices the model and writes a single MagicaVoxel (.vox)
+// file in which each material is a palette entry.
+// See https://github.com/ephtracy/voxel-model/blob/master/MagicaVoxel-file-format-vox.txt.
+package vox
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+)
+
+// Slicer represents a slicer that provides slices of voxels for multiple
+// materials (from an IRMF model).
+type Slicer interface {
+	NumMaterials() int
+	MaterialName(materialNum int) string // 1-based
+	MBB() (min, max [3]float32)          // in millimeters
+
+	PrepareRenderZ() error
+	RenderZSlices(materialNum int, sp irmf.ZSliceProcessor, order irmf.Order) error
+	NumXSlices() int
+	NumYSlices() int
+	NumZSlices() int
+}
+
+// Options controls the VOX output.
+type Options struct {
+	// Colors overrides the color of materials (keyed by 1-based material number).
+	// See matcolor.Colors for the default colors.
+	Colors map[int]color.NRGBA
+}
+
+// maxModelSize is the maximum size of a MagicaVoxel model along each axis.
+// Larger volumes are split into several models.
+const maxModelSize = 256
+
+// maxMaterials is the number of palette entries available for materials.
+const maxMaterials = 255
+
+// Slice slices an IRMF model into a single VOX file using the default options.
+func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into a single VOX file in which
+// material N is palette index N. opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if n := slicer.NumMaterials(); n > maxMaterials {
+		return fmt.Errorf("VOX files support at most %v materials, got %v", maxMaterials, n)
+	}
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	var names []string
+	v := &volume{size: [3]int{slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()}}
+	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
+		name := slicer.MaterialName(materialNum)
+		names = append(names, name)
+		c := &collector{size: v.size, chunks: map[[3]int][]voxel{}}
+		if err := slicer.RenderZSlices(materialNum, c, irmf.MinToMax); err != nil {
+			return fmt.Errorf("RenderZSlices: %v", err)
+		}
+		log.Printf("Material %v (%v): palette index %v, %v voxels", materialNum, name, materialNum, c.count)
+		v.materials = append(v.materials, c.chunks)
+	}
+	colors := matcolor.Colors(names, opts.Colors)
+
+	filename := baseFilename + ".vox"
+	log.Printf("Writing: %v", filename)
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Create: %v", err)
+	}
+	if err := write(f, v, colors); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Unable to close VOX file: %v", err)
+	}
+	return nil
+}
+
+// voxel is an occupied voxel within a model, with its coverage.
+type voxel struct {
+	x, y, z  uint8
+	coverage uint8
+}
+
+// volume holds the occupied voxels of each material, grouped by the
+// (maxModelSize^3) model containing them.
+type volume struct {
+	size      [3]int
+	materials []map[[3]int][]voxel // keyed by the model's index along each axis
+}
+
+// collector collects the voxels of one material that are
+// at least half occupied.
+// It implements the irmf.ZSliceProcessor interface.
+type collector struct {
+	size   [3]int
+	chunks map[[3]int][]voxel
+	count  int
+}
+
+var _ irmf.ZSliceProcessor = &collector{}
+
+func (c *collector) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() != c.size[0] || b.Dy() != c.size[1] {
+		return fmt.Errorf("slice %v is %vx%v, want %vx%v", sliceNum, b.Dx(), b.Dy(), c.size[0], c.size[1])
+	}
+	rgba, _ := img.(*image.RGBA)
+	for y := 0; y < c.size[1]; y++ {
+		for x := 0; x < c.size[0]; x++ {
+			var v uint8
+			if rgba != nil {
+				v = rgba.Pix[y*rgba.Stride+x*4]
+			} else {
+				v = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+			if v < 128 {
+				continue
+			}
+			key := [3]int{x / maxModelSize, y / maxModelSize, sliceNum / maxModelSize}
+			c.chunks[key] = append(c.chunks[key], voxel{
+				x:        uint8(x % maxModelSize),
+				y:        uint8(y % maxModelSize),
+				z:        uint8(sliceNum % maxModelSize),
+				coverage: v,
+			})
+			c.count++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package vox
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/internal/slicertest"
+	"github.com/gmlewis/irmf-slicer/v3/matcolor"
+)
+
+func TestSliceWithOptions(t *testing.T) {
+	material1 := map[[3]int]uint8{{10, 0, 0}: 255, {20, 0, 0}: 150, {299, 1, 2}: 255}
+	for x := 0; x < 300; x += 50 {
+		material1[[3]int{x, 1, 1}] = 255
+	}
+	// 300x2x3 voxels are split into two models.
+	slicer := &slicertest.Slicer{
+		Names: []string{"red PLA", "blue TPU"},
+		Size:  [3]int{300, 2, 3},
+		Max:   [3]float32{30, 0.2, 0.3},
+		Voxels: []map[[3]int]uint8{
+			material1,
+			{{10, 0, 0}: 200, {20, 0, 0}: 255, {270, 1, 2}: 200, {5, 1, 1}: 100},
+		},
+	}
+
+	want := map[[3]int]uint8{}
+	for p := range material1 {
+		want[p] = 1
+	}
+	want[[3]int{20, 0, 0}] = 2 // the greater coverage wins
+	want[[3]int{270, 1, 2}] = 2
+
+	f := slice(t, slicer, &Options{Colors: map[int]color.NRGBA{2: {0, 255, 0, 255}}})
+	checkModels(t, f, slicer.Size, [][3]int{{256, 2, 3}, {44, 2, 3}}, want)
+
+	if red := matcolor.Colors([]string{"red PLA"}, nil)[0]; f.palette[0] != red || f.palette[1] != (color.NRGBA{0, 255, 0, 255}) {
+		t.Errorf("palette = %v, want %v and (overridden) green", f.palette[:2], red)
+	}
+}
+
+func TestModels(t *testing.T) {
+	tests := []struct {
+		name       string
+		size       [3]int
+		voxels     []map[[3]int]uint8
+		wantSizes  [][3]int
+		wantVoxels map[[3]int]uint8
+	}{
+		{
+			name:       "empty model skipped",
+			size:       [3]int{600, 1, 1},
+			voxels:     []map[[3]int]uint8{{{0, 0, 0}: 255, {599, 0, 0}: 255}},
+			wantSizes:  [][3]int{{256, 1, 1}, {88, 1, 1}},
+			wantVoxels: map[[3]int]uint8{{0, 0, 0}: 1, {599, 0, 0}: 1},
+		},
+		{
+			name: "several contested models",
+			size: [3]int{512, 1, 2},
+			voxels: []map[[3]int]uint8{
+				{{0, 0, 0}: 200, {300, 0, 1}: 255},
+				{{0, 0, 0}: 255, {300, 0, 1}: 200, {400, 0, 0}: 255},
+			},
+			wantSizes:  [][3]int{{256, 1, 2}, {256, 1, 2}},
+			wantVoxels: map[[3]int]uint8{{0, 0, 0}: 2, {300, 0, 1}: 1, {400, 0, 0}: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slicer := &slicertest.Slicer{Size: tt.size, Voxels: tt.voxels}
+			checkModels(t, slice(t, slicer, nil), tt.size, tt.wantSizes, tt.wantVoxels)
+		})
+	}
+}
+
+func TestMaxMaterials(t *testing.T) {
+	// Each material occupies one voxel, and the last one uses
+	// the last palette index.
+	slicer := &slicertest.Slicer{Size: [3]int{maxMaterials, 1, 1}}
+	want := map[[3]int]uint8{}
+	for x := 0; x < maxMaterials; x++ {
+		slicer.Voxels = append(slicer.Voxels, map[[3]int]uint8{{x, 0, 0}: 255})
+		want[[3]int{x, 0, 0}] = uint8(x + 1)
+	}
+	last := color.NRGBA{1, 2, 3, 255}
+	f := slice(t, slicer, &Options{Colors: map[int]color.NRGBA{maxMaterials: last}})
+	checkModels(t, f, slicer.Size, [][3]int{{maxMaterials, 1, 1}}, want)
+	if f.palette[maxMaterials-1] != last {
+		t.Errorf("palette[%v] = %v, want %v", maxMaterials-1, f.palette[maxMaterials-1], last)
+	}
+
+	slicer.Voxels = append(slicer.Voxels, nil)
+	if err := SliceWithOptions(filepath.Join(t.TempDir(), "test"), slicer, nil); err == nil {
+		t.Errorf("SliceWithOptions(%v materials) = nil, want error", len(slicer.Voxels))
+	}
+}
+
+// slice writes the VOX file of the slicer and decodes it.
+func slice(t *testing.T, slicer Slicer, opts *Options) *testFile {
+	t.Helper()
+	base := filepath.Join(t.TempDir(), "test")
+	if err := SliceWithOptions(base, slicer, opts); err != nil {
+		t.Fatalf("SliceWithOptions: %v", err)
+	}
+	data, err := ioutil.ReadFile(base + ".vox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := readVOX(data)
+	if err != nil {
+		t.Fatalf("readVOX: %v", err)
+	}
+	return f
+}
+
+// checkModels checks the model sizes and the (material) voxels of the
+// file, which holds a volume of the given size.
+func checkModels(t *testing.T, f *testFile, size [3]int, wantSizes [][3]int, want map[[3]int]uint8) {
+	t.Helper()
+	if len(f.models) != len(wantSizes) {
+		t.Fatalf("got %v models, want %v", len(f.models), len(wantSizes))
+	}
+	got := map[[3]int]uint8{}
+	for i, m := range f.models {
+		if m.size != wantSizes[i] {
+			t.Errorf("model %v size = %v, want %v", i, m.size, wantSizes[i])
+		}
+		for _, p := range m.voxels {
+			// Undo the centering of the model and of the volume.
+			g := [3]int{}
+			for k := range g {
+				g[k] = int(p[k]) - m.size[k]/2 + m.translation[k] + size[k]/2
+			}
+			if _, ok := got[g]; ok {
+				t.Errorf("voxel %v written twice", g)
+			}
+			got[g] = p[3]
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %v voxels, want %v", len(got), len(want))
+	}
+	for p, w := range want {
+		if got[p] != w {
+			t.Errorf("voxel %v = %v, want %v", p, got[p], w)
+		}
+	}
+}
+
+// testModel is a model decoded by readVOX.
+type testModel struct {
+	size        [3]int
+	voxels      [][4]uint8
+	translation [3]int
+}
+
+type testFile struct {
+	models  []*testModel
+	palette [256]color.NRGBA
+}
+
+// readVOX decodes the subset of the VOX format written by this package.
+func readVOX(data []byte) (*testFile, error) {
+	le := binary.LittleEndian
+	if string(data[:4]) != "VOX " || le.Uint32(data[4:]) != version || string(data[8:12]) != "MAIN" {
+		return nil, fmt.Errorf("bad header % x", data[:12])
+	}
+	if n := int(le.Uint32(data[16:])); 20+n != len(data) {
+		return nil, fmt.Errorf("MAIN children size = %v, want %v", n, len(data)-20)
+	}
+
+	f := &testFile{}
+	i32 := func(b []byte, pos *int) int {
+		v := int(int32(le.Uint32(b[*pos:])))
+		*pos += 4
+		return v
+	}
+	str := func(b []byte, pos *int) string {
+		n := i32(b, pos)
+		s := string(b[*pos : *pos+n])
+		*pos += n
+		return s
+	}
+	dict := func(b []byte, pos *int) map[string]string {
+		d := map[string]string{}
+		for n := i32(b, pos); n > 0; n-- {
+			k := str(b, pos)
+			d[k] = str(b, pos)
+		}
+		return d
+	}
+
+	translations := map[int][3]int{} // by shape node
+	shapes := map[int]int{}          // model by shape node
+	for pos := 20; pos < len(data); {
+		id := string(data[pos : pos+4])
+		n := int(le.Uint32(data[pos+4:]))
+		content := data[pos+12 : pos+12+n]
+		pos += 12 + n
+		c := 0
+		switch id {
+		case "SIZE":
+			m := &testModel{}
+			for k := range m.size {
+				m.size[k] = i32(content, &c)
+			}
+			f.models = append(f.models, m)
+		case "XYZI":
+			m := f.models[len(f.models)-1]
+			for count := i32(content, &c); count > 0; count-- {
+				m.voxels = append(m.voxels, [4]uint8{content[c], content[c+1], content[c+2], content[c+3]})
+				c += 4
+			}
+		case "nTRN":
+			i32(content, &c)
+			dict(content, &c)
+			child := i32(content, &c)
+			c += 12 // reserved, layer and number of frames
+			var t [3]int
+			fmt.Sscanf(dict(content, &c)["_t"], "%d %d %d", &t[0], &t[1], &t[2])
+			translations[child] = t
+		case "nSHP":
+			node := i32(content, &c)
+			dict(content, &c)
+			i32(content, &c)
+			shapes[node] = i32(content, &c)
+		case "RGBA":
+			for i := range f.palette {
+				f.palette[i] = color.NRGBA{content[4*i], content[4*i+1], content[4*i+2], content[4*i+3]}
+			}
+		}
+	}
+	if len(shapes) != len(f.models) {
+		return nil, fmt.Errorf("got %v shapes for %v models", len(shapes), len(f.models))
+	}
+	for node, model := range shapes {
+		f.models[model].translation = translations[node]
+	}
+	return f, nil
+}
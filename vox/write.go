@@ -0,0 +1,205 @@
+package vox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// version is the VOX file format version written (with a scene graph).
+const version = 150
+
+// write writes the volume to w as a VOX file. Each model is placed by its
+// own transform node, and all the transform nodes belong to one group.
+func write(w io.Writer, v *volume, colors []color.NRGBA) error {
+	var models, nodes chunkBuffer
+	keys := v.modelKeys()
+
+	// Scene graph: root transform (0) -> group (1) -> for each model,
+	// a transform (2+2i) -> a shape (3+2i) holding model i.
+	var root, group chunkBuffer
+	root.int32(0)
+	root.dict(nil)
+	root.int32(1)  // child
+	root.int32(-1) // reserved
+	root.int32(-1) // layer
+	root.int32(1)  // frames
+	root.dict(nil)
+	nodes.chunk("nTRN", root.Bytes(), nil)
+
+	group.int32(1)
+	group.dict(nil)
+	group.int32(int32(len(keys)))
+	for i := range keys {
+		group.int32(int32(2 + 2*i))
+	}
+	nodes.chunk("nGRP", group.Bytes(), nil)
+
+	var label, best []uint8 // resolves voxels claimed by several materials
+	for i, key := range keys {
+		var size [3]int
+		var t [3]int
+		for k := range size {
+			origin := key[k] * maxModelSize
+			size[k] = v.size[k] - origin
+			if size[k] > maxModelSize {
+				size[k] = maxModelSize
+			}
+			// A model is centered on its translation, and the whole
+			// volume is centered on the origin.
+			t[k] = origin + size[k]/2 - v.size[k]/2
+		}
+
+		var claims int
+		for _, m := range v.materials {
+			if len(m[key]) > 0 {
+				claims++
+			}
+		}
+		if claims > 1 && label == nil {
+			label = make([]uint8, maxModelSize*maxModelSize*maxModelSize)
+			best = make([]uint8, len(label))
+		}
+		xyzi := v.modelVoxels(key, claims > 1, label, best)
+
+		var sizeChunk, xyziChunk chunkBuffer
+		sizeChunk.int32(int32(size[0]))
+		sizeChunk.int32(int32(size[1]))
+		sizeChunk.int32(int32(size[2]))
+		models.chunk("SIZE", sizeChunk.Bytes(), nil)
+		xyziChunk.int32(int32(len(xyzi) / 4))
+		xyziChunk.Write(xyzi)
+		models.chunk("XYZI", xyziChunk.Bytes(), nil)
+
+		var trn, shp chunkBuffer
+		trn.int32(int32(2 + 2*i))
+		trn.dict([][2]string{{"_name", fmt.Sprintf("model %v,%v,%v", key[0], key[1], key[2])}})
+		trn.int32(int32(3 + 2*i)) // child
+		trn.int32(-1)             // reserved
+		trn.int32(0)              // layer
+		trn.int32(1)              // frames
+		trn.dict([][2]string{{"_t", fmt.Sprintf("%v %v %v", t[0], t[1], t[2])}})
+		nodes.chunk("nTRN", trn.Bytes(), nil)
+
+		shp.int32(int32(3 + 2*i))
+		shp.dict(nil)
+		shp.int32(1) // models
+		shp.int32(int32(i))
+		shp.dict(nil)
+		nodes.chunk("nSHP", shp.Bytes(), nil)
+	}
+
+	var palette chunkBuffer
+	for i := 1; i <= 256; i++ {
+		c := color.NRGBA{255, 255, 255, 255}
+		if i <= len(colors) {
+			c = colors[i-1]
+		}
+		palette.Write([]byte{c.R, c.G, c.B, c.A}) // entry i-1 is palette index i
+	}
+
+	var children chunkBuffer
+	children.Write(models.Bytes())
+	children.Write(nodes.Bytes())
+	children.chunk("RGBA", palette.Bytes(), nil)
+
+	var file chunkBuffer
+	file.WriteString("VOX ")
+	file.int32(version)
+	file.chunk("MAIN", nil, children.Bytes())
+	if _, err := w.Write(file.Bytes()); err != nil {
+		return fmt.Errorf("write VOX: %v", err)
+	}
+	return nil
+}
+
+// modelKeys returns the keys of the models holding any voxels, in order.
+func (v *volume) modelKeys() [][3]int {
+	seen := map[[3]int]bool{}
+	var keys [][3]int
+	for _, m := range v.materials {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		for i := 2; i >= 0; i-- {
+			if keys[a][i] != keys[b][i] {
+				return keys[a][i] < keys[b][i]
+			}
+		}
+		return false
+	})
+	return keys
+}
+
+// modelVoxels returns the XYZI data of the model. If several materials
+// have voxels in the model, each voxel goes to the material with the
+// greatest coverage, using label and best as scratch space.
+func (v *volume) modelVoxels(key [3]int, resolve bool, label, best []uint8) []byte {
+	index := func(p voxel) int {
+		return (int(p.z)*maxModelSize+int(p.y))*maxModelSize + int(p.x)
+	}
+	if resolve {
+		for n, m := range v.materials {
+			for _, p := range m[key] {
+				if i := index(p); p.coverage > best[i] {
+					label[i], best[i] = uint8(n+1), p.coverage
+				}
+			}
+		}
+	}
+
+	var data []byte
+	for n, m := range v.materials {
+		for _, p := range m[key] {
+			if resolve {
+				i := index(p)
+				if label[i] != uint8(n+1) {
+					continue
+				}
+				label[i], best[i] = 0, 0 // emit each voxel once and clear the scratch space
+			}
+			data = append(data, p.x, p.y, p.z, uint8(n+1))
+		}
+	}
+	return data
+}
+
+// chunkBuffer builds little-endian VOX chunks.
+type chunkBuffer struct {
+	bytes.Buffer
+}
+
+func (b *chunkBuffer) int32(v int32) {
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+func (b *chunkBuffer) string(s string) {
+	b.int32(int32(len(s)))
+	b.WriteString(s)
+}
+
+// dict writes a dictionary of (key, value) pairs.
+func (b *chunkBuffer) dict(pairs [][2]string) {
+	b.int32(int32(len(pairs)))
+	for _, p := range pairs {
+		b.string(p[0])
+		b.string(p[1])
+	}
+}
+
+// chunk writes a chunk with its content and children.
+func (b *chunkBuffer) chunk(id string, content, children []byte) {
+	b.WriteString(id)
+	b.int32(int32(len(content)))
+	b.int32(int32(len(children)))
+	b.Write(content)
+	b.Write(children)
+}
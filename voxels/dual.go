@@ -0,0 +1,357 @@
+package voxels
+
+import (
+	"image"
+	"math"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/stl"
+)
+
+// qefRegularization biases the dual contouring vertex toward the mass point
+// of its cell (in units of voxels), which keeps the solution stable
+// on flat and nearly-flat surfaces.
+const qefRegularization = 0.05
+
+// dualMesher is a streaming dual mesher that places one vertex in each cell
+// (the cube between 8 adjacent voxel centers) that the surface passes through,
+// and then connects the vertices of the 4 cells around every voxel edge
+// that crosses the surface with a quad.
+//
+// With qef unset, this is the surface nets algorithm (each vertex is placed
+// at the mean of its edge crossings). With qef set, this is dual contouring:
+// each vertex is placed to best fit the tangent planes at its edge crossings
+// (using normals estimated from the gradients of the neighboring voxels),
+// which preserves sharp edges and corners.
+//
+// It keeps only four adjacent Z slices in memory. It implements the
+// irmf.ZSliceProcessor interface and expects its slices in irmf.MinToMax order.
+type dualMesher struct {
+	w   mesh.TriWriter
+	qef bool
+	min [3]float32 // MBB of the model
+	max [3]float32
+
+	nx, ny     int // voxels per slice
+	dx, dy, dz float32
+	z0         float32 // z of the first slice
+	numSlices  int
+	numTris    int
+
+	// layers holds the padded voxel values of the slices L-1, L, L+1, and L+2
+	// where L is the cell layer currently being processed.
+	layers [4][]float32
+	// numLayers is the number of layers pushed (including padding).
+	numLayers int
+	// prevCells and curCells hold the vertices of cell layers L-1 and L.
+	prevCells, curCells []dualVertex
+}
+
+// dualVertex is the vertex of a cell (if the surface passes through it).
+type dualVertex struct {
+	ok  bool
+	pos [3]float32
+}
+
+// dualMesher implements the ZSliceProcessor interface.
+var _ irmf.ZSliceProcessor = &dualMesher{}
+
+// newDualMesher returns a new streaming dual mesher
+// for a model with the given MBB.
+func newDualMesher(w mesh.TriWriter, min, max [3]float32, qef bool) *dualMesher {
+	return &dualMesher{w: w, min: min, max: max, qef: qef}
+}
+
+func (m *dualMesher) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	if m.numSlices == 0 {
+		b := img.Bounds()
+		m.nx, m.ny = b.Dx(), b.Dy()
+		m.dx = (m.max[0] - m.min[0]) / float32(m.nx)
+		m.dy = (m.max[1] - m.min[1]) / float32(m.ny)
+		m.dz = 2 * voxelRadius
+		m.z0 = z
+		for i := range m.layers {
+			m.layers[i] = make([]float32, (m.nx+2)*(m.ny+2))
+		}
+		m.prevCells = make([]dualVertex, (m.nx+1)*(m.ny+1))
+		m.curCells = make([]dualVertex, (m.nx+1)*(m.ny+1))
+		// The slices below the model are empty.
+		m.numLayers = 3
+	}
+	m.numSlices++
+
+	next := m.layers[0]
+	sliceValues(img, next, m.nx+2)
+	return m.push(next)
+}
+
+// finish closes the top of the mesh by pushing the empty slices above the model.
+func (m *dualMesher) finish() error {
+	if m.numSlices == 0 {
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		next := m.layers[0]
+		for j := range next {
+			next[j] = 0
+		}
+		if err := m.push(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// push adds the next slice of voxel values (which reuses the storage of
+// the oldest layer), and then generates the vertices of the newly-completed
+// cell layer and the quads that connect them to the previous cell layer.
+func (m *dualMesher) push(next []float32) error {
+	m.layers[0], m.layers[1], m.layers[2], m.layers[3] = m.layers[1], m.layers[2], m.layers[3], next
+	m.numLayers++
+
+	// Sample layer 0 (the first slice) is the 4th layer pushed,
+	// and cell layer L lies between sample layers L and L+1.
+	cellLayer := m.numLayers - 6
+	m.prevCells, m.curCells = m.curCells, m.prevCells
+	m.cellVertices(cellLayer)
+	return m.quads()
+}
+
+// value returns the voxel value at padded coordinates (i,j) of layer l,
+// treating everything beyond the padding as empty.
+func (m *dualMesher) value(l, i, j int) float32 {
+	if l < 0 || l > 3 || i < 0 || j < 0 || i > m.nx+1 || j > m.ny+1 {
+		return 0
+	}
+	return m.layers[l][j*(m.nx+2)+i]
+}
+
+// gradient estimates the gradient of the voxel values (in voxel units)
+// at padded coordinates (i,j) of layer l using central differences.
+func (m *dualMesher) gradient(l, i, j int) [3]float32 {
+	return [3]float32{
+		0.5 * (m.value(l, i+1, j) - m.value(l, i-1, j)),
+		0.5 * (m.value(l, i, j+1) - m.value(l, i, j-1)),
+		0.5 * (m.value(l+1, i, j) - m.value(l-1, i, j)),
+	}
+}
+
+// cellVertices computes the vertices of the cells between layers 1 and 2.
+// Positions are computed in voxel units relative to each cell's origin
+// and then converted to model units.
+func (m *dualMesher) cellVertices(cellLayer int) {
+	x0 := m.min[0] - 0.5*m.dx // center of the padding voxel
+	y0 := m.min[1] - 0.5*m.dy
+	z := m.z0 + float32(cellLayer)*m.dz
+
+	var val [8]float32
+	var grad [8][3]float32
+	var ps, ns [12][3]float32
+	unit := [8][3]float32{}
+	for c, o := range cornerOffsets {
+		unit[c] = [3]float32{float32(o[0]), float32(o[1]), float32(o[2])}
+	}
+
+	for j := 0; j <= m.ny; j++ {
+		for i := 0; i <= m.nx; i++ {
+			v := &m.curCells[j*(m.nx+1)+i]
+			v.ok = false
+
+			var cubeIndex int
+			for c, o := range cornerOffsets {
+				val[c] = m.value(1+o[2], i+o[0], j+o[1])
+				if val[c] < isoLevel {
+					cubeIndex |= 1 << c
+				}
+			}
+			edges := edgeTable[cubeIndex]
+			if edges == 0 {
+				continue
+			}
+
+			var n int
+			var mass [3]float32
+			for e, ec := range edgeCorners {
+				if edges&(1<<e) == 0 {
+					continue
+				}
+				a, b := ec[0], ec[1]
+				ps[n] = vertexInterp(unit[a], unit[b], val[a], val[b])
+				if m.qef {
+					for _, c := range ec {
+						o := cornerOffsets[c]
+						grad[c] = m.gradient(1+o[2], i+o[0], j+o[1])
+					}
+					mu := edgeFraction(ps[n], unit[a], unit[b])
+					ns[n] = normalize(lerp(grad[a], grad[b], mu))
+				}
+				for k := 0; k < 3; k++ {
+					mass[k] += ps[n][k]
+				}
+				n++
+			}
+			for k := 0; k < 3; k++ {
+				mass[k] /= float32(n)
+			}
+
+			p := mass
+			if m.qef {
+				p = solveQEF(ps[:n], ns[:n], mass)
+			}
+			v.ok = true
+			v.pos = [3]float32{
+				x0 + (float32(i)+p[0])*m.dx,
+				y0 + (float32(j)+p[1])*m.dy,
+				z + p[2]*m.dz,
+			}
+		}
+	}
+}
+
+// quads connects the cell vertices around every voxel edge that crosses
+// the surface. The z edges lie between layers 1 and 2 (surrounded by the
+// current cell layer) and the x and y edges lie in layer 1 (surrounded by
+// the previous and current cell layers).
+func (m *dualMesher) quads() error {
+	stride := m.nx + 1
+	cur := func(i, j int) dualVertex { return m.curCells[j*stride+i] }
+	prev := func(i, j int) dualVertex { return m.prevCells[j*stride+i] }
+
+	for j := 1; j <= m.ny; j++ {
+		for i := 1; i <= m.nx; i++ {
+			// z edge from (i,j) in layer 1 to (i,j) in layer 2.
+			if err := m.quad(m.value(1, i, j), m.value(2, i, j), cur(i-1, j-1), cur(i, j-1), cur(i, j), cur(i-1, j)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for j := 1; j <= m.ny; j++ {
+		for i := 0; i <= m.nx; i++ {
+			// x edge from (i,j) to (i+1,j) in layer 1.
+			if err := m.quad(m.value(1, i, j), m.value(1, i+1, j), prev(i, j-1), prev(i, j), cur(i, j), cur(i, j-1)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for j := 0; j <= m.ny; j++ {
+		for i := 1; i <= m.nx; i++ {
+			// y edge from (i,j) to (i,j+1) in layer 1.
+			if err := m.quad(m.value(1, i, j), m.value(1, i, j+1), prev(i-1, j), cur(i-1, j), cur(i, j), prev(i, j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// quad writes the two triangles of a quad if the edge from a value
+// of va to vb crosses the surface. The vertices are ordered counter-clockwise
+// when viewed from the end of the edge at vb.
+func (m *dualMesher) quad(va, vb float32, v1, v2, v3, v4 dualVertex) error {
+	aInside, bInside := va >= isoLevel, vb >= isoLevel
+	if aInside == bInside {
+		return nil
+	}
+	if !v1.ok || !v2.ok || !v3.ok || !v4.ok {
+		return nil // unreachable for a consistent cell classification.
+	}
+	if !aInside {
+		v2, v4 = v4, v2 // the surface faces toward a.
+	}
+	if err := m.writeTri(v1.pos, v2.pos, v3.pos); err != nil {
+		return err
+	}
+	return m.writeTri(v1.pos, v3.pos, v4.pos)
+}
+
+// writeTri writes a triangle (with its normal) to the TriWriter.
+func (m *dualMesher) writeTri(v1, v2, v3 [3]float32) error {
+	m.numTris++
+	t := &stl.Tri{N: triNormal(v1, v2, v3), V1: v1, V2: v2, V3: v3}
+	return m.w.Write(t)
+}
+
+// edgeFraction returns how far p lies along the axis-aligned edge from a to b.
+func edgeFraction(p, a, b [3]float32) float32 {
+	for k := 0; k < 3; k++ {
+		if a[k] != b[k] {
+			return (p[k] - a[k]) / (b[k] - a[k])
+		}
+	}
+	return 0
+}
+
+func lerp(a, b [3]float32, mu float32) [3]float32 {
+	return [3]float32{a[0] + mu*(b[0]-a[0]), a[1] + mu*(b[1]-a[1]), a[2] + mu*(b[2]-a[2])}
+}
+
+func normalize(v [3]float32) [3]float32 {
+	l := float32(math.Sqrt(float64(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])))
+	if l == 0 {
+		return v
+	}
+	return [3]float32{v[0] / l, v[1] / l, v[2] / l}
+}
+
+// solveQEF finds the point (within the unit cell) that minimizes the sum
+// of squared distances to the planes through ps with normals ns, regularized
+// toward the mass point. Planes with zero normals are ignored.
+func solveQEF(ps, ns [][3]float32, mass [3]float32) [3]float32 {
+	// Solve (AᵀA + λI) x = Aᵀb for x relative to the mass point.
+	var ata [3][3]float64
+	var atb [3]float64
+	for i := range ps {
+		n := ns[i]
+		d := float64(n[0]*(ps[i][0]-mass[0]) + n[1]*(ps[i][1]-mass[1]) + n[2]*(ps[i][2]-mass[2]))
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				ata[r][c] += float64(n[r] * n[c])
+			}
+			atb[r] += float64(n[r]) * d
+		}
+	}
+	for r := 0; r < 3; r++ {
+		ata[r][r] += qefRegularization
+	}
+
+	x, ok := solve3(ata, atb)
+	if !ok {
+		return mass
+	}
+	var p [3]float32
+	for k := 0; k < 3; k++ {
+		p[k] = mass[k] + float32(x[k])
+		if p[k] < 0 {
+			p[k] = 0
+		}
+		if p[k] > 1 {
+			p[k] = 1
+		}
+	}
+	return p
+}
+
+// solve3 solves the 3x3 linear system a*x = b using Cramer's rule.
+func solve3(a [3][3]float64, b [3]float64) ([3]float64, bool) {
+	det := func(m [3][3]float64) float64 {
+		return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	}
+	d := det(a)
+	if math.Abs(d) < 1e-12 {
+		return [3]float64{}, false
+	}
+	var x [3]float64
+	for c := 0; c < 3; c++ {
+		m := a
+		for r := 0; r < 3; r++ {
+			m[r][c] = b[r]
+		}
+		x[c] = det(m) / d
+	}
+	return x, true
+}
@@ -0,0 +1,211 @@
+package voxels
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/gmlewis/irmf-slicer/v3/irmf"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/stl"
+)
+
+// isoLevel is the value of the surface between empty (0) and full (1) voxels.
+// Surface vertices are placed where the linearly-interpolated voxel values
+// cross this level.
+const isoLevel = 0.5
+
+var (
+	// cornerOffsets are the (x,y,z) offsets of each cube corner.
+	cornerOffsets = [8][3]int{
+		{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {0, 1, 0},
+		{0, 0, 1}, {1, 0, 1}, {1, 1, 1}, {0, 1, 1},
+	}
+	// edgeCorners are the two cube corners at the ends of each cube edge.
+	edgeCorners = [12][2]int{
+		{0, 1}, {1, 2}, {2, 3}, {3, 0},
+		{4, 5}, {5, 6}, {6, 7}, {7, 4},
+		{0, 4}, {1, 5}, {2, 6}, {3, 7},
+	}
+)
+
+// mcMesher is a streaming marching cubes mesher that keeps only two
+// adjacent Z slices in memory and writes its triangles to a mesh.TriWriter.
+// The model is surrounded by a border of empty voxels so that
+// the resulting mesh is always closed.
+//
+// It implements the irmf.ZSliceProcessor interface and expects
+// its slices in irmf.MinToMax order.
+type mcMesher struct {
+	w   mesh.TriWriter
+	min [3]float32 // MBB of the model
+	max [3]float32
+
+	nx, ny     int // voxels per slice
+	dx, dy, dz float32
+	numSlices  int
+	numTris    int
+
+	// prev and cur hold the voxel values of the previous and current
+	// slices, padded by one empty voxel on every side.
+	prev, cur []float32
+	prevZ     float32
+}
+
+// mcMesher implements the ZSliceProcessor interface.
+var _ irmf.ZSliceProcessor = &mcMesher{}
+
+// newMCMesher returns a new streaming marching cubes mesher
+// for a model with the given MBB.
+func newMCMesher(w mesh.TriWriter, min, max [3]float32) *mcMesher {
+	return &mcMesher{w: w, min: min, max: max}
+}
+
+func (m *mcMesher) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
+	if m.numSlices == 0 {
+		b := img.Bounds()
+		m.nx, m.ny = b.Dx(), b.Dy()
+		m.dx = (m.max[0] - m.min[0]) / float32(m.nx)
+		m.dy = (m.max[1] - m.min[1]) / float32(m.ny)
+		m.dz = 2 * voxelRadius
+		m.prev = make([]float32, (m.nx+2)*(m.ny+2))
+		m.cur = make([]float32, (m.nx+2)*(m.ny+2))
+		m.prevZ = z - m.dz
+	}
+	m.numSlices++
+
+	sliceValues(img, m.cur, m.nx+2)
+	if err := m.march(z); err != nil {
+		return err
+	}
+	m.prev, m.cur = m.cur, m.prev
+	m.prevZ = z
+	return nil
+}
+
+// finish closes the top of the mesh by marching between
+// the last slice and an empty slice.
+func (m *mcMesher) finish() error {
+	if m.numSlices == 0 {
+		return nil
+	}
+	for i := range m.cur {
+		m.cur[i] = 0
+	}
+	return m.march(m.prevZ + m.dz)
+}
+
+// march generates the triangles between the previous slice
+// (at m.prevZ) and the current slice (at z).
+func (m *mcMesher) march(z float32) error {
+	stride := m.nx + 2
+	x0 := m.min[0] - 0.5*m.dx // center of the padding voxel
+	y0 := m.min[1] - 0.5*m.dy
+	zs := [2]float32{m.prevZ, z}
+	slabs := [2][]float32{m.prev, m.cur}
+
+	var val [8]float32
+	var pos [8][3]float32
+	var verts [12][3]float32
+
+	for j := 0; j <= m.ny; j++ {
+		for i := 0; i <= m.nx; i++ {
+			var cubeIndex int
+			for c, o := range cornerOffsets {
+				val[c] = slabs[o[2]][(j+o[1])*stride+i+o[0]]
+				if val[c] < isoLevel {
+					cubeIndex |= 1 << c
+				}
+			}
+			edges := edgeTable[cubeIndex]
+			if edges == 0 {
+				continue
+			}
+
+			for c, o := range cornerOffsets {
+				pos[c] = [3]float32{x0 + float32(i+o[0])*m.dx, y0 + float32(j+o[1])*m.dy, zs[o[2]]}
+			}
+			for e, ec := range edgeCorners {
+				if edges&(1<<e) != 0 {
+					verts[e] = vertexInterp(pos[ec[0]], pos[ec[1]], val[ec[0]], val[ec[1]])
+				}
+			}
+
+			tris := triTable[cubeIndex]
+			for t := 0; tris[t] != -1; t += 3 {
+				if err := m.writeTri(verts[tris[t]], verts[tris[t+1]], verts[tris[t+2]]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeTri writes a triangle (with its normal) to the TriWriter.
+func (m *mcMesher) writeTri(v1, v2, v3 [3]float32) error {
+	m.numTris++
+	t := &stl.Tri{N: triNormal(v1, v2, v3), V1: v1, V2: v2, V3: v3}
+	return m.w.Write(t)
+}
+
+// sliceValues copies the voxel values of img (from 0 for empty
+// to 1 for full) into dst, which is padded by one voxel on every side.
+func sliceValues(img image.Image, dst []float32, stride int) {
+	b := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			row := rgba.Pix[y*rgba.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				dst[(y+1)*stride+x+1] = voxelValue(row[x*4])
+			}
+		}
+		return
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			dst[(y-b.Min.Y+1)*stride+x-b.Min.X+1] = voxelValue(c.Y)
+		}
+	}
+}
+
+// voxelValue converts a pixel value into a voxel value from empty (0)
+// to full (1). Intermediate values (from supersampled slices or from
+// distance-field style shaders) are preserved so that the surface vertices
+// can be interpolated along the cube edges.
+func voxelValue(v uint8) float32 {
+	return float32(v) / 255
+}
+
+// vertexInterp linearly interpolates the position where the isosurface
+// cuts the edge between p1 and p2 which have the values v1 and v2.
+// The result does not depend on the order of the end points, so
+// neighboring cells generate identical vertices for their shared edges.
+func vertexInterp(p1, p2 [3]float32, v1, v2 float32) [3]float32 {
+	if p2[0] < p1[0] || p2[1] < p1[1] || p2[2] < p1[2] {
+		p1, p2, v1, v2 = p2, p1, v2, v1
+	}
+	if v1 == v2 {
+		return p1
+	}
+	mu := (isoLevel - v1) / (v2 - v1)
+	return [3]float32{
+		p1[0] + mu*(p2[0]-p1[0]),
+		p1[1] + mu*(p2[1]-p1[1]),
+		p1[2] + mu*(p2[2]-p1[2]),
+	}
+}
+
+// triNormal returns the unit normal of the triangle using the right-hand rule.
+func triNormal(v1, v2, v3 [3]float32) [3]float32 {
+	ax, ay, az := v2[0]-v1[0], v2[1]-v1[1], v2[2]-v1[2]
+	bx, by, bz := v3[0]-v1[0], v3[1]-v1[1], v3[2]-v1[2]
+	nx, ny, nz := ay*bz-az*by, az*bx-ax*bz, ax*by-ay*bx
+	l := float32(math.Sqrt(float64(nx*nx + ny*ny + nz*nz)))
+	if l == 0 {
+		return [3]float32{}
+	}
+	return [3]float32{nx / l, ny / l, nz / l}
+}
@@ -0,0 +1,170 @@
+package voxels
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/gmlewis/irmf-slicer/v3/stl"
+)
+
+// triCollector collects the triangles written to it.
+type triCollector struct {
+	tris []stl.Tri
+}
+
+func (c *triCollector) Write(t *stl.Tri) error {
+	c.tris = append(c.tris, *t)
+	return nil
+}
+
+// solidSlices returns n square slices of size x size pixels with
+// the pixels from lo to hi (inclusive) filled in slices lo to hi.
+func solidSlices(n, size, lo, hi int) []image.Image {
+	var imgs []image.Image
+	for z := 0; z < n; z++ {
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		for y := lo; z >= lo && z <= hi && y <= hi; y++ {
+			for x := lo; x <= hi; x++ {
+				img.Set(x, y, color.White)
+			}
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+// checkClosedMesh verifies that every directed edge of the triangles
+// is matched by exactly one edge in the opposite direction,
+// and returns the signed volume of the mesh.
+func checkClosedMesh(t *testing.T, tris []stl.Tri) float64 {
+	t.Helper()
+	type edge struct{ a, b [3]float32 }
+	edges := map[edge]int{}
+	var volume float64
+	for _, tri := range tris {
+		vs := [3][3]float32{tri.V1, tri.V2, tri.V3}
+		for i := 0; i < 3; i++ {
+			edges[edge{vs[i], vs[(i+1)%3]}]++
+		}
+		a, b, c := vs[0], vs[1], vs[2]
+		volume += float64(a[0]*(b[1]*c[2]-b[2]*c[1])-a[1]*(b[0]*c[2]-b[2]*c[0])+a[2]*(b[0]*c[1]-b[1]*c[0])) / 6
+	}
+	for e, n := range edges {
+		if n != 1 || edges[edge{e.b, e.a}] != 1 {
+			t.Fatalf("edge %v appears %v times, opposite edge appears %v times", e, n, edges[edge{e.b, e.a}])
+		}
+	}
+	return volume
+}
+
+func TestMCMesher(t *testing.T) {
+	// A 3x3x3 voxel cube in the middle of a 5x5x5 model with 1mm voxels.
+	c := &triCollector{}
+	m := newMCMesher(c, [3]float32{0, 0, 0}, [3]float32{5, 5, 5})
+	for n, img := range solidSlices(5, 5, 1, 3) {
+		if err := m.ProcessZSlice(n, float32(n)+0.5, 0.5, img); err != nil {
+			t.Fatalf("ProcessZSlice(%v): %v", n, err)
+		}
+	}
+	if err := m.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	if len(c.tris) == 0 {
+		t.Fatal("no triangles generated")
+	}
+	if m.numTris != len(c.tris) {
+		t.Errorf("numTris = %v, want %v", m.numTris, len(c.tris))
+	}
+	// The surface passes halfway between the voxel centers,
+	// with chamfered edges and corners.
+	if v := checkClosedMesh(t, c.tris); v < 20 || v > 27 {
+		t.Errorf("volume = %v, want between 20 and 27", v)
+	}
+	for _, tri := range c.tris {
+		for _, v := range [][3]float32{tri.V1, tri.V2, tri.V3} {
+			for i := 0; i < 3; i++ {
+				if v[i] < 1 || v[i] > 4 {
+					t.Fatalf("vertex %v is outside of the expected MBB", v)
+				}
+			}
+		}
+	}
+}
+
+func TestMCMesherInterpolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		gray  uint8
+		wantX float32 // x of the +X face of the solid
+	}{
+		{name: "full", gray: 255, wantX: 5},
+		{name: "three-quarters", gray: 191, wantX: 4.833},
+		{name: "half", gray: 128, wantX: 4.504},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A 4x4x4 solid whose +X face voxels have the given value,
+			// in a 6x6x6 model with 1mm voxels.
+			c := &triCollector{}
+			m := newMCMesher(c, [3]float32{0, 0, 0}, [3]float32{6, 6, 6})
+			for n, img := range solidSlices(6, 6, 1, 4) {
+				rgba := img.(*image.RGBA)
+				for y := 1; n >= 1 && n <= 4 && y <= 4; y++ {
+					rgba.Set(4, y, color.Gray{Y: tt.gray})
+				}
+				if err := m.ProcessZSlice(n, float32(n)+0.5, 0.5, img); err != nil {
+					t.Fatalf("ProcessZSlice(%v): %v", n, err)
+				}
+			}
+			if err := m.finish(); err != nil {
+				t.Fatalf("finish: %v", err)
+			}
+			checkClosedMesh(t, c.tris)
+
+			var maxX float32
+			for _, tri := range c.tris {
+				for _, v := range [][3]float32{tri.V1, tri.V2, tri.V3} {
+					if v[0] > maxX {
+						maxX = v[0]
+					}
+				}
+			}
+			if d := maxX - tt.wantX; d < -0.01 || d > 0.01 {
+				t.Errorf("max x = %v, want %v", maxX, tt.wantX)
+			}
+		})
+	}
+}
+
+func TestDualMesher(t *testing.T) {
+	volumes := map[bool]float64{}
+	for _, qef := range []bool{false, true} {
+		c := &triCollector{}
+		m := newDualMesher(c, [3]float32{0, 0, 0}, [3]float32{6, 6, 6}, qef)
+		for n, img := range solidSlices(6, 6, 1, 4) {
+			if err := m.ProcessZSlice(n, float32(n)+0.5, 0.5, img); err != nil {
+				t.Fatalf("ProcessZSlice(%v): %v", n, err)
+			}
+		}
+		if err := m.finish(); err != nil {
+			t.Fatalf("finish: %v", err)
+		}
+
+		if len(c.tris) == 0 {
+			t.Fatalf("qef=%v: no triangles generated", qef)
+		}
+		volumes[qef] = checkClosedMesh(t, c.tris)
+	}
+
+	// The faces of the 4x4x4 voxel cube lie halfway between voxel centers,
+	// and dual contouring recovers more of its sharp edges and corners.
+	if v := volumes[false]; v < 40 || v > 64 {
+		t.Errorf("surface nets volume = %v, want between 40 and 64", v)
+	}
+	if v := volumes[true]; v <= volumes[false] || v > 64.01 {
+		t.Errorf("dual contouring volume = %v, want between %v and 64", v, volumes[false])
+	}
+}
@@ -2,14 +2,16 @@
 package voxels
 
 import (
+	"encoding/json"
 	"fmt"
-	"image"
-	"image/color"
+	"io/ioutil"
 	"log"
+	"math"
 	"strings"
 
 	"github.com/gmlewis/irmf-slicer/v3/irmf"
-	"github.com/gmlewis/stldice/v4/binvox"
+	"github.com/gmlewis/irmf-slicer/v3/mesh"
+	"github.com/gmlewis/irmf-slicer/v3/stl"
 )
 
 // Slicer represents a slicer that provides slices of voxels for multiple
@@ -30,70 +32,419 @@ type Slicer interface {
 	NumZSlices() int
 }
 
-// Slice slices an IRMF model into one or more STL files (one per material).
+// Mesher represents an algorithm that converts voxels into a mesh.
+type Mesher int
+
+const (
+	// MarchingCubes generates smooth meshes but rounds off sharp features.
+	MarchingCubes Mesher = iota
+	// SurfaceNets places one vertex per surface cell at the mean
+	// of its edge crossings and generates fewer, more regular triangles.
+	SurfaceNets
+	// DualContouring places one vertex per surface cell to best fit the
+	// estimated tangent planes of the surface, which preserves sharp
+	// edges and corners.
+	DualContouring
+)
+
+// ParseMesher returns the Mesher with the given name
+// ("mc", "surfacenets", or "dc").
+func ParseMesher(name string) (Mesher, error) {
+	switch strings.ToLower(name) {
+	case "mc", "marchingcubes", "":
+		return MarchingCubes, nil
+	case "sn", "surfacenets":
+		return SurfaceNets, nil
+	case "dc", "dualcontouring":
+		return DualContouring, nil
+	}
+	return 0, fmt.Errorf("unknown mesher %q; must be one of: mc, surfacenets, dc", name)
+}
+
+func (m Mesher) String() string {
+	switch m {
+	case MarchingCubes:
+		return "marching cubes"
+	case SurfaceNets:
+		return "surface nets"
+	case DualContouring:
+		return "dual contouring"
+	}
+	return fmt.Sprintf("Mesher(%d)", int(m))
+}
+
+// Options represents the options used to convert voxels into meshes.
+// The zero value uses the defaults.
+type Options struct {
+	Mesher Mesher
+	// ASCII writes ASCII STL files instead of binary STL files.
+	ASCII bool
+
+	// MaxTriangles (if non-zero) limits the number of triangles in each mesh.
+	MaxTriangles int
+	// MaxBytes (if non-zero) limits the size of each STL file.
+	MaxBytes int64
+	// MaxError (if non-zero) is the unitless quadric error threshold
+	// at which the simplification of each mesh stops.
+	// See mesh.SimplifyOptions.
+	MaxError float64
+
+	// Smooth (if non-zero) is the number of smoothing passes applied to each
+	// mesh to remove stair-stepping, using Taubin smoothing by default.
+	Smooth int
+	// SmoothLaplacian uses Laplacian (rather than Taubin) smoothing.
+	SmoothLaplacian bool
+	// SmoothMaxDisplacement (if non-zero) limits the distance that smoothing
+	// may move any vertex, in units of the voxel size.
+	SmoothMaxDisplacement float64
+	// SmoothPreserveVolume keeps the volume of each body constant while smoothing.
+	SmoothPreserveVolume bool
+
+	// SplitBodies writes each disconnected body (with its cavities) to its
+	// own numbered STL file, plus a JSON index listing the volume and
+	// MBB of each body.
+	SplitBodies bool
+
+	// Validate checks each mesh for holes, non-manifold edges,
+	// inconsistent orientation and degenerate triangles, and writes
+	// a JSON report next to each STL file.
+	Validate bool
+	// Repair removes degenerate and duplicate triangles and fixes the
+	// orientation of each mesh before it is written (and validated).
+	Repair bool
+}
+
+// provenancer is implemented by slicers (such as *irmf.Slicer) that can
+// describe the model and settings used to slice each material.
+type provenancer interface {
+	Provenance(materialNum int) string
+}
+
+// newSTL creates an STL file writer for the material, recording
+// its provenance (if known) in the STL header.
+func (o *Options) newSTL(stlFile string, slicer Slicer, materialNum int) (*stl.Client, error) {
+	var w *stl.Client
+	var err error
+	if o.ASCII {
+		w, err = stl.NewASCII(stlFile, slicer.MaterialName(materialNum))
+	} else {
+		w, err = stl.New(stlFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := slicer.(provenancer); ok {
+		if header := p.Provenance(materialNum); header != "" {
+			if err := w.SetHeader(header); err != nil {
+				w.Close()
+				return nil, err
+			}
+		}
+	}
+	return w, nil
+}
+
+// inMemory reports whether the meshes must be built in memory
+// (rather than streamed) to apply the options.
+func (o *Options) inMemory() bool {
+	_, simplify := o.simplifyOptions()
+	return simplify || o.Smooth > 0 || o.SplitBodies || o.Validate || o.Repair
+}
+
+// voxelSize returns the smallest dimension of a voxel (in millimeters).
+func voxelSize(slicer Slicer) float32 {
+	min, max := slicer.MBB()
+	size := float32(math.Inf(1))
+	for i, n := range []int{slicer.NumXSlices(), slicer.NumYSlices(), slicer.NumZSlices()} {
+		if n > 0 {
+			if d := (max[i] - min[i]) / float32(n); d < size {
+				size = d
+			}
+		}
+	}
+	return size
+}
+
+// simplifyOptions returns the mesh simplification options
+// and whether the meshes should be simplified at all.
+func (o *Options) simplifyOptions() (mesh.SimplifyOptions, bool) {
+	so := mesh.SimplifyOptions{TargetTris: o.MaxTriangles, MaxError: o.MaxError}
+	if o.MaxBytes > 0 {
+		if n := mesh.TrisForSTLSize(o.MaxBytes); so.TargetTris == 0 || n < so.TargetTris {
+			so.TargetTris = n
+		}
+	}
+	return so, so.TargetTris > 0 || so.MaxError > 0
+}
+
+// streamingMesher represents a mesher that consumes Z slices
+// (in irmf.MinToMax order) and writes triangles as it goes.
+type streamingMesher interface {
+	irmf.ZSliceProcessor
+	// finish writes any remaining triangles after the last slice.
+	finish() error
+	// triangles returns the number of triangles written.
+	triangles() int
+}
+
+func (m *mcMesher) triangles() int   { return m.numTris }
+func (m *dualMesher) triangles() int { return m.numTris }
+
+// newMesher returns a new streaming mesher for a model with the given MBB.
+func (o *Options) newMesher(w mesh.TriWriter, min, max [3]float32) streamingMesher {
+	switch o.Mesher {
+	case SurfaceNets:
+		return newDualMesher(w, min, max, false)
+	case DualContouring:
+		return newDualMesher(w, min, max, true)
+	default:
+		return newMCMesher(w, min, max)
+	}
+}
+
+// Slice slices an IRMF model into one or more STL files (one per material)
+// using the default options.
 func Slice(baseFilename string, slicer Slicer) error {
+	return SliceWithOptions(baseFilename, slicer, nil)
+}
+
+// SliceWithOptions slices an IRMF model into one or more STL files
+// (one per material). opts may be nil.
+func SliceWithOptions(baseFilename string, slicer Slicer, opts *Options) error {
 	for materialNum := 1; materialNum <= slicer.NumMaterials(); materialNum++ {
 		materialName := strings.ReplaceAll(slicer.MaterialName(materialNum), " ", "-")
 
 		stlFile := fmt.Sprintf("%v-mat%02d-%v.stl", baseFilename, materialNum, materialName)
+		if _, err := SliceMaterial(stlFile, slicer, materialNum, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		min, max := slicer.MBB()
-		scale := float64(max[2] - min[2])
-		model := binvox.New(
-			slicer.NumXSlices(),
-			slicer.NumYSlices(),
-			slicer.NumZSlices(),
-			float64(min[0]),
-			float64(min[1]),
-			float64(min[2]),
-			scale,
-			false,
-		)
-
-		c := &client{model: model, slicer: slicer}
-
-		log.Printf("Rendering...")
-		if err := slicer.PrepareRenderZ(); err != nil {
-			return fmt.Errorf("PrepareRenderZ: %v", err)
+// SliceMaterial slices a single material of an IRMF model into an STL file
+// and returns the number of triangles written. opts may be nil.
+//
+// The STL file is generated by a streaming mesher that keeps only
+// a few adjacent slices in memory, so memory usage is proportional
+// to the size of a slice rather than the volume of the model.
+// If the options call for simplification, however, the mesh is
+// built in memory, simplified, and then written.
+func SliceMaterial(stlFile string, slicer Slicer, materialNum int, opts *Options) (int, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	log.Printf("Rendering...")
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return 0, fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+
+	if opts.inMemory() {
+		return sliceInMemory(stlFile, slicer, materialNum, opts)
+	}
+
+	log.Printf("Writing: %v", stlFile)
+	w, err := opts.newSTL(stlFile, slicer, materialNum)
+	if err != nil {
+		return 0, fmt.Errorf("stl.New: %v", err)
+	}
+
+	min, max := slicer.MBB()
+	m := opts.newMesher(w, min, max)
+	if err := slicer.RenderZSlices(materialNum, m, irmf.MinToMax); err != nil {
+		w.Close()
+		return 0, fmt.Errorf("RenderZSlices: %v", err)
+	}
+	if err := m.finish(); err != nil {
+		w.Close()
+		return 0, fmt.Errorf("%v: %v", opts.Mesher, err)
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("stl.Close: %v", err)
+	}
+	log.Printf("Wrote %v triangles (%v) to %v", m.triangles(), opts.Mesher, stlFile)
+	return m.triangles(), nil
+}
+
+// buildMesh renders the material into an in-memory mesh.
+func buildMesh(slicer Slicer, materialNum int, opts *Options) (*mesh.Mesh, error) {
+	b := mesh.NewBuilder()
+	min, max := slicer.MBB()
+	m := opts.newMesher(b, min, max)
+	if err := slicer.RenderZSlices(materialNum, m, irmf.MinToMax); err != nil {
+		return nil, fmt.Errorf("RenderZSlices: %v", err)
+	}
+	if err := m.finish(); err != nil {
+		return nil, fmt.Errorf("%v: %v", opts.Mesher, err)
+	}
+	return b.Mesh(), nil
+}
+
+// Mesh renders the material (1-based) into an in-memory mesh and applies
+// the repair, smoothing and simplification requested by opts (which may be nil),
+// logging the validation of the mesh if requested.
+// It is used by the writers that package several materials into one file.
+func Mesh(slicer Slicer, materialNum int, opts *Options) (*mesh.Mesh, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := slicer.PrepareRenderZ(); err != nil {
+		return nil, fmt.Errorf("PrepareRenderZ: %v", err)
+	}
+	m, repair, err := processMesh(slicer, materialNum, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Validate || opts.Repair {
+		r := m.Validate()
+		r.Repair = repair
+		log.Printf("Validation of material %v (%v): %v", materialNum, slicer.MaterialName(materialNum), r)
+	}
+	return m, nil
+}
+
+// processMesh meshes the material in memory, then repairs, smooths
+// and simplifies the mesh as requested by opts.
+func processMesh(slicer Slicer, materialNum int, opts *Options) (*mesh.Mesh, *mesh.RepairReport, error) {
+	m, err := buildMesh(slicer, materialNum, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var repair *mesh.RepairReport
+	if opts.Repair {
+		repair = m.Repair()
+	}
+
+	if opts.Smooth > 0 {
+		smooth := mesh.SmoothOptions{
+			Iterations:     opts.Smooth,
+			Laplacian:      opts.SmoothLaplacian,
+			PreserveVolume: opts.SmoothPreserveVolume,
+		}
+		if opts.SmoothMaxDisplacement > 0 {
+			smooth.MaxDisplacement = float32(opts.SmoothMaxDisplacement) * voxelSize(slicer)
 		}
+		log.Printf("Smoothing %v triangles (%v passes)...", len(m.Tris), opts.Smooth)
+		m.Smooth(smooth)
+	}
 
-		if err := slicer.RenderZSlices(materialNum, c, irmf.MaxToMin); err != nil {
-			return fmt.Errorf("RenderZSlices: %v", err)
+	if so, ok := opts.simplifyOptions(); ok {
+		orig := &mesh.Mesh{
+			Verts: append([][3]float32(nil), m.Verts...),
+			Tris:  append([][3]int(nil), m.Tris...),
+		}
+		m.Simplify(so)
+		log.Printf("Simplified %v triangles (%v) to %v triangles; Hausdorff deviation: %.4fmm",
+			len(orig.Tris), opts.Mesher, len(m.Tris), mesh.Hausdorff(orig, m))
+		if so.TargetTris > 0 && len(m.Tris) > so.TargetTris {
+			log.Printf("WARNING: could not reach the target of %v triangles", so.TargetTris)
 		}
+	}
+	return m, repair, nil
+}
+
+// sliceInMemory meshes and processes the material in memory,
+// then splits and validates the mesh (as requested by opts)
+// and writes the STL file(s).
+func sliceInMemory(stlFile string, slicer Slicer, materialNum int, opts *Options) (int, error) {
+	m, repair, err := processMesh(slicer, materialNum, opts)
+	if err != nil {
+		return 0, err
+	}
 
-		log.Printf("Converting to STL...")
-		mesh := model.MarchingCubes()
-		log.Printf("Writing: %v", stlFile)
-		if err := mesh.SaveSTL(stlFile); err != nil {
-			log.Fatalf("SaveSTL: %v", err)
+	if !opts.SplitBodies {
+		if err := writeMesh(stlFile, m, slicer, materialNum, opts, repair); err != nil {
+			return 0, err
 		}
+		return len(m.Tris), nil
 	}
 
-	return nil
+	bodies := m.Bodies()
+	log.Printf("Found %v separate bodies", len(bodies))
+	base := strings.TrimSuffix(stlFile, ".stl")
+	var index []bodyInfo
+	for i, body := range bodies {
+		bodyFile := fmt.Sprintf("%v-body%03d.stl", base, i+1)
+		if err := writeMesh(bodyFile, body, slicer, materialNum, opts, repair); err != nil {
+			return 0, err
+		}
+		min, max := body.Bounds()
+		index = append(index, bodyInfo{
+			Body:      i + 1,
+			STL:       bodyFile,
+			Triangles: len(body.Tris),
+			Volume:    body.Volume(),
+			Min:       min,
+			Max:       max,
+		})
+	}
+	if err := writeJSON(base+"-bodies.json", index); err != nil {
+		return 0, err
+	}
+	return len(m.Tris), nil
 }
 
-// client represents a voxels-to-STL converter.
-// It implements the irmf.SliceProcessor interface.
-type client struct {
-	model  *binvox.BinVOX
-	slicer Slicer
+// bodyInfo describes one of the bodies written by the SplitBodies option.
+type bodyInfo struct {
+	Body      int        `json:"body"`
+	STL       string     `json:"stl"`
+	Triangles int        `json:"triangles"`
+	Volume    float64    `json:"volume"` // cubic millimeters
+	Min       [3]float32 `json:"min"`    // MBB in millimeters
+	Max       [3]float32 `json:"max"`
 }
 
-// client implements the ZSliceProcessor interface.
-var _ irmf.ZSliceProcessor = &client{}
+// writeMesh writes the mesh to an STL file and validates it (if requested).
+func writeMesh(stlFile string, m *mesh.Mesh, slicer Slicer, materialNum int, opts *Options, repair *mesh.RepairReport) error {
+	log.Printf("Writing: %v", stlFile)
+	w, err := opts.newSTL(stlFile, slicer, materialNum)
+	if err != nil {
+		return fmt.Errorf("stl.New: %v", err)
+	}
+	if err := m.WriteTris(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("stl.Close: %v", err)
+	}
+	log.Printf("Wrote %v triangles to %v", len(m.Tris), stlFile)
 
-func (c *client) ProcessZSlice(sliceNum int, z, voxelRadius float32, img image.Image) error {
-	scanImage(img, c.model, sliceNum)
+	if opts.Validate || opts.Repair {
+		r := m.Validate()
+		r.Repair = repair
+		log.Printf("Validation: %v", r)
+		if err := writeReport(stlFile, r); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func scanImage(img image.Image, model *binvox.BinVOX, z int) {
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			c := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
-			if c.Y >= 128 {
-				model.Add(x, y, z)
-			}
-		}
+// writeReport writes the validation report for stlFile
+// to a JSON file next to it.
+func writeReport(stlFile string, r *mesh.Report) error {
+	return writeJSON(strings.TrimSuffix(stlFile, ".stl")+"-report.json", struct {
+		STL        string `json:"stl"`
+		Watertight bool   `json:"watertight"`
+		*mesh.Report
+	}{stlFile, r.Watertight(), r})
+}
+
+// writeJSON writes v to an indented JSON file.
+func writeJSON(filename string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %v", err)
+	}
+	log.Printf("Writing: %v", filename)
+	if err := ioutil.WriteFile(filename, append(buf, '\n'), 0644); err != nil {
+		return fmt.Errorf("WriteFile: %v", err)
 	}
+	return nil
 }